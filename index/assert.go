@@ -0,0 +1,148 @@
+package index
+
+import (
+	"fmt"
+	"math"
+
+	"wtfDB/memory"
+)
+
+/*
+debugAssertions enables internal invariant checks after every structural mutation of a
+node: its keys are sorted, its size is within bounds and, after a split, the sibling link
+and key ranges of the two halves are valid. A violated invariant panics at the mutation
+that caused it, instead of surfacing much later as a failed lookup.
+
+The checks are meant for development builds and tests, and are disabled by default. When
+disabled, every check reduces to a single branch on this flag.
+*/
+var debugAssertions bool
+
+// SetDebugAssertions enables or disables the internal invariant checks of the index package.
+func SetDebugAssertions(enabled bool) {
+	debugAssertions = enabled
+}
+
+// Checks the invariants of node after a mutation at site, and panics when one is violated.
+func assertNode(site string, node BPlusTreeNode) {
+	if !debugAssertions {
+		return
+	}
+	if err := checkNodeInvariants(node); err != nil {
+		panic(fmt.Sprintf("%s: page %d: %v", site, node.getPageId(), err))
+	}
+}
+
+// Checks the invariants of both halves of a split at site: the left node links to the right
+// node, and every key of the left node sorts before every key of the right node.
+func assertSplit(site string, left BPlusTreeNode, right BPlusTreeNode, separator int) {
+	if !debugAssertions {
+		return
+	}
+	assertNode(site, left)
+	assertNode(site, right)
+	if sibling := rightSiblingOf(left); sibling != right.getPageId() {
+		panic(fmt.Sprintf("%s: page %d: right sibling is %d, expected the new node %d", site, left.getPageId(), sibling, right.getPageId()))
+	}
+	compare := nodeMetadata(left).compare
+	if last, ok := lastKey(left); ok && compare(last, separator) >= 0 {
+		panic(fmt.Sprintf("%s: page %d: key %d does not sort before the split key %d", site, left.getPageId(), last, separator))
+	}
+	if first, ok := right.getSeparatorKey(); ok && compare(first, separator) < 0 {
+		panic(fmt.Sprintf("%s: page %d: key %d sorts before the split key %d", site, right.getPageId(), first, separator))
+	}
+}
+
+func checkNodeInvariants(node BPlusTreeNode) error {
+	if node.getSize() > node.getMaxSize() {
+		return fmt.Errorf("size %d exceeds max size %d", node.getSize(), node.getMaxSize())
+	}
+	var keys []int
+	switch n := node.(type) {
+	case *leafNode:
+		if len(n.keys) != len(n.recordIds) {
+			return fmt.Errorf("%d keys but %d record ids", len(n.keys), len(n.recordIds))
+		}
+		if n.treeMetadata.insertSequence && len(n.keys) != len(n.sequences) {
+			return fmt.Errorf("%d keys but %d sequence numbers", len(n.keys), len(n.sequences))
+		}
+		if n.treeMetadata.inlineSize > 0 && len(n.keys) != len(n.inline) {
+			return fmt.Errorf("%d keys but %d inline values", len(n.keys), len(n.inline))
+		}
+		keys = n.keys
+	case *innerNode:
+		if len(n.keys) != len(n.children) {
+			return fmt.Errorf("%d keys but %d children", len(n.keys), len(n.children))
+		}
+		if len(n.keys) == 0 || n.keys[0] != math.MinInt {
+			return fmt.Errorf("first key is not the invalid key")
+		}
+		keys = n.keys[1:]
+	}
+	compare := nodeMetadata(node).compare
+	for i := 1; i < len(keys); i++ {
+		if compare(keys[i-1], keys[i]) >= 0 {
+			return fmt.Errorf("keys are not strictly increasing: %d before %d", keys[i-1], keys[i])
+		}
+	}
+	return nil
+}
+
+func nodeMetadata(node BPlusTreeNode) *BPlusTreeMetadata {
+	switch n := node.(type) {
+	case *leafNode:
+		return n.treeMetadata
+	case *innerNode:
+		return n.treeMetadata
+	}
+	return nil
+}
+
+// Returns the last (largest) key of a node, skipping the invalid first key of an inner node.
+func lastKey(node BPlusTreeNode) (int, bool) {
+	switch n := node.(type) {
+	case *leafNode:
+		if len(n.keys) > 0 {
+			return n.keys[len(n.keys)-1], true
+		}
+	case *innerNode:
+		if len(n.keys) > 1 {
+			return n.keys[len(n.keys)-1], true
+		}
+	}
+	return InvalidKey, false
+}
+
+/*
+Checks at site that the page a split is about to link into its parent (or a new root) holds
+a complete node: a leaf, or an inner node with at least one child. A child page is always
+written before the pointer to it is added, see Insert, so a reader that follows the new
+pointer never finds a page that was never written (a zeroed page reads as an empty inner node).
+*/
+func assertLinkedChild(site string, b *memory.BufferPoolManager, m *BPlusTreeMetadata, pageId int) {
+	if !debugAssertions {
+		return
+	}
+	f, err := b.GetPage(pageId)
+	if err != nil {
+		panic(fmt.Sprintf("%s: unable to read child page %d: %v", site, pageId, err))
+	}
+	defer b.Unpin(f)
+	switch pageType := getPageType(f); pageType {
+	case 1: // Leaf node
+		leaf := &leafNode{treeMetadata: m, bufferManager: b, frame: f}
+		if _, err := leaf.fromBytes(f.Data); err != nil {
+			panic(fmt.Sprintf("%s: child page %d: %v", site, pageId, err))
+		}
+	case 0: // Inner node
+		inner := &innerNode{treeMetadata: m, bufferManager: b, frame: f}
+		if _, err := inner.fromBytes(f.Data); err != nil {
+			panic(fmt.Sprintf("%s: child page %d: %v", site, pageId, err))
+		}
+		if len(inner.children) == 0 {
+			panic(fmt.Sprintf("%s: child page %d is an inner node without children", site, pageId))
+		}
+	default:
+		panic(fmt.Sprintf("%s: child page %d has unknown page type %d", site, pageId, pageType))
+	}
+}
@@ -0,0 +1,49 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func Test_debugAssertions(t *testing.T) {
+	SetDebugAssertions(true)
+	t.Cleanup(func() { SetDebugAssertions(false) })
+
+	// a correct tree passes every check
+	tree := newTestTree(t, 16)
+	for _, k := range rand.New(rand.NewSource(47)).Perm(300) {
+		tree.Insert(k, k)
+	}
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+
+	// a leaf whose keys were left out of order is caught by the next insert into it
+	broken := newTestTree(t, 4)
+	leaf := broken.Root.(*leafNode)
+	leaf.keys = []int{30, 10}
+	leaf.recordIds = []int{3, 1}
+	msg := recoverPanic(func() { broken.Insert(50, 5) })
+	assertEqual(t, true, strings.HasPrefix(msg, "leaf insert:"), msg)
+	assertEqual(t, true, strings.Contains(msg, "not strictly increasing"), msg)
+
+	// a split whose halves do not link up is caught at the split
+	broken = newTestTree(t, 4)
+	for k := range broken.Order() {
+		broken.Insert(k, k)
+	}
+	broken.Root.(*leafNode).keys[0] = 100
+	msg = recoverPanic(func() { broken.Insert(50, 5) })
+	assertEqual(t, true, strings.HasPrefix(msg, "leaf split:"), msg)
+}
+
+// Runs fn and returns the message it panicked with, or "" if it returned normally.
+func recoverPanic(fn func()) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg = fmt.Sprint(r)
+		}
+	}()
+	fn()
+	return ""
+}
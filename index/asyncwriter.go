@@ -0,0 +1,176 @@
+package index
+
+import (
+	"slices"
+	"sync"
+)
+
+/*
+AsyncWriter is a write-behind front end for a B+ tree, intended for write-heavy workloads
+that can relax durability.
+
+Insert enqueues the key/value pair onto an in-memory apply queue and returns immediately.
+A background goroutine applies queued inserts to the tree in the order they were enqueued.
+Sync blocks until the queue has been drained. The queue is bounded, which bounds how far the
+tree lags behind the writes: once the queue holds its max length (DefaultMaxQueueLength,
+see WithMaxQueueLength), Insert blocks until the background goroutine has applied an insert.
+
+This trades guarantees for throughput:
+  - An insert that has returned is not yet in the tree, and its outcome (eg. a rejected
+    duplicate key) is never reported back to the caller.
+  - Queued inserts are lost if the process exits before they are applied. Even after Sync,
+    pages are only durable once the buffer pool flushes them to disk.
+  - Get does not observe queued inserts unless readYourWrites is enabled, in which case
+    the queue is consulted for keys that are not (yet) in the tree. For a tree that
+    overwrites existing keys (see WithOverwrite), the latest queued insert of a key wins
+    over the tree instead.
+
+All access to the tree must go through the writer once it has been created.
+*/
+type AsyncWriter struct {
+	tree           *bPlusTree
+	readYourWrites bool
+	maxQueue       int // the max number of queued inserts, Insert blocks while the queue is full
+
+	treeLock sync.Mutex // serializes access to the tree
+
+	mu      sync.Mutex // guards queue and closed
+	cond    *sync.Cond // signalled when the queue changes or the writer is closed
+	queue   []kvPair   // inserts that have not been applied yet, in enqueue order
+	closed  bool
+	stopped chan struct{} // closed when the background goroutine exits
+}
+
+type kvPair struct {
+	key   int
+	value int
+}
+
+// DefaultMaxQueueLength is the max number of queued inserts of an AsyncWriter by default.
+const DefaultMaxQueueLength = 1024
+
+// AsyncWriterOption configures an AsyncWriter.
+type AsyncWriterOption func(*AsyncWriter)
+
+// WithMaxQueueLength bounds the apply queue of the writer to n inserts; Insert blocks while
+// n inserts are queued. A max length below 1 is raised to 1.
+func WithMaxQueueLength(n int) AsyncWriterOption {
+	return func(w *AsyncWriter) {
+		w.maxQueue = max(n, 1)
+	}
+}
+
+// Creates a new asynchronous writer for the given tree and starts its background apply goroutine.
+// When readYourWrites is true, Get also consults inserts that are still queued.
+func NewAsyncWriter(t *bPlusTree, readYourWrites bool, opts ...AsyncWriterOption) *AsyncWriter {
+	w := &AsyncWriter{
+		tree:           t,
+		readYourWrites: readYourWrites,
+		maxQueue:       DefaultMaxQueueLength,
+		stopped:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// Enqueues a k,v pair to be inserted into the tree and returns without waiting for it to be applied.
+// Blocks while the queue is full. Returns false if the writer has been closed.
+func (w *AsyncWriter) Insert(k int, v int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.queue) >= w.maxQueue && !w.closed {
+		w.cond.Wait()
+	}
+	if w.closed {
+		return false
+	}
+	w.queue = append(w.queue, kvPair{key: k, value: v})
+	w.cond.Broadcast()
+	return true
+}
+
+// Return the value associated with a given key. Inserts that are still queued are only
+// visible when the writer was created with readYourWrites enabled.
+func (w *AsyncWriter) Get(k int) (int, bool) {
+	w.treeLock.Lock()
+	defer w.treeLock.Unlock()
+	if w.readYourWrites && w.tree.metadata.overwrite {
+		// the last queued insert of a key overwrites the tree and every insert before it
+		if v, ok := w.lastQueued(k); ok {
+			return v, true
+		}
+	}
+	if v, ok := w.tree.Get(k); ok || !w.readYourWrites {
+		return v, ok
+	}
+
+	// The tree keeps the first insert of a key, so the first queued insert of a key is the one that wins.
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	i := slices.IndexFunc(w.queue, func(p kvPair) bool { return p.key == k })
+	if i < 0 {
+		return 0, false
+	}
+	return w.queue[i].value, true
+}
+
+// Returns the value of the last queued insert of key k, and whether k is queued.
+func (w *AsyncWriter) lastQueued(k int) (int, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := len(w.queue) - 1; i >= 0; i-- {
+		if w.queue[i].key == k {
+			return w.queue[i].value, true
+		}
+	}
+	return 0, false
+}
+
+// Blocks until every insert enqueued before the call has been applied to the tree.
+func (w *AsyncWriter) Sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.queue) > 0 {
+		w.cond.Wait()
+	}
+}
+
+// Drains the queue and stops the background goroutine. Inserts after Close are rejected.
+func (w *AsyncWriter) Close() {
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	<-w.stopped
+}
+
+// Applies queued inserts one at a time until the writer is closed and the queue is empty.
+// An insert stays on the queue until it has been applied, so that readers consulting the
+// queue never miss a pair that is in between the queue and the tree.
+func (w *AsyncWriter) run() {
+	defer close(w.stopped)
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			return
+		}
+		p := w.queue[0]
+		w.mu.Unlock()
+
+		w.treeLock.Lock()
+		w.tree.Insert(p.key, p.value)
+		w.mu.Lock()
+		w.queue = w.queue[1:]
+		w.cond.Broadcast()
+		w.mu.Unlock()
+		w.treeLock.Unlock()
+	}
+}
@@ -0,0 +1,86 @@
+package index
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_asyncWriterQueuedThenDrained(t *testing.T) {
+	w := NewAsyncWriter(newTestTree(t, 10), false)
+	defer w.Close()
+
+	for i := 1; i <= 9; i++ {
+		assertEqual(t, true, w.Insert(100+i, i), "")
+	}
+	w.Sync()
+
+	for i := 1; i <= 9; i++ {
+		v, ok := w.Get(100 + i)
+		assertEqual(t, true, ok, "all queued inserts are applied after Sync")
+		assertEqual(t, i, v, "")
+	}
+	_, ok := w.Get(200)
+	assertEqual(t, false, ok, "")
+}
+
+func Test_asyncWriterReadYourWrites(t *testing.T) {
+	w := NewAsyncWriter(newTestTree(t, 10), true)
+
+	for i := 1; i <= 9; i++ {
+		w.Insert(100+i, i)
+		v, ok := w.Get(100 + i)
+		assertEqual(t, true, ok, "a queued insert is visible to Get")
+		assertEqual(t, i, v, "")
+	}
+
+	// a duplicate key is rejected by the tree, the first insert wins
+	w.Insert(101, 42)
+	v, _ := w.Get(101)
+	assertEqual(t, 1, v, "")
+
+	w.Close()
+	assertEqual(t, false, w.Insert(300, 1), "inserts are rejected once the writer is closed")
+	v, ok := w.Get(109)
+	assertEqual(t, true, ok, "Close drains the queue")
+	assertEqual(t, 9, v, "")
+}
+
+func Test_asyncWriterBoundedQueue(t *testing.T) {
+	w := NewAsyncWriter(newTestTree(t, 10), true, WithMaxQueueLength(2))
+	defer w.Close()
+
+	// the background goroutine cannot apply inserts while the tree is locked
+	w.treeLock.Lock()
+	assertEqual(t, true, w.Insert(1, 1), "")
+	assertEqual(t, true, w.Insert(2, 2), "")
+	inserted := make(chan bool)
+	go func() { inserted <- w.Insert(3, 3) }()
+	select {
+	case <-inserted:
+		t.Fatal("an insert into a full queue returned")
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.treeLock.Unlock()
+	assertEqual(t, true, <-inserted, "the insert is queued once an insert was applied")
+	w.Sync()
+	v, ok := w.Get(3)
+	assertEqual(t, true, ok, "")
+	assertEqual(t, 3, v, "")
+}
+
+func Test_asyncWriterReadYourOverwrites(t *testing.T) {
+	w := NewAsyncWriter(newTestTree(t, 10, WithOverwrite()), true)
+	defer w.Close()
+	w.Insert(1, 10)
+	w.Sync()
+
+	w.treeLock.Lock()
+	w.Insert(1, 11)
+	w.Insert(1, 12)
+	w.treeLock.Unlock()
+	v, _ := w.Get(1)
+	assertEqual(t, 12, v, "the last queued overwrite wins")
+	w.Sync()
+	v, _ = w.Get(1)
+	assertEqual(t, 12, v, "")
+}
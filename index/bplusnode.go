@@ -7,18 +7,28 @@ import (
 	"wtfDB/memory"
 )
 
+/*
+Page ids are serialized with a single width everywhere they are stored on a page:
+the right sibling page id in leaf and inner page headers and the child page pointers
+of inner pages are all 64-bit big-endian integers (PageIdSize). Record ids are stored as
+64-bit values (ValueTypeSize) by default, so no page id or record id is ever truncated; a
+tree configured with narrower record ids rejects record ids that do not fit.
+*/
 const (
 	MaxPageSize     = 256 * 1024
 	MaxKeySize      = 64 * 1024
 	MaxRecordIdSize = 128 * 1024
 	KeySize         = 8 // bytes
 	ValueTypeSize   = 8 // bytes
+	PageIdSize      = 8 // bytes
+	SequenceSize    = 8 // bytes
 	InvalidKey      = -1
 )
 
 var (
 	ErrInvalidPageTypeHeader = fmt.Errorf("invalid page type")
 	ErrNilNode               = fmt.Errorf("node is nil")
+	ErrInvalidRootPage       = fmt.Errorf("root page is not a valid b+ tree node")
 )
 
 /*
@@ -45,9 +55,8 @@ type BPlusTreeNode interface {
 	// Returns the page id of the associated node
 	getPageId() int
 
-	// Returns a pointer to the inner parent node and nil when the node is a root node or does not have a parent
-	// This method also removes the parent from the ancestor seen list (constructed durind downwards tree traversal)
-	getParent() *innerNode
+	// Returns the buffer frame on which the node is serialized
+	getFrame() *memory.Frame
 
 	// Returns the first key in a B+ tree node if the key list is not empty.
 	// This method also returns true if the key list is non-empty, otherwise returns false if empty.
@@ -59,9 +68,6 @@ type BPlusTreeNode interface {
 	// Returns true if leaf node, otherwise false.
 	isLeaf() bool
 
-	// Insert a key-value pair into the B+ tree
-	insert(int, int) bool
-
 	// Serializes B+ tree node to sequence of bytes
 	toBytes() error
 
@@ -69,23 +75,16 @@ type BPlusTreeNode interface {
 	fromBytes([]byte) (BPlusTreeNode, error)
 }
 
+// The split of a node that overflowed on insert: the split key and the page id of the
+// new right sibling, which are inserted into the parent of the split node.
+type nodeSplit struct {
+	key    int
+	pageId int
+}
+
 // Deserialize root page into a b+ tree node that is pinned and loaded into a buffer frame
 func fromBytes(b *memory.BufferPoolManager, m *BPlusTreeMetadata) (BPlusTreeNode, error) {
-	page, err := b.GetPage(m.rootPageId)
-	if err != nil {
-		return nil, err
-	}
-	var node BPlusTreeNode
-	pageType := int(getPageType(page))
-	if pageType == 1 {
-		node, _ = newLeafNode(b, m).fromBytes(page.Data)
-	} else if pageType == 0 {
-		node, _ = newInnerNode(b, m).fromBytes(page.Data)
-	} else {
-		log.Printf("Unexpected byte in page header %d", pageType)
-		return nil, ErrInvalidPageTypeHeader
-	}
-	return node, err
+	return fetchNodeByPage(b, m, m.rootPageId)
 }
 
 func fetchNodeByPage(b *memory.BufferPoolManager, m *BPlusTreeMetadata, pageId int) (BPlusTreeNode, error) {
@@ -97,17 +96,124 @@ func fetchNodeByPage(b *memory.BufferPoolManager, m *BPlusTreeMetadata, pageId i
 	var node BPlusTreeNode
 	switch pageType := int(getPageType(f)); pageType {
 	case 1: // Leaf node
-		node = createLeafNodeFromPage(b, m, f)
+		node, err = createLeafNodeFromPage(b, m, f)
 	case 0: // Inner node
-		node = createInnerNodeFromPage(b, m, f)
+		node, err = createInnerNodeFromPage(b, m, f)
 	default:
 		log.Printf("Unknown node type: %d", pageType)
-		return nil, fmt.Errorf("unknown node type: %d", pageType)
+		err = fmt.Errorf("%w: unknown node type %d", ErrInvalidPageTypeHeader, pageType)
+	}
+	if err != nil {
+		b.Unpin(f)
+		return nil, fmt.Errorf("page %d: %w", pageId, err)
 	}
 	return node, nil
 }
 
+/*
+Fetches the node on page pageId, a child on the given level below the root, and checks that
+its type is plausible for the level: the leaves are on the level of the height of the tree,
+and the nodes of every level above them are inner nodes. A child pointer that lands on a node
+of the wrong type, eg. after the pointer or the page was corrupted, fails with an error
+wrapping ErrCorruptTree instead of being followed. The check is skipped while the height of
+the tree is unknown.
+*/
+func fetchChild(b *memory.BufferPoolManager, m *BPlusTreeMetadata, pageId int, level int) (BPlusTreeNode, error) {
+	node, err := fetchNodeByPage(b, m, pageId)
+	if err != nil {
+		return nil, err
+	}
+	if m.height >= 0 && node.isLeaf() != (level == m.height) {
+		b.Unpin(node.getFrame())
+		return nil, fmt.Errorf("%w: page %d on level %d is %s, expected %s since the leaves are on level %d",
+			ErrCorruptTree, pageId, level, nodeKind(node.isLeaf()), nodeKind(level == m.height), m.height)
+	}
+	return node, nil
+}
+
+func nodeKind(leaf bool) string {
+	if leaf {
+		return "a leaf"
+	}
+	return "an inner node"
+}
+
+/*
+Performs a quick integrity check of the root page of an existing tree.
+
+The root page must have a valid page type and must parse into a node. An inner root
+must also have at least one child, which catches the common case of metadata that
+points at a page that was never written (a zeroed page reads as an empty inner node).
+This is much lighter than a full integrity check of the tree, since only the root
+page is read.
+*/
+func verifyRootPage(b *memory.BufferPoolManager, m *BPlusTreeMetadata) error {
+	f, err := b.GetPage(m.rootPageId)
+	if err != nil {
+		return fmt.Errorf("%w: unable to read root page %d: %v", ErrInvalidRootPage, m.rootPageId, err)
+	}
+	defer b.Unpin(f)
+
+	switch pageType := getPageType(f); pageType {
+	case 1: // Leaf node
+		leaf := &leafNode{treeMetadata: m, bufferManager: b, frame: f}
+		if _, err := leaf.fromBytes(f.Data); err != nil {
+			return fmt.Errorf("%w: root page %d: %v", ErrInvalidRootPage, m.rootPageId, err)
+		}
+	case 0: // Inner node
+		inner := &innerNode{treeMetadata: m, bufferManager: b, frame: f}
+		if _, err := inner.fromBytes(f.Data); err != nil {
+			return fmt.Errorf("%w: root page %d: %v", ErrInvalidRootPage, m.rootPageId, err)
+		}
+		if len(inner.children) == 0 {
+			return fmt.Errorf("%w: root page %d is an inner node without children", ErrInvalidRootPage, m.rootPageId)
+		}
+	default:
+		return fmt.Errorf("%w: root page %d has unknown page type %d", ErrInvalidRootPage, m.rootPageId, pageType)
+	}
+	return nil
+}
+
+// Offsets of the page version in the header of a leaf and an inner page.
+const (
+	leafVersionOffset  = 20
+	innerVersionOffset = 16
+)
+
+/*
+Returns the version of the node serialized on frame f. Every write of a node (see toBytes)
+bumps the version in its page header, and the version is written to disk with the page, so a
+reader that copied a node can tell whether the page changed since by comparing versions. The
+version wraps around after 2^32 writes.
+*/
+func pageVersion(f *memory.Frame) uint32 {
+	if getPageType(f) == 1 {
+		return binary.BigEndian.Uint32(f.Data[leafVersionOffset:])
+	}
+	return binary.BigEndian.Uint32(f.Data[innerVersionOffset:])
+}
+
+// Increments the page version stored at the start of b, and returns the new version.
+// The version is read from the page rather than from the node, since several copies of a
+// node may be parsed from the same page.
+func bumpVersion(b []byte) uint32 {
+	version := binary.BigEndian.Uint32(b) + 1
+	binary.BigEndian.PutUint32(b, version)
+	return version
+}
+
 // Returns 1 if page is leaf, 0 if inner and -1 if invalid page
 func getPageType(page *memory.Frame) int {
 	return int(binary.BigEndian.Uint32(page.Data[0:]))
 }
+
+// Serializes a page id into the first PageIdSize bytes of b.
+func putPageId(b []byte, pageId int) {
+	binary.BigEndian.PutUint64(b, uint64(pageId))
+}
+
+// Deserializes a page id from the first PageIdSize bytes of b.
+// The InvalidPageId (-1) round-trips through its two's complement representation.
+func getPageId(b []byte) int {
+	return int(int64(binary.BigEndian.Uint64(b)))
+}
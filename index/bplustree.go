@@ -1,8 +1,15 @@
 package index
 
 import (
+	"cmp"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"log"
+	"slices"
 	"strings"
+	"sync"
+	"wtfDB/io"
 	"wtfDB/memory"
 )
 
@@ -26,133 +33,1727 @@ the search and leaf pages contain actual data.
 type BPlusTree interface {
 	Insert(k int, v int) bool
 	Get(k int) (int, bool)
-	// Remove(k int) bool
+	Remove(k int) bool
 }
 
+const (
+	DefaultOrder = 4
+	MinOrder     = 3 // an inner node must be able to split into two nodes that each have a child
+)
+
+var (
+	ErrInvalidOrder        = fmt.Errorf("invalid b+ tree order")
+	ErrInvalidRecordIdSize = fmt.Errorf("invalid record id size")
+	ErrRecordIdOutOfRange  = fmt.Errorf("record id does not fit the record id size")
+	ErrTreeClosed          = fmt.Errorf("b+ tree is closed")
+	ErrTreeNotInitialized  = fmt.Errorf("b+ tree is not initialized")
+	ErrBufferPoolTooSmall  = fmt.Errorf("buffer pool is too small for the tree")
+	ErrNodePinned          = fmt.Errorf("node is pinned outside the tree")
+)
+
 type BPlusTreeMetadata struct {
-	rootPageId int          // root page id, set to an in
-	order      int          // minimum number of keys for any node
-	indexName  string       // name of the B+ tree index, default name is primary
-	seen       []*innerNode // maintains ancestral nodes seen during downward tree traversal from root to leaf
+	rootPageId      int    // root page id, set to an in
+	order           int    // max number of entries (key/record id pairs or child pointers) per node
+	indexName       string // name of the B+ tree index, default name is primary
+	seen            []int  // page ids of the ancestral nodes seen during downward tree traversal from root to leaf
+	verifyOnOpen    bool   // verify the root page when opening an existing tree
+	varintRecordIds bool   // store leaf record ids as varints instead of fixed 8 byte values
+	recordIdSize    int    // width of a fixed-size record id on a leaf page, 4 or 8 bytes
+	insertSequence  bool   // store an insert sequence number with every leaf entry
+	appendHint      bool   // the workload inserts mostly increasing keys, see WithAppendHint
+	nextSequence    int    // the sequence number assigned to the next inserted entry
+	overwrite       bool   // inserting an existing key overwrites its record id, see WithOverwrite
+	moveRight       bool   // lookups follow right sibling links past a split, see WithMoveRight
+	inlineSize      int    // max size of a value stored inline in a leaf, 0 if disabled, see WithInlineValues
+	height          int    // the number of levels below the root, -1 if unknown, see fetchChild
+
+	// orders the keys of the tree, cmp.Compare by default
+	compare func(a, b int) int
 }
 
+// Option configures the metadata of a B+ tree.
+type Option func(*BPlusTreeMetadata)
+
+// WithVerifyOnOpen enables a quick integrity check of an existing tree when it is opened.
+// The check verifies that the root page id points to a page with a valid page type that
+// can be parsed into a root node, so that a tree whose metadata points at a garbage page
+// fails to open with a descriptive error instead of failing on the first lookup.
+func WithVerifyOnOpen() Option {
+	return func(m *BPlusTreeMetadata) {
+		m.verifyOnOpen = true
+	}
+}
+
+/*
+A bPlusTree is safe for concurrent use by multiple goroutines. A coarse tree latch lets any
+number of readers (lookups, scans and iterators) run at the same time, while a writer (an
+insert or delete) holds it exclusively. Since there's only ever one writer, the seen stack
+of the metadata is never shared between inserts.
+*/
 type bPlusTree struct {
 	Root          BPlusTreeNode             // root of the B+ tree
 	bufferManager *memory.BufferPoolManager // buffer pool manager
 	metadata      *BPlusTreeMetadata
+	pinnedInner   []*memory.Frame // frames of the inner nodes pinned by PinInternalNodes
+	appendLeaf    *memory.Frame   // the rightmost leaf, kept pinned when the tree has the append hint
+
+	mu     sync.RWMutex // tree latch, shared by readers and held exclusively by writers
+	rootMu sync.Mutex   // guards Root and the root page id, which readers may reload concurrently
+
+	maintenanceMu sync.Mutex   // guards maintenance
+	maintenance   *maintenance // the background maintenance started by StartMaintenance, nil if not running
+}
+
+// WithOrder sets the order (fanout) of the tree: the max number of key/record id pairs of a
+// leaf and of child pointers of an inner node. A node that overflows its order is split in two.
+// The order must be at least MinOrder, and a node of that many entries must fit in a page;
+// NewBPlusTree returns ErrInvalidOrder otherwise. The default order is DefaultOrder.
+func WithOrder(n int) Option {
+	return func(m *BPlusTreeMetadata) {
+		m.order = n
+	}
+}
+
+/*
+WithMaxKeysPerNode caps the capacity of every node at n key pairs, well below the max that
+fits in a page, eg. to force splits and merges in tests of rebalancing edge cases. A leaf holds
+up to n key/record id pairs and an inner node up to n child pointers (n-1 separator keys), so
+getMaxSize of both is 2*n in getSize units. The cap is the order of the tree: this is WithOrder
+under the name of the cap, it overrides an earlier WithOrder, and the same bounds apply.
+*/
+func WithMaxKeysPerNode(n int) Option {
+	return WithOrder(n)
+}
+
+// WithVarintRecordIds stores the record ids of leaf pages as variable-length signed varints
+// rather than fixed 8 byte values, which saves space for small record ids. Keys stay fixed
+// size so that they can still be binary searched. The encoding is not recorded on the page,
+// so a tree must always be opened with the same setting it was created with.
+func WithVarintRecordIds() Option {
+	return func(m *BPlusTreeMetadata) {
+		m.varintRecordIds = true
+	}
+}
+
+// WithRecordIdSize sets the width of the record ids stored on leaf pages to 4 or 8 bytes.
+// A 4 byte record id fits more entries on a leaf page, but only holds record ids within the
+// range of an int32; inserting a record id outside of that range fails with ErrRecordIdOutOfRange.
+// The width is not recorded on the page, so a tree must always be opened with the same setting
+// it was created with. The width has no effect on varint record ids.
+func WithRecordIdSize(n int) Option {
+	return func(m *BPlusTreeMetadata) {
+		m.recordIdSize = n
+	}
+}
+
+/*
+WithComparator orders the keys of the tree with compare instead of the natural order of
+ints. compare returns a negative number when a sorts before b, a positive number when a
+sorts after b, and zero when a and b are the same key. Every lookup, insert and scan follows
+this order. The comparator is not recorded on the page, so a tree must always be opened
+with the same comparator it was created with.
+*/
+func WithComparator(compare func(a, b int) int) Option {
+	return func(m *BPlusTreeMetadata) {
+		m.compare = compare
+	}
+}
+
+/*
+WithAppendHint tunes the tree for append-mostly workloads, where keys are inserted in (mostly)
+increasing order and the rightmost leaf is nearly always the insertion point:
+  - the rightmost leaf is kept pinned, and a key greater than every key of the tree is
+    appended to it directly, without descending from the root
+  - when the rightmost leaf overflows on an append, only the appended key moves to the new
+    leaf, so the leaves left behind are full instead of half full
+
+Keys inserted out of order take the regular path. The pinned leaf takes one more frame of
+the buffer pool, so the pool must hold at least four frames.
+*/
+func WithAppendHint() Option {
+	return func(m *BPlusTreeMetadata) {
+		m.appendHint = true
+	}
+}
+
+/*
+WithInsertSequence stores a monotonically increasing sequence number with every leaf entry,
+assigned in the order the entries are inserted, so that entries can be visited in insert
+order with ScanByInsertOrder. Sequence numbers take 8 bytes per entry, which lowers the
+max order of the tree. Like the record id encoding, this is not recorded on the page, so a
+tree must always be opened with the same setting it was created with.
+*/
+func WithInsertSequence() Option {
+	return func(m *BPlusTreeMetadata) {
+		m.insertSequence = true
+	}
+}
+
+/*
+WithOverwrite makes inserting an existing key overwrite the record id of the key. By default
+inserting an existing key is a no-op that keeps the record id. Either way the insert reports
+false, since no key was added, and a full leaf is never split for an existing key.
+*/
+func WithOverwrite() Option {
+	return func(m *BPlusTreeMetadata) {
+		m.overwrite = true
+	}
+}
+
+/*
+WithMoveRight makes Get tolerate a split whose new sibling is not yet linked into its
+parent: when k sorts after every key of the leaf it descended to, the lookup retries in the
+right sibling of the leaf, for as long as k may have moved there. Splits write the sibling
+before linking it (see Insert), so the sibling link is always complete. Readers hold the
+tree latch for reading today and never see an unlinked sibling, so this is off by default;
+it costs a read of the next leaf for every miss past the last key of a leaf.
+*/
+func WithMoveRight() Option {
+	return func(m *BPlusTreeMetadata) {
+		m.moveRight = true
+	}
 }
 
-func NewBPlusTreeMetadata(indexName string) *BPlusTreeMetadata {
-	return &BPlusTreeMetadata{
-		order:      4,
-		rootPageId: memory.InvalidPageId,
-		indexName:  indexName,
-		seen:       make([]*innerNode, 0),
+func NewBPlusTreeMetadata(indexName string, opts ...Option) *BPlusTreeMetadata {
+	m := &BPlusTreeMetadata{
+		order:        DefaultOrder,
+		rootPageId:   memory.InvalidPageId,
+		indexName:    indexName,
+		seen:         make([]int, 0),
+		recordIdSize: ValueTypeSize,
+		height:       -1,
+		compare:      cmp.Compare[int],
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+	return m
 }
 
+/*
+Creates a B+ tree on buffer pool b, or opens an existing tree: the tree of the same index name
+in the header page of the file (see header.go), or the tree whose root page id the metadata
+holds (see OpenBPlusTree).
+
+Several indexes can share a single buffer pool, and so a single database file: page ids are
+allocated by the pool and are unique within the file, so the pages of one tree are never
+handed out to another, and every tree only follows the page ids reachable from its own root.
+Each tree keeps its root pinned, so a shared pool needs at least one frame per open tree on
+top of the frames the trees use to descend. The trees evict each other's pages as usual.
+
+Returns an error wrapping ErrTreeNotInitialized if the root leaf of a new tree cannot be
+created, eg. because the buffer pool has no frame to spare, and ErrInvalidHeaderPage if page 0
+of the file is not a header page.
+*/
 func NewBPlusTree(indexName string, b *memory.BufferPoolManager, m *BPlusTreeMetadata) (*bPlusTree, error) {
+	if m.rootPageId == memory.InvalidPageId {
+		if err := m.Load(b); err != nil && !errors.Is(err, ErrIndexNotFound) {
+			return nil, err
+		}
+	} else if _, err := loadHeaderPage(b); err != nil {
+		// the root page id could not be saved when the root changes
+		return nil, err
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
 	bptree := &bPlusTree{
 		metadata:      m,
 		bufferManager: b,
 	}
 	// case 1. there exists a valid root page id
 	if m.rootPageId != memory.InvalidPageId {
+		if m.verifyOnOpen {
+			if err := verifyRootPage(b, m); err != nil {
+				return nil, err
+			}
+		}
 		node, err := fromBytes(b, m)
 		if err != nil {
 			return nil, err
 		}
 		bptree.Root = node
+		if m.height, err = bptree.measureHeight(); err != nil {
+			b.Unpin(node.getFrame())
+			return nil, err
+		}
+		if m.insertSequence {
+			// continue numbering after the latest insert of the existing tree
+			err := bptree.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+				for _, seq := range leaf.sequences {
+					m.nextSequence = max(m.nextSequence, seq+1)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
 	} else {
 		// case 2: we need to create the root page
+		// page 0 is the header page, so it is reserved before the root leaf takes a page
+		f, err := reserveHeaderPage(b)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrTreeNotInitialized, err)
+		}
+		b.Unpin(f)
 		leaf := newLeafNode(b, m)
-		bptree.updateRoot(leaf)
+		if leaf == nil {
+			return nil, fmt.Errorf("%w: unable to create the root leaf: %w", ErrTreeNotInitialized, memory.ErrBufferPoolFull)
+		}
+		m.height = 0
+		if err := bptree.updateRoot(leaf); err != nil {
+			b.Unpin(leaf.frame)
+			return nil, err
+		}
 	}
 	return bptree, nil
 }
 
-// Inserts a k,v pair into the B+tree
+/*
+Opens the existing tree whose root is on page rootPageId, eg. one of several indexes in a
+file that share buffer pool b. The tree must be opened with the same options it was created
+with. The root page id of a tree is returned by RootPageId, and changes as the tree grows.
+*/
+func OpenBPlusTree(indexName string, b *memory.BufferPoolManager, rootPageId int, opts ...Option) (*bPlusTree, error) {
+	m := NewBPlusTreeMetadata(indexName, opts...)
+	m.rootPageId = rootPageId
+	return NewBPlusTree(indexName, b, m)
+}
+
+// Returns the page id of the root of the tree, which is needed to open the tree again.
+func (t *bPlusTree) RootPageId() int {
+	return t.rootPage()
+}
+
+/*
+Inserts a k,v pair into the B+tree.
+
+The root is pinned for as long as it is the root of the tree. For an inner root, the
+tree is traversed from the root to the leaf L in which the pair belongs; every inner node
+on the path is recorded on the seen stack so that a split of L can push its split key up
+into its ancestors. Nodes are unpinned as soon as they are no longer needed, and ancestors
+are loaded again when a split reaches them, so an insert pins at most three pages.
+
+When the insert splits the root, a new root is created a level above it, and the tree's
+root is swapped for the new root.
+
+A split writes its pages in an order that keeps every reachable page complete: the new
+sibling is written first, then the split node with its right sibling link to it, and only
+then is the pointer to the sibling added to the parent (or to the new root, which is
+written before it is swapped in). A reader that follows any pointer of the tree thus finds
+a fully written node, while a sibling that is not yet linked into its parent is only
+reachable through the sibling link, where it holds keys the parent still routes to its left
+neighbour. Readers hold the tree latch for reading, so they do not observe a split in
+progress today, but the order keeps pages consistent for latch crabbing and after a crash
+between the writes. An insert into the key range of a leaf that a crashed split did not
+link into its parent links the leaf before inserting, see unlinkedSibling.
+*/
 func (t *bPlusTree) Insert(k int, v int) bool {
-	// how do we know there's an overflow ?
-	// what happens when the tree height changes ?
-	// how do we initiate the new root >
-	// what type is the new root?
-	// update root helper can be useful here
-	fmt.Printf("inserting k,v pair: %+v,%+v\n", k, v)
-	if t.Root.getMaxSize() <= t.Root.getSize() {
-		// insertion into full root node will cause an overflow
-		// case 1. root is a leaf, therefore we need to create a new inner node
-		if t.Root.isLeaf() { // nit: type assertion with ok comma idiom ?
-			fmt.Println("root is a leaf")
-			newRoot := newInnerNode(t.bufferManager, t.metadata)
-			t.metadata.seen = append(t.metadata.seen, newRoot) // append new root to ancestor stack maintained during downward tree traversal
-			l, _ := t.Root.(*leafNode)
-			// set first pointer in the new root to point to the subtree holding less than the first index entry
-			newRoot.children = append(newRoot.children, uint64(l.frame.PageId))
-			// set parent of root leaf L to newroot and update root page id
-			t.updateRoot(newRoot)
-			// perform insertion into current root node
-			inserted := l.insert(k, v)
-			t.bufferManager.Unpin(l.frame)
-			return inserted
-		}
-
-		// case 2: root node is an inner node, therefore we need to create a new inner node
-		// 1. create a new root of type inner node and insert page pointer to current root, if the current root is full
-		// 2. set parent of current root as the new root
-		// 3. traverse root to find the correct leaf node L to insert k,v pair. use lookup algorithm to find correct leaf node
-		// 4. insert k,v pair into leaf node
-		// 5. if new root contains non-empty keys (index entries), update root pointer/root page id to point to new root
-
-	}
-	// case : root is leaf and root is not full (can insert k/v pair directly into leaf node)
-	if t.Root.isLeaf() {
-		inserted := t.Root.insert(k, v)
-		t.bufferManager.Unpin(t.Root.(*leafNode).frame)
-		return inserted
-	}
-
-	// case : root is inner node and root is not full
-	// 3. traverse root to find the correct leaf node L to insert k,v pair. use lookup algorithm to find correct leaf node
-	// 4. insert k,v pair into leaf node
-	fmt.Println("BPTree: current root is an inner node...")
-	fmt.Printf("BPTree: inserting [%+v,%+v] into tree\n", k, v)
-	leafNode, _ := t.Root.(*innerNode).search(k)
-	t.bufferManager.Unpin(leafNode.frame)
-	return leafNode.insert(k, v)
-}
-
-// Return the value associated with a given key
+	if t == nil {
+		log.Println(ErrTreeNotInitialized)
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	inserted, err := t.insert(k, LeafValue{RecordId: v})
+	if err != nil {
+		log.Println(err)
+	}
+	return inserted
+}
+
+/*
+Inserts a k,v pair into the B+tree like Insert, and also reports whether the insert
+made the tree grow a level, ie. whether the root was split and swapped for a new root.
+*/
+func (t *bPlusTree) InsertWithInfo(k int, v int) (inserted bool, grew bool, err error) {
+	if t == nil {
+		return false, false, ErrTreeNotInitialized
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rootPageId := t.rootPage()
+	inserted, err = t.insert(k, LeafValue{RecordId: v})
+	return inserted, t.rootPage() != rootPageId, err
+}
+
+// Inserts key k with value v, a record id or a value stored inline, into the tree.
+func (t *bPlusTree) insert(k int, v LeafValue) (bool, error) {
+	root, err := t.root()
+	if err != nil {
+		return false, err
+	}
+	if !v.IsInline() && !t.metadata.recordIdFits(v.RecordId) {
+		return false, fmt.Errorf("%w: %d does not fit in %d bytes", ErrRecordIdOutOfRange, v.RecordId, t.metadata.recordIdSize)
+	}
+	t.metadata.seen = t.metadata.seen[:0]
+	if inserted, ok := t.appendToRightmostLeaf(k, v); ok {
+		return inserted, nil
+	}
+
+	node := root
+	if !root.isLeaf() {
+		// case : root is inner node
+		// traverse root to find the correct leaf node L to insert k,v pair and insert k,v pair into leaf node
+		leaf, err := root.(*innerNode).search(k)
+		if errors.Is(err, memory.ErrBufferPoolFull) && len(t.pinnedInner) > 0 {
+			// inner nodes pinned by PinInternalNodes only save reads, release them to make room
+			t.unpinInternalNodes()
+			t.metadata.seen = t.metadata.seen[:0]
+			leaf, err = root.(*innerNode).search(k)
+		}
+		if err != nil {
+			t.metadata.seen = t.metadata.seen[:0]
+			return false, err
+		}
+		node = leaf
+	}
+	leaf := node.(*leafNode)
+	if split := t.unlinkedSibling(leaf, k); split != nil {
+		// a split crashed before linking its new leaf into the parent, finish it and insert again
+		if err := t.pushSplit(root, node, split); err != nil {
+			return false, fmt.Errorf("unable to link leaf %d left behind by a split: %w", split.pageId, err)
+		}
+		return t.insert(k, v)
+	}
+	// the rightmost leaf after the insert, to keep pinned for appends
+	rightmost := memory.InvalidPageId
+	if t.metadata.appendHint && node != root && leaf.rightSibling == memory.InvalidPageId {
+		rightmost = leaf.getPageId()
+	}
+	inserted, split, err := t.insertInto(func() (bool, *nodeSplit, error) { return leaf.insert(k, v) })
+	if err != nil {
+		t.releaseLeaf(root, leaf)
+		return false, err
+	}
+	if rightmost != memory.InvalidPageId && split != nil {
+		rightmost = split.pageId
+	}
+	defer t.keepAppendLeaf(rightmost)
+	return inserted, t.pushSplit(root, node, split)
+}
+
+/*
+Runs insert, the insert of a pair into a leaf or inner node, which may split the node. When
+the buffer pool has no frame for the new sibling of a split, the inner nodes pinned by
+PinInternalNodes are released, since they only save reads, and the insert is tried again.
+The node is left as is if the insert fails.
+*/
+func (t *bPlusTree) insertInto(insert func() (bool, *nodeSplit, error)) (bool, *nodeSplit, error) {
+	inserted, split, err := insert()
+	if errors.Is(err, memory.ErrBufferPoolFull) && len(t.pinnedInner) > 0 {
+		t.unpinInternalNodes()
+		inserted, split, err = insert()
+	}
+	return inserted, split, err
+}
+
+/*
+Pushes the split of node, if any, up into its ancestors on the seen stack, one level at a
+time, and grows the tree by a level when the root is split. node is unpinned unless it is
+the root; a node is unpinned before its parent is loaded, so an insert pins at most the
+root, one node and the node's new sibling.
+
+When a parent cannot be split for lack of a frame, the error is returned and the new sibling
+of the node is only reachable through its sibling link. A leaf left this way is linked into
+its parent by a later insert into its key range, see unlinkedSibling.
+*/
+func (t *bPlusTree) pushSplit(root BPlusTreeNode, node BPlusTreeNode, split *nodeSplit) error {
+	for split != nil {
+		if node != root {
+			t.bufferManager.Unpin(node.getFrame())
+		}
+		parentId := t.metadata.removeAncestor()
+		if parentId == memory.InvalidPageId {
+			// the root was split, grow the tree by a level
+			return t.growRoot(split)
+		}
+		if parentId == root.getPageId() {
+			node = root
+		} else {
+			parent, err := fetchNodeByPage(t.bufferManager, t.metadata, parentId)
+			if err != nil {
+				t.metadata.seen = t.metadata.seen[:0]
+				return fmt.Errorf("unable to load parent to insert split key %d: %w", split.key, err)
+			}
+			node = parent
+		}
+		parent, ok := node.(*innerNode)
+		if !ok {
+			if node != root {
+				t.bufferManager.Unpin(node.getFrame())
+			}
+			t.metadata.seen = t.metadata.seen[:0]
+			return fmt.Errorf("%w: parent %d is not an inner node", ErrCorruptTree, parentId)
+		}
+		assertLinkedChild("split link", t.bufferManager, t.metadata, split.pageId)
+		pushed := split
+		var err error
+		if _, split, err = t.insertInto(func() (bool, *nodeSplit, error) { return parent.insert(pushed.key, pushed.pageId) }); err != nil {
+			if node != root {
+				t.bufferManager.Unpin(node.getFrame())
+			}
+			t.metadata.seen = t.metadata.seen[:0]
+			return fmt.Errorf("unable to link page %d into its parent %d: %w", pushed.pageId, node.getPageId(), err)
+		}
+	}
+	if node != root {
+		t.bufferManager.Unpin(node.getFrame())
+	}
+	t.metadata.seen = t.metadata.seen[:0]
+	return nil
+}
+
+/*
+Detects a leaf split that was cut short, eg. by a crash, after the new leaf was written and
+linked as the right sibling of leaf, but before it was linked into the parent of leaf. The
+parent then routes keys of the new leaf to leaf, so such a split shows when k sorts after
+every key of leaf and not before the first key of its right sibling. Returns the split that
+links the sibling into the parent, or nil if the sibling is linked. Only inserts past the
+last key of a leaf read its sibling.
+*/
+func (t *bPlusTree) unlinkedSibling(leaf *leafNode, k int) *nodeSplit {
+	compare := t.metadata.compare
+	if leaf.rightSibling == memory.InvalidPageId || (len(leaf.keys) > 0 && compare(k, leaf.keys[len(leaf.keys)-1]) <= 0) {
+		return nil
+	}
+	next, err := fetchNodeByPage(t.bufferManager, t.metadata, leaf.rightSibling)
+	if err != nil {
+		return nil // the insert goes ahead into leaf
+	}
+	defer t.bufferManager.Unpin(next.getFrame())
+	sibling, ok := next.(*leafNode)
+	if !ok || len(sibling.keys) == 0 || compare(k, sibling.keys[0]) < 0 {
+		return nil
+	}
+	return &nodeSplit{key: sibling.keys[0], pageId: sibling.getPageId()}
+}
+
+/*
+Appends k,v to the rightmost leaf kept pinned by the append hint, when k is greater than
+every key of the tree and the leaf has room for it. Reports false if the pair has to be
+inserted along the regular path instead.
+*/
+func (t *bPlusTree) appendToRightmostLeaf(k int, v LeafValue) (bool, bool) {
+	if t.appendLeaf == nil {
+		return false, false
+	}
+	// the leaf is parsed from its frame, which other operations may have changed since
+	leaf, err := createLeafNodeFromPage(t.bufferManager, t.metadata, t.appendLeaf)
+	if err != nil || leaf.rightSibling != memory.InvalidPageId || len(leaf.keys) == 0 {
+		return false, false
+	}
+	if t.metadata.compare(k, leaf.keys[len(leaf.keys)-1]) <= 0 || leaf.getMaxSize()-leaf.getSize() < 1 {
+		return false, false
+	}
+	inserted, _, _ := leaf.insert(k, v) // the leaf has room, so it is not split
+	return inserted, true
+}
+
+// Keeps the leaf on page pageId pinned for appends, in place of the leaf pinned so far.
+// Does nothing for InvalidPageId.
+func (t *bPlusTree) keepAppendLeaf(pageId int) {
+	if pageId == memory.InvalidPageId || (t.appendLeaf != nil && t.appendLeaf.PageId == pageId) {
+		return
+	}
+	f, err := t.bufferManager.GetPage(pageId)
+	if err != nil {
+		return // appends take the regular path until the next insert into the rightmost leaf
+	}
+	t.releaseAppendLeaf()
+	t.appendLeaf = f
+}
+
+func (t *bPlusTree) releaseAppendLeaf() {
+	if t.appendLeaf != nil {
+		t.bufferManager.Unpin(t.appendLeaf)
+		t.appendLeaf = nil
+	}
+}
+
+// Creates a new root above the split root, holding the split key, and swaps it in as the root of the tree.
+func (t *bPlusTree) growRoot(split *nodeSplit) error {
+	newRoot := newRootNode(t.bufferManager, t.metadata, t.Root.getPageId())
+	if newRoot == nil && len(t.pinnedInner) > 0 {
+		t.unpinInternalNodes()
+		newRoot = newRootNode(t.bufferManager, t.metadata, t.Root.getPageId())
+	}
+	if newRoot == nil {
+		return fmt.Errorf("unable to create a new root: %w", memory.ErrBufferPoolFull)
+	}
+	assertLinkedChild("root split link", t.bufferManager, t.metadata, split.pageId)
+	newRoot.insert(split.key, split.pageId)
+	t.metadata.height++
+	return t.updateRoot(newRoot)
+}
+
+// Return the value associated with a given key and true if the key exists.
+// If the key does not exist, the zero value and false are returned.
 func (t *bPlusTree) Get(k int) (int, bool) {
-	return t.Root.get(k)
+	v, ok, err := t.get(k)
+	if err != nil {
+		log.Println(err)
+	}
+	return v, ok
+}
+
+var ErrKeyNotFound = fmt.Errorf("key not found")
+
+// Returns the value associated with key k like Get, but reports a missing key with
+// ErrKeyNotFound, and a lookup that fails (eg. on a closed tree) with its error.
+func (t *bPlusTree) GetE(k int) (int, error) {
+	v, ok, err := t.get(k)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%w: %d", ErrKeyNotFound, k)
+	}
+	return v, nil
+}
+
+func (t *bPlusTree) get(k int) (int, bool, error) {
+	if t == nil {
+		return 0, false, ErrTreeNotInitialized
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	root, err := t.root()
+	if err != nil {
+		return 0, false, err
+	}
+	leaf, isLeaf := root.(*leafNode)
+	if isLeaf && !t.metadata.moveRight {
+		v, ok := leaf.get(k)
+		return v, ok, nil
+	}
+	if isLeaf {
+		// a root leaf is pinned once more, since moving right unpins it
+		t.bufferManager.Pin(leaf.frame)
+	} else if leaf, err = root.(*innerNode).findLeaf(k); err != nil {
+		return 0, false, err
+	}
+	if t.metadata.moveRight {
+		if leaf, err = leaf.moveRight(k); err != nil {
+			return 0, false, err
+		}
+	}
+	defer t.bufferManager.Unpin(leaf.frame)
+	v, ok := leaf.get(k)
+	return v, ok, nil
+}
+
+/*
+Removes key k and its record id from the tree, and reports whether k existed. The leaf of k
+is found along the same path as Get, while its ancestors are recorded on the seen stack like
+an insert does. A leaf other than the root that drops below half full borrows entries from
+a sibling under the same parent, or is merged with it, and a merge may cascade up to the
+root, which shrinks the tree by a level once it is left with a single child, see rebalance.
+
+A merge frees the page of the right node. An open iterator notices that its leaf changed by
+the page version, and then finds the next leaf from the root instead of the sibling link.
+*/
+func (t *bPlusTree) Remove(k int) bool {
+	if t == nil {
+		log.Println(ErrTreeNotInitialized)
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	removed, err := t.remove(k)
+	if err != nil {
+		log.Println(err)
+	}
+	return removed
+}
+
+func (t *bPlusTree) remove(k int) (bool, error) {
+	root, err := t.root()
+	if err != nil {
+		return false, err
+	}
+	t.metadata.seen = t.metadata.seen[:0]
+	leaf, ok := root.(*leafNode)
+	if !ok {
+		if leaf, err = root.(*innerNode).search(k); err != nil {
+			t.metadata.seen = t.metadata.seen[:0]
+			return false, err
+		}
+	}
+	if !leaf.remove(k) {
+		t.releaseLeaf(root, leaf)
+		return false, nil
+	}
+	assertNode("remove", leaf)
+	if err := leaf.toBytes(); err != nil {
+		t.releaseLeaf(root, leaf)
+		return false, err
+	}
+	t.bufferManager.MarkDirty(leaf.frame)
+	if leaf == root || !leaf.underflows() {
+		t.releaseLeaf(root, leaf)
+		return true, nil
+	}
+	if err := t.rebalance(root.(*innerNode), leaf); err != nil {
+		return true, fmt.Errorf("unable to rebalance leaf %d after removing key %d: %w", leaf.getPageId(), k, err)
+	}
+	return true, nil
+}
+
+// Unpins leaf unless it is the root, and clears the seen stack.
+func (t *bPlusTree) releaseLeaf(root BPlusTreeNode, leaf *leafNode) {
+	if leaf != root {
+		t.bufferManager.Unpin(leaf.frame)
+	}
+	t.metadata.seen = t.metadata.seen[:0]
+}
+
+/*
+Rebalances node, a leaf or inner node other than the root that dropped below half full, with
+its right sibling under the same parent, or its left sibling if node is the last child of
+the parent. When the entries of both nodes fit in one node, the right node is merged into the
+left one: the left node takes over the right sibling link of the right node, the page of the
+right node is freed, and its child pointer and separator key are removed from the parent.
+This also covers a left node that became empty. Otherwise the entries are redistributed
+evenly, and the separator key of the right node in the parent is replaced. A right node that
+is pinned outside the tree is not merged, since its page could not be freed: ErrNodePinned is
+returned and node stays underfull (see releaseMergedNode).
+
+Inner nodes are merged and redistributed by pulling the separator key of the right node
+down from the parent, see innerNode.merge. A merge removes a child from the parent, so a
+parent that drops below half full is rebalanced in turn, up to the root; a root that is left
+with a single child is replaced by its child, which shrinks the tree by a level.
+
+The parent is the top of the seen stack. node must be pinned and is unpinned; the parent is
+unpinned while the siblings are updated, so that a removal pins at most the root and two
+other pages, like an insert.
+*/
+func (t *bPlusTree) rebalance(root *innerNode, node BPlusTreeNode) error {
+	defer func() { t.metadata.seen = t.metadata.seen[:0] }()
+	for {
+		parentId := t.metadata.removeAncestor()
+		parent, err := t.fetchParent(root, parentId)
+		if err != nil {
+			t.bufferManager.Unpin(node.getFrame())
+			return err
+		}
+		i := slices.Index(parent.children, uint64(node.getPageId()))
+		j := i + 1
+		if j == len(parent.children) {
+			j = i - 1
+		}
+		var siblingId, separator int
+		if i >= 0 && j >= 0 {
+			siblingId = int(parent.children[j])
+			separator = parent.keys[max(i, j)]
+		}
+		if parent != root {
+			t.bufferManager.Unpin(parent.frame)
+		}
+		if i < 0 || j < 0 {
+			// an only child has no sibling to rebalance with, it stays underfull
+			t.bufferManager.Unpin(node.getFrame())
+			return nil
+		}
+
+		sibling, err := fetchNodeByPage(t.bufferManager, t.metadata, siblingId)
+		if err != nil {
+			t.bufferManager.Unpin(node.getFrame())
+			return err
+		}
+		left, right := node, sibling
+		if j < i {
+			left, right = sibling, node
+		}
+		if t.fitInOne(left, right) {
+			if err := t.releaseMergedNode(right); err != nil {
+				t.bufferManager.Unpin(left.getFrame())
+				t.bufferManager.Unpin(right.getFrame())
+				return err
+			}
+		}
+		merge, separator, err := t.rebalancePair(left, right, separator)
+		if err == nil {
+			err = left.toBytes()
+			t.bufferManager.MarkDirty(left.getFrame())
+		}
+		if err == nil && !merge {
+			// the page of a merged right node is freed, so it is not written
+			err = right.toBytes()
+			t.bufferManager.MarkDirty(right.getFrame())
+		}
+		t.bufferManager.Unpin(left.getFrame())
+		t.bufferManager.Unpin(right.getFrame())
+		if err != nil {
+			return err
+		}
+		if merge {
+			deleted, err := t.bufferManager.DeletePage(right.getPageId())
+			if err != nil {
+				return err
+			}
+			if !deleted {
+				// releaseMergedNode checked that the tree held the only pin
+				return fmt.Errorf("%w: merged page %d was pinned again", ErrNodePinned, right.getPageId())
+			}
+		}
+
+		// the parent is loaded again to update the separator key of the right node
+		if parent, err = t.fetchParent(root, parentId); err != nil {
+			return err
+		}
+		rightIdx := max(i, j)
+		if merge {
+			parent.keys = slices.Delete(parent.keys, rightIdx, rightIdx+1)
+			parent.children = slices.Delete(parent.children, rightIdx, rightIdx+1)
+		} else {
+			parent.keys[rightIdx] = separator
+		}
+		assertNode("rebalance", parent)
+		if err := parent.toBytes(); err != nil {
+			if parent != root {
+				t.bufferManager.Unpin(parent.frame)
+			}
+			return err
+		}
+		t.bufferManager.MarkDirty(parent.frame)
+		if parent == root {
+			if len(root.children) == 1 {
+				return t.shrinkRoot(root)
+			}
+			return nil
+		}
+		if !merge || !parent.underflows() {
+			t.bufferManager.Unpin(parent.frame)
+			return nil
+		}
+		node = parent
+	}
+}
+
+// Reports whether the entries of the sibling nodes left and right fit in one node, in which
+// case rebalancePair merges right into left.
+func (t *bPlusTree) fitInOne(left BPlusTreeNode, right BPlusTreeNode) bool {
+	switch l := left.(type) {
+	case *leafNode:
+		r, ok := right.(*leafNode)
+		return ok && len(l.keys)+len(r.keys) <= t.metadata.order
+	case *innerNode:
+		r, ok := right.(*innerNode)
+		return ok && len(l.children)+len(r.children) <= t.metadata.order
+	}
+	return false
+}
+
+/*
+Releases the pins that the tree itself keeps on right, the node to be merged into its left
+sibling and freed: the append leaf, and the inner nodes pinned by PinInternalNodes. Returns
+ErrNodePinned if right is still pinned by anyone but the caller, eg. by a LeafHandle or a
+scan, since its page could not be freed once it is unlinked from the tree. The tree is not
+changed in that case, and the node it left underfull stays so.
+*/
+func (t *bPlusTree) releaseMergedNode(right BPlusTreeNode) error {
+	if t.appendLeaf != nil && t.appendLeaf.PageId == right.getPageId() {
+		t.releaseAppendLeaf()
+	}
+	if !right.isLeaf() {
+		t.unpinInternalNodes()
+	}
+	if pins := t.bufferManager.PinCount(right.getFrame()); pins > 1 {
+		return fmt.Errorf("%w: page %d holds %d other pins and cannot be freed by a merge", ErrNodePinned, right.getPageId(), pins-1)
+	}
+	return nil
+}
+
+/*
+Merges or redistributes the entries of the sibling nodes left and right, whose separator key
+in the parent is separator. Reports whether right was merged into left, and otherwise returns
+the new separator key of right.
+*/
+func (t *bPlusTree) rebalancePair(left BPlusTreeNode, right BPlusTreeNode, separator int) (bool, int, error) {
+	switch l := left.(type) {
+	case *leafNode:
+		r, ok := right.(*leafNode)
+		if !ok {
+			break
+		}
+		if t.fitInOne(l, r) {
+			l.merge(r)
+			return true, separator, nil
+		}
+		l.redistribute(r)
+		assertSplit("leaf redistribute", l, r, r.keys[0])
+		return false, r.keys[0], nil
+	case *innerNode:
+		r, ok := right.(*innerNode)
+		if !ok {
+			break
+		}
+		if t.fitInOne(l, r) {
+			l.merge(r, separator)
+			return true, separator, nil
+		}
+		separator = l.redistribute(r, separator)
+		assertSplit("inner redistribute", l, r, separator)
+		return false, separator, nil
+	}
+	return false, separator, fmt.Errorf("%w: siblings %d and %d are not of the same type", ErrCorruptTree, left.getPageId(), right.getPageId())
+}
+
+// Returns the inner node on page parentId, which is the root or pinned by this call.
+func (t *bPlusTree) fetchParent(root *innerNode, parentId int) (*innerNode, error) {
+	if parentId == root.getPageId() {
+		return root, nil
+	}
+	node, err := fetchNodeByPage(t.bufferManager, t.metadata, parentId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load parent %d: %w", parentId, err)
+	}
+	parent, ok := node.(*innerNode)
+	if !ok {
+		t.bufferManager.Unpin(node.getFrame())
+		return nil, fmt.Errorf("%w: parent %d is not an inner node", ErrCorruptTree, parentId)
+	}
+	return parent, nil
+}
+
+// Replaces an inner root that has a single child by the child, which shrinks the tree by a
+// level, and frees the page of the old root.
+func (t *bPlusTree) shrinkRoot(root *innerNode) error {
+	child, err := fetchNodeByPage(t.bufferManager, t.metadata, int(root.children[0]))
+	if err != nil {
+		return fmt.Errorf("unable to load the only child of the root: %w", err)
+	}
+	// the child keeps the pin it was fetched with as the pin of the new root
+	t.metadata.height--
+	if err := t.updateRoot(child); err != nil {
+		return err // the old root stays allocated, since the header page still points to it
+	}
+	_, err = t.bufferManager.DeletePage(root.getPageId())
+	return err
+}
+
+// Returns the number of levels below the root, following the first child of every inner
+// node down to a leaf. All leaves are at the same depth, so any path gives the height.
+func (t *bPlusTree) measureHeight() (int, error) {
+	root, ok := t.Root.(*innerNode)
+	if !ok {
+		return 0, nil
+	}
+	if len(root.children) == 0 {
+		return 0, fmt.Errorf("%w: inner root %d has no children", ErrCorruptTree, root.getPageId())
+	}
+	height, pageId := 1, int(root.children[0])
+	for {
+		node, err := fetchNodeByPage(t.bufferManager, t.metadata, pageId)
+		if err != nil {
+			return 0, fmt.Errorf("unable to measure the height of the tree: %w", err)
+		}
+		t.bufferManager.Unpin(node.getFrame())
+		inner, ok := node.(*innerNode)
+		if !ok {
+			return height, nil
+		}
+		if len(inner.children) == 0 {
+			return 0, fmt.Errorf("%w: inner node %d has no children", ErrCorruptTree, pageId)
+		}
+		height, pageId = height+1, int(inner.children[0])
+	}
+}
+
+/*
+Reports whether inserting k would overflow, and so split, the leaf in which k belongs, without
+inserting it. This lets a bulk loader plan its batches around splits. The leaf is found with
+the same read-only descent as Get. Inserting an existing key never splits a leaf.
+*/
+func (t *bPlusTree) WouldSplit(k int) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	node, err := t.root()
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	leaf, ok := node.(*leafNode)
+	if !ok {
+		leaf, err = node.(*innerNode).findLeaf(k)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		defer t.bufferManager.Unpin(leaf.frame)
+	}
+	if _, found := leaf.locate(k); found {
+		return false
+	}
+	return leaf.getMaxSize()-leaf.getSize() < 1
+}
+
+/*
+Return the value associated with a given key, together with the page id of the leaf
+that holds the key. This lets locality-aware callers continue working around the key
+(eg. scanning its neighbours) without descending the tree again.
+
+If the key does not exist, the zero value and false are returned along with the page id
+of the leaf in which the key would be stored.
+*/
+func (t *bPlusTree) GetWithLeaf(k int) (int, int, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	node, err := t.root()
+	if err != nil {
+		log.Println(err)
+		return 0, memory.InvalidPageId, false
+	}
+	switch root := node.(type) {
+	case *leafNode:
+		v, ok := root.get(k)
+		return v, root.getPageId(), ok
+	case *innerNode:
+		leaf, err := root.findLeaf(k)
+		if err != nil {
+			log.Println(err)
+			return 0, memory.InvalidPageId, false
+		}
+		defer t.bufferManager.Unpin(leaf.frame)
+		v, ok := leaf.get(k)
+		return v, leaf.getPageId(), ok
+	}
+	return 0, memory.InvalidPageId, false
+}
+
+// LeafResult is the value of one of the keys looked up by GetFromLeaf.
+type LeafResult struct {
+	Val int  // the record id of the key, the zero value if the key was not found
+	OK  bool // whether the key was found
+}
+
+/*
+Returns the values of keys, in the order of keys, from the leaf on page leafPageId, which
+is loaded and pinned once for all of them. This is meant for keys known to live on one leaf,
+eg. a leaf found by ForEachLeafPage or GetWithLeaf, and saves a descent of the tree per key.
+
+The leaf is not checked to cover the keys: a key that lives on another leaf is reported as
+not found. If the page cannot be loaded, or is no longer a leaf of the tree (eg. after
+Compact), every key is reported as not found.
+*/
+func (t *bPlusTree) GetFromLeaf(leafPageId int, keys []int) []LeafResult {
+	results := make([]LeafResult, len(keys))
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	node, err := fetchNodeByPage(t.bufferManager, t.metadata, leafPageId)
+	if err != nil {
+		log.Println(err)
+		return results
+	}
+	defer t.bufferManager.Unpin(node.getFrame())
+	leaf, ok := node.(*leafNode)
+	if !ok {
+		log.Printf("page %d is not a leaf", leafPageId)
+		return results
+	}
+	for i, k := range keys {
+		results[i].Val, results[i].OK = leaf.get(k)
+	}
+	return results
+}
+
+/*
+Visits the nodes of the tree breadth-first, level by level from the root (level 0) down to
+the leaves, and left to right within a level.
+
+Nodes are loaded through the buffer pool from a queue of page ids, and each node is unpinned
+once fn returns, so fn must not hold on to the node after it returns. fn runs while the
+tree latch is held for reading, so it must not modify the tree.
+Returns an error if a page cannot be loaded.
+*/
+func (t *bPlusTree) LevelOrder(fn func(level int, node BPlusTreeNode)) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.levelOrder(fn)
+}
+
+// LevelOrder without taking the tree latch, which the caller holds.
+func (t *bPlusTree) levelOrder(fn func(level int, node BPlusTreeNode)) error {
+	type queued struct {
+		pageId int
+		level  int
+	}
+	queue := []queued{{pageId: t.rootPage(), level: 0}}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		node, err := fetchNodeByPage(t.bufferManager, t.metadata, next.pageId)
+		if err != nil {
+			return err
+		}
+		if inner, ok := node.(*innerNode); ok {
+			for _, child := range inner.children {
+				queue = append(queue, queued{pageId: int(child), level: next.level + 1})
+			}
+		}
+		fn(next.level, node)
+		t.bufferManager.Unpin(node.getFrame())
+	}
+	return nil
+}
+
+/*
+Returns the size of the index, computed by walking every node of the tree: the total number
+of pages, the number of bytes those pages occupy on disk, and the number of leaf and inner pages.
+Every page touched is unpinned again. Returns an error if a page cannot be loaded.
+*/
+func (t *bPlusTree) SizeInfo() (pages int, bytes int64, leafPages int, innerPages int, err error) {
+	err = t.LevelOrder(func(level int, node BPlusTreeNode) {
+		if node.isLeaf() {
+			leafPages++
+		} else {
+			innerPages++
+		}
+	})
+	pages = leafPages + innerPages
+	bytes = int64(pages) * io.PageSize
+	return pages, bytes, leafPages, innerPages, err
+}
+
+/*
+Visits every leaf page of the tree in key order, by walking the leaf sibling chain from
+the leftmost leaf. Each leaf is unpinned once fn returns, so fn must not hold on to the leaf
+after it returns. fn runs while the tree latch is held for reading, so it must not modify
+the tree. The walk stops at the first error returned by fn, which is returned.
+Returns an error if a page cannot be loaded.
+*/
+func (t *bPlusTree) ForEachLeafPage(fn func(pageId int, leaf *leafNode) error) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.forEachLeafFrom(firstChild, fn)
+}
+
+// Picks the first child of every inner node, to descend to the leftmost leaf.
+func firstChild(*innerNode) int {
+	return 0
+}
+
+// Like ForEachLeafPage, but the walk starts at the leaf reached by descending from the
+// root along the child pointers pick returns (see innerNode.descend).
+func (t *bPlusTree) forEachLeafFrom(pick func(*innerNode) int, fn func(pageId int, leaf *leafNode) error) error {
+	node, err := t.root()
+	if err != nil {
+		return err
+	}
+	var leaf *leafNode
+	switch root := node.(type) {
+	case *leafNode:
+		return fn(root.getPageId(), root)
+	case *innerNode:
+		l, err := root.descend(pick)
+		if err != nil {
+			return err
+		}
+		leaf = l
+	}
+	for {
+		err := fn(leaf.getPageId(), leaf)
+		next := leaf.rightSibling
+		t.bufferManager.Unpin(leaf.frame)
+		if err != nil || next == memory.InvalidPageId {
+			return err
+		}
+		node, err := fetchNodeByPage(t.bufferManager, t.metadata, next)
+		if err != nil {
+			return err
+		}
+		l, ok := node.(*leafNode)
+		if !ok {
+			t.bufferManager.Unpin(node.getFrame())
+			return fmt.Errorf("%w: right sibling %d of leaf %d is not a leaf", ErrCorruptTree, next, leaf.getPageId())
+		}
+		leaf = l
+	}
+}
+
+/*
+Visits every key of the tree in key order, until fn returns false. This is a lean variant of
+ForEachLeafPage for existence scans and key-set operations: only the keys of the leaf pages
+are deserialized, and their values (record ids or inline values) are never decoded.
+Returns an error if a page cannot be loaded.
+*/
+func (t *bPlusTree) ScanKeys(fn func(key int) bool) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	node, err := t.root()
+	if err != nil {
+		return err
+	}
+	if leaf, ok := node.(*leafNode); ok {
+		for _, k := range leaf.keys {
+			if !fn(k) {
+				break
+			}
+		}
+		return nil
+	}
+	pageId := int(node.(*innerNode).children[0])
+	for pageId != memory.InvalidPageId {
+		f, err := t.bufferManager.GetPage(pageId)
+		if err != nil {
+			return err
+		}
+		if getPageType(f) != 1 {
+			// descend to the leftmost leaf
+			inner, err := createInnerNodeFromPage(t.bufferManager, t.metadata, f)
+			t.bufferManager.Unpin(f)
+			if err != nil {
+				return fmt.Errorf("page %d: %w", pageId, err)
+			}
+			pageId = int(inner.children[0])
+			continue
+		}
+		keys, next, err := leafKeys(f.Data)
+		t.bufferManager.Unpin(f)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", pageId, err)
+		}
+		for _, k := range keys {
+			if !fn(k) {
+				return nil
+			}
+		}
+		pageId = next
+	}
+	return nil
+}
+
+/*
+Visits every entry of the tree in key order, until fn returns false, reading the pages
+straight from disk into a single page buffer instead of loading them through the buffer
+pool. A full scan of a cold index that does not fit in the buffer pool would otherwise evict
+every other page of the pool, while the pages the scan brings in are never used again.
+Pages that are in the buffer pool are read from their frames, so the scan sees every insert.
+Returns an error if a page cannot be read.
+*/
+func (t *bPlusTree) ColdScan(fn func(key int, rid int) bool) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	buf := t.bufferManager.PageBuffer()
+	pageId := t.rootPage()
+	for pageId != memory.InvalidPageId {
+		if err := t.bufferManager.ReadPageUncached(pageId, buf); err != nil {
+			return err
+		}
+		if binary.BigEndian.Uint32(buf) != 1 {
+			// descend to the leftmost leaf
+			inner := &innerNode{treeMetadata: t.metadata, bufferManager: t.bufferManager}
+			if _, err := inner.fromBytes(buf); err != nil {
+				return fmt.Errorf("page %d: %w", pageId, err)
+			}
+			if len(inner.children) == 0 {
+				return fmt.Errorf("%w: inner page %d has no children", ErrCorruptTree, pageId)
+			}
+			pageId = int(inner.children[0])
+			continue
+		}
+		leaf := &leafNode{treeMetadata: t.metadata, bufferManager: t.bufferManager}
+		if _, err := leaf.fromBytes(buf); err != nil {
+			return fmt.Errorf("page %d: %w", pageId, err)
+		}
+		for i, k := range leaf.keys {
+			if !fn(k, leaf.recordIds[i]) {
+				return nil
+			}
+		}
+		pageId = leaf.rightSibling
+	}
+	return nil
+}
+
+/*
+Returns all keys and their record ids in key order, read with a full scan of the leaves.
+This is meant for small indexes and tests: the whole index is copied into memory, so it
+should not be used on large trees. If a page cannot be loaded, the error is logged and
+the entries read so far are returned.
+*/
+func (t *bPlusTree) ToSlice() ([]int, []int) {
+	var keys, recordIds []int
+	err := t.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+		keys = append(keys, leaf.keys...)
+		recordIds = append(recordIds, leaf.recordIds...)
+		return nil
+	})
+	if err != nil {
+		log.Printf("unable to read all leaves: %+v", err)
+	}
+	return keys, recordIds
+}
+
+/*
+Returns the record ids of the keys k with lo <= k < hi, in key order. Only the record ids
+are returned, eg. for a query plan that scans the index and then fetches the records.
+See AppendRecordIdsInRange to reuse a slice across calls.
+*/
+func (t *bPlusTree) RecordIdsInRange(lo int, hi int) []int {
+	return t.AppendRecordIdsInRange(nil, lo, hi)
+}
+
+/*
+Appends the record ids of the keys k with lo <= k < hi to dst in key order, and returns the
+extended slice. The leaves are walked from the leaf covering lo, and their record ids are
+appended directly, so a dst with enough capacity is filled without allocating. If a page
+cannot be loaded, the error is logged and the record ids read so far are returned.
+*/
+func (t *bPlusTree) AppendRecordIdsInRange(dst []int, lo int, hi int) []int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.metadata.compare(lo, hi) >= 0 {
+		return dst
+	}
+	pick := func(n *innerNode) int { return n.childIndex(lo) }
+	err := t.forEachLeafFrom(pick, func(pageId int, leaf *leafNode) error {
+		start, _ := leaf.locate(lo)
+		end, _ := leaf.locate(hi)
+		if start < end {
+			dst = append(dst, leaf.recordIds[start:end]...)
+		}
+		if end < len(leaf.keys) {
+			return errStopWalk // the leaf holds a key >= hi, the range ends here
+		}
+		return nil
+	})
+	if err != nil && err != errStopWalk {
+		log.Printf("unable to read all leaves in range: %+v", err)
+	}
+	return dst
+}
+
+// Stops a walk over the leaves once the entry looked for is found.
+var errStopWalk = errors.New("stop walk")
+
+/*
+Returns the n-th smallest key (counting from 0) and its record id, and false if the tree
+holds n or fewer keys. The tree does not maintain per-subtree counts, so the leaves are
+scanned in key order until the n-th key is reached.
+*/
+func (t *bPlusTree) Select(n int) (key int, rid int, ok bool) {
+	if n < 0 {
+		return 0, 0, false
+	}
+	err := t.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+		if n < len(leaf.keys) {
+			key, rid, ok = leaf.keys[n], leaf.recordIds[n], true
+			return errStopWalk
+		}
+		n -= len(leaf.keys)
+		return nil
+	})
+	if err != nil && err != errStopWalk {
+		log.Println(err)
+		return 0, 0, false
+	}
+	return key, rid, ok
+}
+
+/*
+Returns the rank of key k, ie. the number of keys in the tree that are strictly less than k,
+and whether k itself exists. Like Select, the leaves are scanned in key order, up to the
+leaf that covers k.
+*/
+func (t *bPlusTree) Rank(k int) (rank int, exists bool) {
+	err := t.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+		pos, found := leaf.locate(k)
+		rank += pos
+		if found || pos < len(leaf.keys) {
+			exists = found
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && err != errStopWalk {
+		log.Println(err)
+	}
+	return rank, exists
+}
+
+/*
+Returns the entry with the smallest key strictly greater than k, and false if there's no
+such key. Unlike a ceiling lookup, k itself is never returned. When every key of the leaf
+covering k is less than or equal to k, the successor is the first key of a leaf to its right.
+*/
+func (t *bPlusTree) Successor(k int) (key int, rid int, ok bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	pick := func(n *innerNode) int { return n.childIndex(k) }
+	err := t.forEachLeafFrom(pick, func(pageId int, leaf *leafNode) error {
+		pos, found := leaf.locate(k)
+		if found {
+			pos++
+		}
+		if pos < len(leaf.keys) {
+			key, rid, ok = leaf.keys[pos], leaf.recordIds[pos], true
+			return errStopWalk
+		}
+		return nil
+	})
+	if err != nil && err != errStopWalk {
+		log.Println(err)
+		return 0, 0, false
+	}
+	return key, rid, ok
+}
+
+/*
+Returns the entry with the largest key strictly less than k, and false if there's no
+such key. Unlike a floor lookup, k itself is never returned. When every key of the leaf
+covering k is greater than or equal to k, the predecessor is the last key of the leaf to its left.
+*/
+func (t *bPlusTree) Predecessor(k int) (key int, rid int, ok bool) {
+	it := &Iterator{tree: t}
+	if err := it.load(func(n *innerNode) int { return n.childIndex(k) }); err != nil {
+		log.Println(err)
+		return 0, 0, false
+	}
+	// the position of the first key >= k, the entry before it is the predecessor
+	it.pos, _ = t.metadata.searchKeys(it.keys, k)
+	if it.pos == 0 && len(it.keys) > 0 {
+		// step onto the leaf to the left, whose last key is the predecessor
+		it.Prev()
+	} else {
+		it.pos--
+	}
+	if err := it.Err(); err != nil {
+		log.Println(err)
+		return 0, 0, false
+	}
+	if !it.Valid() {
+		return 0, 0, false
+	}
+	return it.Key(), it.Value(), true
+}
+
+/*
+Deletes every entry for which pred returns true, and returns the number of deleted entries.
+The leaves are visited in key order through the leaf sibling chain to collect the keys of the
+matching entries first, so the walk is not affected by the deletes. The keys are then removed
+one at a time like Remove does, which rebalances every leaf that drops below half full.
+*/
+func (t *bPlusTree) DeleteWhere(pred func(key int, rid int) bool) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var keys []int
+	err := t.forEachLeafFrom(firstChild, func(pageId int, leaf *leafNode) error {
+		for i := range leaf.keys {
+			if pred(leaf.keys[i], leaf.recordIds[i]) {
+				keys = append(keys, leaf.keys[i])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, k := range keys {
+		removed, err := t.remove(k)
+		if removed {
+			deleted++
+		}
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+var ErrNoInsertSequence = fmt.Errorf("tree does not store insert sequence numbers")
+
+/*
+Visits every entry of the tree in the order the entries were inserted, with the sequence
+number assigned on insert (or on the last overwrite, see WithOverwrite). The tree must be created with WithInsertSequence, otherwise
+ErrNoInsertSequence is returned. All entries are read and sorted in memory before fn is
+called, so this is meant for small trees. The scan stops at the first error returned by fn.
+*/
+func (t *bPlusTree) ScanByInsertOrder(fn func(key int, rid int, seq int) error) error {
+	if !t.metadata.insertSequence {
+		return ErrNoInsertSequence
+	}
+	type entry struct{ key, rid, seq int }
+	var entries []entry
+	err := t.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+		for i := range leaf.keys {
+			entries = append(entries, entry{leaf.keys[i], leaf.recordIds[i], leaf.sequences[i]})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return a.seq - b.seq })
+	for _, e := range entries {
+		if err := fn(e.key, e.rid, e.seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Visits the entries of the tree that were inserted or overwritten after sequence number since,
+ie. whose sequence number is greater than since, in key order. This supports incremental
+replication and change data capture: a consumer passes the largest sequence number it has
+seen so far. There is no index on sequence numbers, so every leaf is scanned. The tree must
+be created with WithInsertSequence, otherwise ErrNoInsertSequence is returned. The scan
+stops at the first error returned by fn.
+*/
+func (t *bPlusTree) ScanSince(since int, fn func(key int, rid int, seq int) error) error {
+	if !t.metadata.insertSequence {
+		return ErrNoInsertSequence
+	}
+	return t.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+		for i, seq := range leaf.sequences {
+			if seq <= since {
+				continue
+			}
+			if err := fn(leaf.keys[i], leaf.recordIds[i], seq); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/*
+Loads every inner (non-leaf) node of the tree into the buffer pool and keeps it pinned,
+so that a point lookup only ever has to read its leaf from disk. The buffer pool must be
+large enough to hold all inner nodes plus the pages of a descent; if the inner nodes do
+not fit, the pins taken so far are released and the error is returned.
+
+Inner nodes created by later splits are not pinned; call PinInternalNodes again to pin them.
+*/
+func (t *bPlusTree) PinInternalNodes() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.unpinInternalNodes()
+	node, err := t.root()
+	if err != nil {
+		return err
+	}
+	root, ok := node.(*innerNode)
+	if !ok {
+		return nil // the root is the only node, and it is already pinned
+	}
+
+	// all leaves are at the same depth, so the descent stops at the first leaf it finds
+	level := []*innerNode{root}
+	for len(level) > 0 {
+		var next []*innerNode
+	nodes:
+		for _, n := range level {
+			for _, child := range n.children {
+				node, err := fetchNodeByPage(t.bufferManager, t.metadata, int(child))
+				if err != nil {
+					t.unpinInternalNodes()
+					return err
+				}
+				inner, ok := node.(*innerNode)
+				if !ok {
+					t.bufferManager.Unpin(node.getFrame())
+					next = nil
+					break nodes
+				}
+				t.pinnedInner = append(t.pinnedInner, inner.frame)
+				next = append(next, inner)
+			}
+		}
+		level = next
+	}
+	return nil
+}
+
+// Releases the pins taken by PinInternalNodes.
+func (t *bPlusTree) UnpinInternalNodes() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.unpinInternalNodes()
+}
+
+func (t *bPlusTree) unpinInternalNodes() {
+	for _, f := range t.pinnedInner {
+		t.bufferManager.Unpin(f)
+	}
+	t.pinnedInner = nil
+}
+
+/*
+Close releases the pins the tree holds: the pin on the root, which is held for the
+lifetime of the tree, the pins taken by PinInternalNodes and the pin on the rightmost
+leaf kept by the append hint, and stops background maintenance. Dirty pages are not flushed,
+that's up to the owner of the buffer pool. The tree must not be used after it is closed;
+operations on a closed tree fail with ErrTreeClosed.
+*/
+func (t *bPlusTree) Close() {
+	t.StopMaintenance()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.unpinInternalNodes()
+	t.releaseAppendLeaf()
+	t.rootMu.Lock()
+	defer t.rootMu.Unlock()
+	if t.Root != nil {
+		t.bufferManager.Unpin(t.Root.getFrame())
+		t.Root = nil
+	}
+}
+
+// Returns the order (fanout) of the tree.
+func (t *bPlusTree) Order() int {
+	return t.metadata.order
+}
+
+/*
+Returns the root node of the tree. The root is kept pinned, so the cached root node
+normally stays valid. Should its frame no longer hold the root page pinned (eg. because
+the pin was released and the frame was reused for another page), the cached node would
+be stale, so the root is loaded again through the buffer pool by its page id instead.
+*/
+func (t *bPlusTree) root() (BPlusTreeNode, error) {
+	t.rootMu.Lock()
+	defer t.rootMu.Unlock()
+	if t.metadata == nil || t.bufferManager == nil {
+		// the tree was not created by NewBPlusTree
+		return nil, ErrTreeNotInitialized
+	}
+	if t.Root == nil {
+		return nil, ErrTreeClosed
+	}
+	if needed, size := t.framesNeeded(), t.bufferManager.Size(); size < needed {
+		return nil, fmt.Errorf("%w: %d frames, an operation on the tree pins up to %d", ErrBufferPoolTooSmall, size, needed)
+	}
+	if t.bufferManager.IsPinnedPage(t.Root.getFrame(), t.metadata.rootPageId) {
+		return t.Root, nil
+	}
+	node, err := fetchNodeByPage(t.bufferManager, t.metadata, t.metadata.rootPageId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reload the root: %w", err)
+	}
+	// the pin taken by the fetch is the pin the tree holds on to
+	t.Root = node
+	return node, nil
 }
 
-func (t *bPlusTree) updateRoot(newRoot BPlusTreeNode) {
+/*
+Returns the number of frames an operation on the tree pins at most: the root, which stays
+pinned, and below an inner root a node and the new sibling of its split (or on a removal, a
+leaf and its parent or sibling), plus the rightmost leaf pinned by the append hint and the
+header page while a new root is saved to it (see header.go). This does
+not grow with the height of the tree, since a descent unpins every inner node once it has
+read its child pointer. A pool shared by several trees needs a frame more for the pinned
+root of every other tree.
+*/
+func (t *bPlusTree) framesNeeded() int {
+	needed := 2 // a root leaf and the new sibling of its split
+	if !t.Root.isLeaf() {
+		needed = 3
+	}
+	if t.metadata.appendHint {
+		needed++
+	}
+	return needed + 1 // the header page, while a new root is saved
+}
+
+// Returns the page id of the root, read consistently with Root.
+func (t *bPlusTree) rootPage() int {
+	t.rootMu.Lock()
+	defer t.rootMu.Unlock()
+	if t.metadata == nil {
+		return memory.InvalidPageId
+	}
+	return t.metadata.rootPageId
+}
+
+/*
+Swaps in newRoot as the root of the tree. The root is pinned for as long as it is the root:
+newRoot must be pinned, and the tree takes over that pin, while the pin on the old root is released.
+
+Root and the root page id are swapped together under the root latch, so root() and rootPage()
+never see one without the other. Every operation reads the root once when it starts, and
+the tree latch keeps readers out while a writer swaps the root, so no reader descends from
+an old root whose page is being reused. Once readers no longer take the tree latch (eg. with
+snapshots), reuse of the old root page has to be deferred until no reader holds it.
+
+The new root page id is saved to the header page of the file. The root is swapped in either
+way; if the save fails, the error is returned and the header page still holds the old root
+page id, so the caller must not free the page of the old root.
+*/
+func (t *bPlusTree) updateRoot(newRoot BPlusTreeNode) error {
+	t.rootMu.Lock()
+	defer t.rootMu.Unlock()
+	if t.Root != nil {
+		t.bufferManager.Unpin(t.Root.getFrame())
+	}
 	t.Root = newRoot
 	t.metadata.rootPageId = newRoot.getPageId()
+	if err := t.metadata.Save(t.bufferManager); err != nil {
+		return fmt.Errorf("unable to save the root page id %d: %w", t.metadata.rootPageId, err)
+	}
+	return nil
+}
+
+// Returns the number of entries that fit on a leaf page, which depends on what is stored per entry.
+func (m *BPlusTreeMetadata) leafSlotCount() int {
+	entrySize := KeySize + m.recordIdSize
+	if m.inlineSize > 0 {
+		entrySize = KeySize + m.taggedValueSize()
+	}
+	if m.insertSequence {
+		entrySize += SequenceSize
+	}
+	return (io.PageSize - LeafPageHeaderSize) / entrySize
+}
+
+// Validates the configuration of the tree. A node holding order entries must fit in a page.
+func (m *BPlusTreeMetadata) validate() error {
+	if m.recordIdSize != 4 && m.recordIdSize != ValueTypeSize {
+		return fmt.Errorf("%w: %d bytes, expected 4 or %d", ErrInvalidRecordIdSize, m.recordIdSize, ValueTypeSize)
+	}
+	if m.inlineSize < 0 || m.inlineSize > MaxInlineValueSize {
+		return fmt.Errorf("%w: %d bytes is not within [0, %d]", ErrInvalidInlineSize, m.inlineSize, MaxInlineValueSize)
+	}
+	// a leaf holds up to order key/record id pairs and an inner node up to order children
+	// (see getMaxSize), which must fit on a page
+	maxOrder := min(m.leafSlotCount(), InternalPageSlotCount)
+	if m.order < MinOrder || m.order > maxOrder {
+		return fmt.Errorf("%w: order %d is not within [%d, %d]", ErrInvalidOrder, m.order, MinOrder, maxOrder)
+	}
+	return nil
+}
+
+// Binary searches keys, which are sorted in the order of the tree's comparator, for k. Returns the
+// position where k is found or would be inserted, and whether k was found.
+func (m *BPlusTreeMetadata) searchKeys(keys []int, k int) (int, bool) {
+	return slices.BinarySearchFunc(keys, k, m.compare)
 }
 
 func (m *BPlusTreeMetadata) isRootPage(pageId int) bool {
 	return m.rootPageId == pageId
 }
 
-func (m *BPlusTreeMetadata) getAncestor() *innerNode {
+func (m *BPlusTreeMetadata) getAncestor() int {
 	if len(m.seen) > 0 {
 		return m.seen[len(m.seen)-1]
 	}
-	return nil
+	return memory.InvalidPageId
 }
 
-// Returns the ancestor that was removed.
-// Returns nil when there aren't any ancestors to remove.
-func (m *BPlusTreeMetadata) removeAncestor() *innerNode {
+// Returns the page id of the ancestor that was removed.
+// Returns InvalidPageId when there aren't any ancestors to remove.
+func (m *BPlusTreeMetadata) removeAncestor() int {
 	n := len(m.seen)
 	if n > 0 {
 		val := m.seen[n-1]
-		m.seen = m.seen[:n]
+		m.seen = m.seen[:n-1]
 		return val
 	}
-	return nil
+	return memory.InvalidPageId
 }
 
 // PrettyPrint recursively prints the B+ tree structure
@@ -187,8 +1788,13 @@ func PrettyPrint(node BPlusTreeNode, level int, prefix string, isLast bool) {
 		// Recursively print each child
 		for i, childPageNum := range n.children {
 			isLastChild := i == len(n.children)-1
-			childNode, _ := fetchNodeByPage(n.bufferManager, n.treeMetadata, int(childPageNum))
+			childNode, err := fetchNodeByPage(n.bufferManager, n.treeMetadata, int(childPageNum))
+			if err != nil {
+				fmt.Printf("%s%s%s%v\n", prefix, childPrefix, connector, err)
+				continue
+			}
 			PrettyPrint(childNode, level+1, prefix+childPrefix, isLastChild)
+			n.bufferManager.Unpin(childNode.getFrame())
 		}
 	case *leafNode:
 		// fmt.Printf("%s%sLeaf Node: Keys: %v, RecordIds: %v, RightSibling: %d, PageId: %d\n",
@@ -0,0 +1,2016 @@
+package index
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"wtfDB/io"
+	"wtfDB/memory"
+)
+
+func Test_getDistinguishesMissingKeyFromValue(t *testing.T) {
+	tree := newTestTree(t, 10)
+
+	// -1 is a legitimate record id and must not be mistaken for a missing key
+	tree.Insert(7, -1)
+	v, ok := tree.Get(7)
+	assertEqual(t, true, ok, "key 7 was inserted")
+	assertEqual(t, -1, v, "")
+
+	v, ok = tree.Get(8)
+	assertEqual(t, false, ok, "key 8 was never inserted")
+	assertEqual(t, 0, v, "a missing key returns the zero value")
+
+	// Split the root leaf so that lookups go through an inner node
+	for i := 1; i <= 9; i++ {
+		tree.Insert(100+i, -i)
+	}
+	for i := 1; i <= 9; i++ {
+		v, ok = tree.Get(100 + i)
+		assertEqual(t, true, ok, "")
+		assertEqual(t, -i, v, "")
+	}
+	v, ok = tree.Get(7)
+	assertEqual(t, true, ok, "key 7 was inserted")
+	assertEqual(t, -1, v, "")
+	v, ok = tree.Get(200)
+	assertEqual(t, false, ok, "key 200 was never inserted")
+	assertEqual(t, 0, v, "a missing key returns the zero value")
+}
+
+func Test_getWithLeaf(t *testing.T) {
+	tree := newTestTree(t, 10)
+	for i := 1; i <= 9; i++ {
+		tree.Insert(100+i, i)
+	}
+
+	for i := 1; i <= 9; i++ {
+		v, leafPageId, ok := tree.GetWithLeaf(100 + i)
+		assertEqual(t, true, ok, "")
+		assertEqual(t, i, v, "")
+
+		// the returned page must be a leaf that holds the key
+		node, err := fetchNodeByPage(tree.bufferManager, tree.metadata, leafPageId)
+		if err != nil {
+			t.Fatalf("unable to fetch leaf page %d: %v", leafPageId, err)
+		}
+		assertEqual(t, true, node.isLeaf(), "")
+		leafValue, found := node.get(100 + i)
+		assertEqual(t, true, found, "leaf page should contain the key")
+		assertEqual(t, i, leafValue, "")
+		tree.bufferManager.Unpin(node.getFrame())
+	}
+
+	// a missing key reports the leaf it would be stored on
+	_, leafPageId, ok := tree.GetWithLeaf(0)
+	assertEqual(t, false, ok, "")
+	_, firstLeafPageId, _ := tree.GetWithLeaf(101)
+	assertEqual(t, firstLeafPageId, leafPageId, "key 0 belongs on the first leaf")
+}
+
+func Test_getFromLeaf(t *testing.T) {
+	tree := newTestTree(t, 16)
+	for k := range 50 {
+		tree.Insert(k, 100+k)
+	}
+	// scan to the second leaf
+	var leafPageId int
+	var leafKeys []int
+	n := 0
+	tree.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+		if n++; n == 2 {
+			leafPageId, leafKeys = pageId, slices.Clone(leaf.keys)
+			return errors.New("stop")
+		}
+		return nil
+	})
+	assertEqual(t, true, len(leafKeys) >= 2, "")
+
+	keys := []int{leafKeys[len(leafKeys)-1], leafKeys[0], 1000, 0}
+	results := tree.GetFromLeaf(leafPageId, keys)
+	assertEqual(t, 4, len(results), "")
+	assertEqual(t, LeafResult{Val: 100 + keys[0], OK: true}, results[0], "")
+	assertEqual(t, LeafResult{Val: 100 + keys[1], OK: true}, results[1], "")
+	assertEqual(t, LeafResult{}, results[2], "a missing key is not found")
+	assertEqual(t, LeafResult{}, results[3], "a key of another leaf is not found")
+
+	// a page that is not a leaf reports every key as not found
+	results = tree.GetFromLeaf(tree.RootPageId(), keys[:1])
+	assertEqual(t, LeafResult{}, results[0], "")
+}
+
+func Test_remove(t *testing.T) {
+	tree := newTestTree(t, 8)
+	for k := 1; k <= 9; k++ {
+		assertEqual(t, true, tree.Insert(k, k*10), "")
+	}
+	_, isLeaf := tree.Root.(*leafNode)
+	assertEqual(t, false, isLeaf, "the inserts split the root leaf")
+
+	removed := []int{1, 5, 9}
+	for _, k := range removed {
+		assertEqual(t, true, tree.Remove(k), fmt.Sprintf("remove key %d", k))
+		assertEqual(t, false, tree.Remove(k), fmt.Sprintf("remove key %d again", k))
+	}
+	assertEqual(t, false, tree.Remove(100), "remove a key that never existed")
+	for k := 1; k <= 9; k++ {
+		v, ok := tree.Get(k)
+		if slices.Contains(removed, k) {
+			assertEqual(t, false, ok, fmt.Sprintf("get removed key %d", k))
+			assertEqual(t, 0, v, "")
+			continue
+		}
+		assertEqual(t, true, ok, fmt.Sprintf("get key %d", k))
+		assertEqual(t, k*10, v, "")
+	}
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+
+	// a removed key can be inserted again, and removals are written to the page
+	assertEqual(t, true, tree.Insert(5, 55), "")
+	assertEqual(t, true, tree.bufferManager.FlushAllPages(), "")
+	reopened, err := OpenBPlusTree("primary", tree.bufferManager, tree.RootPageId())
+	assertEqual(t, nil, err, "")
+	keys, _ := reopened.ToSlice()
+	assertEqual(t, true, slices.Equal([]int{2, 3, 4, 5, 6, 7, 8}, keys), fmt.Sprint(keys))
+}
+
+func Test_removeRebalancesLeaves(t *testing.T) {
+	tree := newTestTree(t, 8)
+	for k := 1; k <= 16; k++ {
+		tree.Insert(k, k)
+	}
+	// the leaves are [1 2] [3 4] [5 6] [7 8] [9 10] [11 12] [13 14 15 16]
+	leafCount := func() int {
+		_, _, leaves, _, err := tree.SizeInfo()
+		assertEqual(t, nil, err, "")
+		return leaves
+	}
+	check := func(want []int) {
+		t.Helper()
+		keys, _ := tree.ToSlice()
+		assertEqual(t, true, slices.Equal(want, keys), fmt.Sprint(keys))
+		assertEqual(t, nil, tree.CheckIntegrity(), "")
+		orphans, err := tree.FindOrphanPages()
+		assertEqual(t, nil, err, "")
+		assertEqual(t, 0, len(orphans), fmt.Sprint(orphans))
+		tree.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+			if leaf != tree.Root && len(leaf.keys) > 0 {
+				assertEqual(t, false, leaf.underflows(), fmt.Sprintf("leaf %d %v", pageId, leaf.keys))
+			}
+			return nil
+		})
+	}
+	keys := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 14, 15, 16}
+	leaves := leafCount()
+
+	// [11] borrows from its right sibling, whose separator key in the parent changes
+	assertEqual(t, true, tree.Remove(12), "")
+	check(keys)
+	assertEqual(t, leaves, leafCount(), "a borrow keeps the leaves")
+	leaf, _ := tree.Root.(*innerNode).findLeaf(13)
+	assertEqual(t, true, slices.Equal([]int{11, 13}, leaf.keys), fmt.Sprint(leaf.keys))
+	tree.bufferManager.Unpin(leaf.frame)
+
+	// [2] is merged with [3 4]
+	assertEqual(t, true, tree.Remove(1), "")
+	keys = keys[1:]
+	check(keys)
+	assertEqual(t, leaves-1, leafCount(), "a merge frees a leaf")
+
+	// a leaf that is emptied is merged too, and its child pointer and separator key are removed
+	deleted, err := tree.DeleteWhere(func(key int, rid int) bool { return key == 5 })
+	assertEqual(t, 1, deleted, errMessage(err))
+	assertEqual(t, true, tree.Remove(6), "")
+	keys = slices.DeleteFunc(keys, func(k int) bool { return k == 5 || k == 6 })
+	check(keys)
+	assertEqual(t, leaves-2, leafCount(), "")
+	for _, k := range keys {
+		v, ok := tree.Get(k)
+		assertEqual(t, true, ok && v == k, fmt.Sprintf("get key %d", k))
+	}
+
+	_, _, _, err = tree.FreeSpaceReport()
+	assertEqual(t, nil, err, errMessage(err))
+
+	// a merge that leaves the root with a single child shrinks the tree to a root leaf
+	small := newTestTree(t, 8)
+	for k := 1; k <= 5; k++ {
+		small.Insert(k, k)
+	}
+	assertEqual(t, true, small.Remove(1), "")
+	root, isLeaf := small.Root.(*leafNode)
+	assertEqual(t, true, isLeaf, "")
+	assertEqual(t, true, slices.Equal([]int{2, 3, 4, 5}, root.keys), fmt.Sprint(root.keys))
+	assertEqual(t, root.getPageId(), small.RootPageId(), "")
+	_, _, _, err = small.FreeSpaceReport()
+	assertEqual(t, nil, err, errMessage(err))
+}
+
+func Test_removeWithPinnedSibling(t *testing.T) {
+	tree := newTestTree(t, 8)
+	for k := 1; k <= 16; k++ {
+		tree.Insert(k, k)
+	}
+	// the leaves are [1 2] [3 4] ..., and [3 4] is pinned outside the tree, eg. by a scan
+	_, _, leaves, _, _ := tree.SizeInfo()
+	sibling, err := tree.Root.(*innerNode).findLeaf(3)
+	assertEqual(t, nil, err, errMessage(err))
+
+	// [2] cannot be merged with its pinned sibling, whose page could not be freed
+	tree.mu.Lock()
+	removed, err := tree.remove(1)
+	tree.mu.Unlock()
+	assertEqual(t, true, removed, "")
+	assertEqual(t, true, errors.Is(err, ErrNodePinned), errMessage(err))
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	orphans, err := tree.FindOrphanPages()
+	assertEqual(t, nil, err, errMessage(err))
+	assertEqual(t, 0, len(orphans), fmt.Sprint(orphans))
+	_, _, leafCount, _, _ := tree.SizeInfo()
+	assertEqual(t, leaves, leafCount, "the sibling is not merged")
+	for k := 2; k <= 16; k++ {
+		v, ok := tree.Get(k)
+		assertEqual(t, true, ok && v == k, fmt.Sprintf("get key %d", k))
+	}
+
+	// once the pin is released, the next removal merges the leaves and frees the page
+	siblingId := sibling.getPageId()
+	tree.bufferManager.Unpin(sibling.frame)
+	assertEqual(t, true, tree.Remove(2), "")
+	assertEqual(t, false, tree.bufferManager.IsAllocated(siblingId), "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	_, _, _, err = tree.FreeSpaceReport()
+	assertEqual(t, nil, err, errMessage(err))
+}
+
+func Test_removeShrinksTree(t *testing.T) {
+	orders := map[string]func(keys []int){
+		"ascending":  func(keys []int) {},
+		"descending": func(keys []int) { slices.Reverse(keys) },
+		"random": func(keys []int) {
+			rand.New(rand.NewSource(7)).Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+		},
+	}
+	for name, shuffle := range orders {
+		t.Run(name, func(t *testing.T) {
+			tree := newTestTree(t, 8)
+			keys := make([]int, 200)
+			for i := range keys {
+				keys[i] = i
+				tree.Insert(i, i)
+			}
+			height := func() int {
+				levels, rootPageId := 0, tree.RootPageId()
+				err := tree.LevelOrder(func(level int, node BPlusTreeNode) {
+					levels = max(levels, level+1)
+					if node.getPageId() != rootPageId {
+						assertEqual(t, false, node.getSize() < node.getMaxSize()/2, fmt.Sprintf("page %d is underfull", node.getPageId()))
+					}
+				})
+				assertEqual(t, nil, err, "")
+				return levels
+			}
+			assertEqual(t, true, height() >= 4, "")
+
+			shuffle(keys)
+			for i, k := range keys {
+				assertEqual(t, true, tree.Remove(k), fmt.Sprintf("remove key %d", k))
+				if i%20 != 19 {
+					continue
+				}
+				assertEqual(t, nil, tree.CheckIntegrity(), "")
+				left := slices.Clone(keys[i+1:])
+				slices.Sort(left)
+				got, _ := tree.ToSlice()
+				assertEqual(t, true, slices.Equal(left, got), fmt.Sprint(got))
+				height()
+			}
+			assertEqual(t, 1, height(), "the tree shrinks to a root leaf")
+			assertEqual(t, true, tree.Root.isLeaf(), "")
+			_, _, _, err := tree.FreeSpaceReport()
+			assertEqual(t, nil, err, errMessage(err))
+		})
+	}
+}
+
+func Test_removeEveryKey(t *testing.T) {
+	tree := newTestTree(t, 8)
+	for k := range 100 {
+		tree.Insert(k, k)
+	}
+	for k := range 100 {
+		assertEqual(t, true, tree.Remove(k), "")
+	}
+
+	// the tree collapses to a single empty root leaf, like a new tree
+	root, ok := tree.Root.(*leafNode)
+	assertEqual(t, true, ok, "the root is a leaf")
+	assertEqual(t, 0, len(root.keys), "")
+	assertEqual(t, 0, tree.metadata.height, "")
+	keys, _ := tree.ToSlice()
+	assertEqual(t, 0, len(keys), "")
+	_, used, _, err := tree.FreeSpaceReport()
+	assertEqual(t, nil, err, errMessage(err))
+	assertEqual(t, 2, used, "only the header page and the root leaf are left")
+	_, err = tree.GetE(0)
+	assertEqual(t, true, errors.Is(err, ErrKeyNotFound), errMessage(err))
+	assertEqual(t, false, tree.Remove(0), "")
+
+	// and grows again as keys are inserted
+	for k := range 100 {
+		assertEqual(t, true, tree.Insert(k, k*2), "")
+	}
+	for k := range 100 {
+		v, ok := tree.Get(k)
+		assertEqual(t, true, ok, "")
+		assertEqual(t, k*2, v, "")
+	}
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
+
+func Test_bufferPoolTooSmall(t *testing.T) {
+	dm := io.NewDiskManager(filepath.Join(t.TempDir(), "index_test"))
+	t.Cleanup(dm.(*io.DefaultDiskManager).Shutdown)
+	bpm := memory.NewBufferPoolManager(dm, 16)
+	tree, err := NewBPlusTree("primary", bpm, NewBPlusTreeMetadata("primary"))
+	assertEqual(t, nil, err, "")
+	for k := range 100 {
+		assertEqual(t, true, tree.Insert(k, k), "")
+	}
+	_, _, _, innerPages, err := tree.SizeInfo()
+	assertEqual(t, nil, err, "")
+	assertEqual(t, true, innerPages > 1, "the tree has three levels")
+	assertEqual(t, true, bpm.FlushAllPages(), "")
+
+	// two frames hold the pinned root and a single node below it, which is not enough for a
+	// split, so every operation fails up front instead of running out of frames halfway
+	small, err := OpenBPlusTree("primary", memory.NewBufferPoolManager(dm, 2), tree.RootPageId())
+	assertEqual(t, nil, err, "")
+	_, _, err = small.InsertWithInfo(100, 100)
+	assertEqual(t, true, errors.Is(err, ErrBufferPoolTooSmall), errMessage(err))
+	_, ok := small.Get(1)
+	assertEqual(t, false, ok, "")
+
+	// a tree whose root is a leaf only needs a frame for the sibling of a root split, and one
+	// for the header page to save the new root
+	leafTree := newTestTree(t, 3)
+	for k := range 4 {
+		assertEqual(t, true, leafTree.Insert(k, k), "")
+	}
+	_, ok = leafTree.Get(3)
+	assertEqual(t, true, ok, "")
+}
+
+func Test_getE(t *testing.T) {
+	tree := newTestTree(t, 8)
+	for k := range 20 {
+		tree.Insert(k, k*10)
+	}
+	v, err := tree.GetE(7)
+	assertEqual(t, nil, err, errMessage(err))
+	assertEqual(t, 70, v, "")
+	_, err = tree.GetE(20)
+	assertEqual(t, true, errors.Is(err, ErrKeyNotFound), errMessage(err))
+
+	// a failed lookup reports its own error rather than a missing key
+	tree.Close()
+	_, err = tree.GetE(7)
+	assertEqual(t, ErrTreeClosed, err, "")
+	var nilTree *bPlusTree
+	_, err = nilTree.GetE(7)
+	assertEqual(t, ErrTreeNotInitialized, err, "")
+}
+
+func Test_childOfWrongType(t *testing.T) {
+	tree := newTestTree(t, 16)
+	for k := range 200 {
+		assertEqual(t, true, tree.Insert(k, k), "")
+	}
+	assertEqual(t, true, tree.metadata.height >= 3, "the leaves are at least three levels below the root")
+	root := tree.Root.(*innerNode)
+	fetchInner := func(pageId uint64) *innerNode {
+		node, err := fetchNodeByPage(tree.bufferManager, tree.metadata, int(pageId))
+		assertEqual(t, nil, err, errMessage(err))
+		return node.(*innerNode)
+	}
+	write := func(n *innerNode) {
+		assertEqual(t, nil, n.toBytes(), "")
+		tree.bufferManager.MarkDirty(n.frame)
+	}
+
+	// a node on the level above the leaves points at an inner node of its own level
+	left, right := fetchInner(root.children[0]), fetchInner(root.children[1])
+	grandchild := fetchInner(right.children[0])
+	grandchild.children[0] = left.children[0]
+	write(grandchild)
+	_, err := tree.GetE(root.keys[1])
+	assertEqual(t, true, errors.Is(err, ErrCorruptTree), errMessage(err))
+	assertEqual(t, true, strings.Contains(errMessage(err), "is an inner node, expected a leaf"), errMessage(err))
+	tree.bufferManager.Unpin(grandchild.frame)
+
+	// the root points at a leaf, two levels above the leaves
+	leaf, err := root.findLeaf(0)
+	assertEqual(t, nil, err, errMessage(err))
+	root.children[1] = uint64(leaf.getPageId())
+	write(root)
+	_, err = tree.GetE(root.keys[1])
+	assertEqual(t, true, errors.Is(err, ErrCorruptTree), errMessage(err))
+	assertEqual(t, true, strings.Contains(errMessage(err), "is a leaf, expected an inner node"), errMessage(err))
+	assertEqual(t, false, tree.Insert(1000, 1000), "")
+	tree.bufferManager.Unpin(leaf.frame)
+	tree.bufferManager.Unpin(left.frame)
+	tree.bufferManager.Unpin(right.frame)
+	assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "only the root stays pinned")
+}
+
+func Test_removeAncestor(t *testing.T) {
+	m := NewBPlusTreeMetadata("primary")
+	m.seen = append(m.seen, 3, 7, 11) // the root, then two inner nodes on the way down
+	for _, want := range []int{11, 7, 3} {
+		assertEqual(t, want, m.getAncestor(), "")
+		assertEqual(t, want, m.removeAncestor(), "ancestors are removed last in, first out")
+	}
+	assertEqual(t, 0, len(m.seen), "")
+	assertEqual(t, memory.InvalidPageId, m.getAncestor(), "")
+	assertEqual(t, memory.InvalidPageId, m.removeAncestor(), "")
+}
+
+func Test_treeNotInitialized(t *testing.T) {
+	// a pool without frames cannot hold the root leaf of a new tree
+	_, err := NewBPlusTree("primary", newTestBufferPool(t, 0), NewBPlusTreeMetadata("primary"))
+	assertEqual(t, true, errors.Is(err, ErrTreeNotInitialized), errMessage(err))
+	assertEqual(t, true, errors.Is(err, memory.ErrBufferPoolFull), errMessage(err))
+
+	// a tree that was not created by NewBPlusTree fails cleanly instead of panicking
+	var nilTree *bPlusTree
+	assertEqual(t, false, nilTree.Insert(1, 1), "")
+	_, ok := nilTree.Get(1)
+	assertEqual(t, false, ok, "")
+	_, _, err = nilTree.InsertWithInfo(1, 1)
+	assertEqual(t, ErrTreeNotInitialized, err, "")
+	zeroTree := &bPlusTree{}
+	assertEqual(t, false, zeroTree.Insert(1, 1), "")
+	_, ok = zeroTree.Get(1)
+	assertEqual(t, false, ok, "")
+	_, _, err = zeroTree.InsertWithInfo(1, 1)
+	assertEqual(t, ErrTreeNotInitialized, err, "")
+}
+
+func Test_verifyOnOpen(t *testing.T) {
+	tree := newTestTree(t, 10)
+	for i := 1; i <= 3; i++ {
+		tree.Insert(100+i, i)
+	}
+
+	// reopening a valid tree passes verification
+	m := NewBPlusTreeMetadata("primary", WithVerifyOnOpen())
+	m.rootPageId = tree.metadata.rootPageId
+	reopened, err := NewBPlusTree("primary", tree.bufferManager, m)
+	if err != nil {
+		t.Fatalf("unexpected error when opening a valid tree: %v", err)
+	}
+	v, ok := reopened.Get(102)
+	assertEqual(t, true, ok, "")
+	assertEqual(t, 2, v, "")
+
+	// root page id points at a page that was never written
+	m = NewBPlusTreeMetadata("primary", WithVerifyOnOpen())
+	m.rootPageId = 50
+	_, err = NewBPlusTree("primary", tree.bufferManager, m)
+	assertEqual(t, true, errors.Is(err, ErrInvalidRootPage), errMessage(err))
+}
+
+func Test_levelOrder(t *testing.T) {
+	tree := newTestTree(t, 64)
+	for i := 1; i <= 40; i++ {
+		tree.Insert(i, i)
+	}
+
+	var levels []int
+	var pageIds []int
+	childrenByLevel := map[int][]uint64{}
+	err := tree.LevelOrder(func(level int, node BPlusTreeNode) {
+		levels = append(levels, level)
+		pageIds = append(pageIds, node.getPageId())
+		if inner, ok := node.(*innerNode); ok {
+			childrenByLevel[level] = append(childrenByLevel[level], inner.children...)
+		}
+		// leaves are only ever found at the bottom level
+		assertEqual(t, len(childrenByLevel) == level, node.isLeaf(), "")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertEqual(t, 0, levels[0], "the root is visited first")
+	assertEqual(t, tree.Root.getPageId(), pageIds[0], "")
+	i := 1
+	for level := 0; level < len(childrenByLevel); level++ {
+		// the next level is made up of exactly the children of this level, in order
+		for _, child := range childrenByLevel[level] {
+			assertEqual(t, level+1, levels[i], "")
+			assertEqual(t, int(child), pageIds[i], "")
+			i++
+		}
+	}
+	assertEqual(t, len(pageIds), i, "every node is visited once")
+	assertEqual(t, true, len(childrenByLevel) >= 3, "expected a tree with at least four levels")
+}
+
+func Test_order(t *testing.T) {
+	var leafCounts, heights []int
+	for _, order := range []int{3, 4, 7} {
+		tree := newTestTree(t, 128, WithOrder(order))
+		assertEqual(t, order, tree.Order(), "")
+		for i := 1; i <= 30; i++ {
+			tree.Insert(i, i)
+		}
+		if err := tree.CheckIntegrity(); err != nil {
+			t.Fatalf("order %d: %v", order, err)
+		}
+		for i := 1; i <= 30; i++ {
+			_, ok := tree.Get(i)
+			assertEqual(t, true, ok, "")
+		}
+
+		height := 0
+		_, _, leafPages, _, _ := tree.SizeInfo()
+		tree.LevelOrder(func(level int, node BPlusTreeNode) {
+			height = max(height, level+1)
+			assertEqual(t, true, node.getSize() <= 2*order, "node exceeds the order of the tree")
+		})
+		leafCounts = append(leafCounts, leafPages)
+		heights = append(heights, height)
+	}
+	// a larger order packs the same keys into fewer, fuller leaves and a shallower tree
+	assertEqual(t, true, leafCounts[0] > leafCounts[1] && leafCounts[1] > leafCounts[2], fmt.Sprintf("leaf counts %v", leafCounts))
+	assertEqual(t, true, heights[0] > heights[2], fmt.Sprintf("heights %v", heights))
+
+	for _, order := range []int{0, 2, min(LeafPageSlotCount, InternalPageSlotCount) + 1} {
+		_, err := NewBPlusTree("primary", newTestBufferPool(t, 4), NewBPlusTreeMetadata("primary", WithOrder(order)))
+		assertEqual(t, true, errors.Is(err, ErrInvalidOrder), fmt.Sprintf("order %d: %v", order, err))
+	}
+}
+
+func Test_maxOrder(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithInsertSequence()}} {
+		order := min(NewBPlusTreeMetadata("primary", opts...).leafSlotCount(), InternalPageSlotCount)
+		// a small pool evicts the full nodes, so they are written to and read back from their pages
+		tree := newTestTree(t, 8, append(opts, WithOrder(order))...)
+		assertEqual(t, order, tree.Order(), "")
+		for _, k := range rand.New(rand.NewSource(7)).Perm(20 * order) {
+			assertEqual(t, true, tree.Insert(k, k), "")
+		}
+		assertEqual(t, nil, tree.CheckIntegrity(), "")
+		for k := range 20 * order {
+			v, ok := tree.Get(k)
+			assertEqual(t, true, ok, fmt.Sprintf("order %d: key %d", order, k))
+			assertEqual(t, k, v, "")
+		}
+	}
+}
+
+func Test_dirtyAndPinnedPagesAfterInserts(t *testing.T) {
+	tree := newTestTree(t, 64)
+	for i := 1; i <= 40; i++ {
+		tree.Insert(i, i)
+	}
+	pages, _, _, _, err := tree.SizeInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, pages, tree.bufferManager.DirtyPageCount(), "every page of the tree is dirty before a flush")
+	assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "only the root stays pinned")
+
+	assertEqual(t, true, tree.bufferManager.FlushAllPages(), "")
+	assertEqual(t, 0, tree.bufferManager.DirtyPageCount(), "")
+
+	tree.Insert(41, 41)
+	assertEqual(t, true, tree.bufferManager.DirtyPageCount() >= 1, "an insert dirties the leaf it lands in")
+}
+
+func Test_pinInternalNodes(t *testing.T) {
+	tree := newTestTree(t, 48)
+	keys := rand.New(rand.NewSource(20)).Perm(200)
+	for _, k := range keys {
+		tree.Insert(k, k)
+	}
+	_, _, _, innerPages, err := tree.SizeInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.PinInternalNodes(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, innerPages, tree.bufferManager.PinnedPageCount(), "every inner node is pinned")
+	for _, k := range keys {
+		before := tree.bufferManager.Stats()
+		v, ok := tree.Get(k)
+		assertEqual(t, true, ok, "")
+		assertEqual(t, k, v, "")
+		misses := tree.bufferManager.Stats().Misses - before.Misses
+		assertEqual(t, true, misses <= 1, fmt.Sprintf("get(%d) missed %d pages", k, misses))
+	}
+
+	tree.UnpinInternalNodes()
+	assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "only the root stays pinned")
+
+	// the inner nodes do not fit into a pool that is too small
+	small := newTestTree(t, 8)
+	for _, k := range keys {
+		small.Insert(k, k)
+	}
+	assertEqual(t, true, small.PinInternalNodes() != nil, "")
+	assertEqual(t, 1, small.bufferManager.PinnedPageCount(), "pins are released when the inner nodes do not fit")
+}
+
+func Test_splitWithFullPool(t *testing.T) {
+	dm := io.NewDiskManager(filepath.Join(t.TempDir(), "index_test"))
+	t.Cleanup(dm.(*io.DefaultDiskManager).Shutdown)
+	bpm := memory.NewBufferPoolManager(dm, 64)
+	tree, err := NewBPlusTree("primary", bpm, NewBPlusTreeMetadata("primary"))
+	assertEqual(t, nil, err, "")
+	for k := range 200 {
+		tree.Insert(10*k, k)
+	}
+	_, _, _, innerPages, err := tree.SizeInfo()
+	assertEqual(t, nil, err, "")
+	assertEqual(t, true, bpm.FlushAllPages(), "")
+
+	// the pinned inner nodes leave a single frame, for the leaf of a descent, so a split
+	// releases them to make room for its new sibling
+	pinned, err := OpenBPlusTree("primary", memory.NewBufferPoolManager(dm, innerPages+1), tree.RootPageId())
+	assertEqual(t, nil, err, "")
+	assertEqual(t, nil, pinned.PinInternalNodes(), "")
+	for k := range 100 {
+		if k%10 == 0 {
+			continue
+		}
+		inserted, _, err := pinned.InsertWithInfo(k, k)
+		assertEqual(t, nil, err, errMessage(err))
+		assertEqual(t, true, inserted, "")
+	}
+	assertEqual(t, nil, pinned.CheckIntegrity(), "")
+
+	// with every other frame pinned, a split fails and leaves the tree as it was
+	small := newTestTree(t, 4)
+	for k := range 4 {
+		small.Insert(k, k)
+	}
+	var hogs []*memory.Frame
+	for f, err := small.bufferManager.GetNewPageFrame(); err == nil; f, err = small.bufferManager.GetNewPageFrame() {
+		hogs = append(hogs, f)
+	}
+	inserted, _, err := small.InsertWithInfo(4, 4)
+	assertEqual(t, true, errors.Is(err, memory.ErrBufferPoolFull), errMessage(err))
+	assertEqual(t, false, inserted, "")
+	_, ok := small.Get(4)
+	assertEqual(t, false, ok, "")
+	assertEqual(t, false, small.Insert(4, 4), "")
+	for _, f := range hogs {
+		small.bufferManager.Unpin(f)
+		small.bufferManager.DeletePage(f.PageId)
+	}
+	inserted, _, err = small.InsertWithInfo(4, 4)
+	assertEqual(t, nil, err, errMessage(err))
+	assertEqual(t, true, inserted, "")
+	assertEqual(t, nil, small.CheckIntegrity(), "")
+}
+
+func Test_insertWithInfo(t *testing.T) {
+	tree := newTestTree(t, 64)
+	height := func() int {
+		h := 0
+		tree.LevelOrder(func(level int, node BPlusTreeNode) {
+			h = max(h, level+1)
+		})
+		return h
+	}
+
+	for i := 1; i <= 100; i++ {
+		before := height()
+		inserted, grew, err := tree.InsertWithInfo(i, i)
+		assertEqual(t, nil, err, "")
+		assertEqual(t, true, inserted, "")
+		assertEqual(t, height() == before+1, grew, fmt.Sprintf("insert of %d", i))
+	}
+	assertEqual(t, true, height() > 2, "the tree grew more than once")
+}
+
+func Test_forEachLeafPage(t *testing.T) {
+	tree := newTestTree(t, 64)
+	for _, k := range rand.New(rand.NewSource(25)).Perm(120) {
+		tree.Insert(k, k)
+	}
+	_, _, leafPages, _, err := tree.SizeInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	visited := make(map[int]bool)
+	next := 0
+	err = tree.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+		assertEqual(t, false, visited[pageId], fmt.Sprintf("leaf %d visited twice", pageId))
+		visited[pageId] = true
+		for _, k := range leaf.keys {
+			assertEqual(t, next, k, "leaves are visited in key order")
+			next++
+		}
+		return nil
+	})
+	assertEqual(t, nil, err, "")
+	assertEqual(t, leafPages, len(visited), "")
+	assertEqual(t, 120, next, "")
+	assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "")
+
+	stop := errors.New("stop")
+	count := 0
+	err = tree.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	assertEqual(t, stop, err, "")
+	assertEqual(t, 3, count, "the walk stops at the first error")
+	assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "")
+}
+
+func Test_toSlice(t *testing.T) {
+	tree := newTestTree(t, 64)
+	keys, rids := tree.ToSlice()
+	assertEqual(t, 0, len(keys), "")
+	assertEqual(t, 0, len(rids), "")
+
+	inserted := rand.New(rand.NewSource(27)).Perm(100)
+	for _, k := range inserted {
+		tree.Insert(k*3, k*7)
+	}
+	slices.Sort(inserted)
+
+	keys, rids = tree.ToSlice()
+	assertEqual(t, len(inserted), len(keys), "")
+	assertEqual(t, len(inserted), len(rids), "")
+	for i, k := range inserted {
+		assertEqual(t, k*3, keys[i], "")
+		assertEqual(t, k*7, rids[i], "")
+	}
+}
+
+func Test_scanSince(t *testing.T) {
+	tree := newTestTree(t, 64, WithInsertSequence(), WithOverwrite())
+	batches := rand.New(rand.NewSource(84)).Perm(80)
+	for _, k := range batches[:40] {
+		tree.Insert(k, k)
+	}
+	lastSeq := -1
+	tree.ScanByInsertOrder(func(key int, rid int, seq int) error {
+		lastSeq = seq
+		return nil
+	})
+
+	second := map[int]bool{}
+	for _, k := range batches[40:] {
+		tree.Insert(k, k)
+		second[k] = true
+	}
+	// an overwrite counts as a change
+	tree.Insert(batches[0], -1)
+	second[batches[0]] = true
+
+	var keys []int
+	err := tree.ScanSince(lastSeq, func(key int, rid int, seq int) error {
+		assertEqual(t, true, second[key], fmt.Sprintf("key %d was not changed in the second batch", key))
+		assertEqual(t, true, seq > lastSeq, "")
+		keys = append(keys, key)
+		return nil
+	})
+	assertEqual(t, nil, err, "")
+	assertEqual(t, len(second), len(keys), "")
+	assertEqual(t, true, slices.IsSorted(keys), "entries are visited in key order")
+
+	plain := newTestTree(t, 4)
+	err = plain.ScanSince(0, func(key int, rid int, seq int) error { return nil })
+	assertEqual(t, ErrNoInsertSequence, err, "")
+}
+
+func Test_scanByInsertOrder(t *testing.T) {
+	tree := newTestTree(t, 64, WithInsertSequence())
+	inserted := rand.New(rand.NewSource(28)).Perm(60)
+	for _, k := range inserted {
+		tree.Insert(k, k*2)
+	}
+
+	var order []int
+	err := tree.ScanByInsertOrder(func(key int, rid int, seq int) error {
+		assertEqual(t, len(order), seq, "sequence numbers increase with every insert")
+		assertEqual(t, key*2, rid, "")
+		order = append(order, key)
+		return nil
+	})
+	assertEqual(t, nil, err, "")
+	assertEqual(t, true, slices.Equal(inserted, order), "entries are visited in insert order")
+
+	// a reopened tree continues numbering after the latest insert
+	m := NewBPlusTreeMetadata("primary", WithInsertSequence())
+	m.rootPageId = tree.metadata.rootPageId
+	reopened, err := NewBPlusTree("primary", tree.bufferManager, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.Insert(1000, 1)
+	last := -1
+	reopened.ScanByInsertOrder(func(key int, rid int, seq int) error {
+		last = key
+		assertEqual(t, true, seq < 60 || key == 1000, "")
+		return nil
+	})
+	assertEqual(t, 1000, last, "")
+
+	plain := newTestTree(t, 4)
+	err = plain.ScanByInsertOrder(func(key int, rid int, seq int) error { return nil })
+	assertEqual(t, ErrNoInsertSequence, err, "")
+}
+
+func Test_insertOnFullBufferPool(t *testing.T) {
+	const poolSize = 24
+	tree := newTestTree(t, poolSize)
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	// every frame the tree does not hold is pinned by someone else
+	var held []*memory.Frame
+	for {
+		f, err := tree.bufferManager.GetNewPageFrame()
+		if err != nil {
+			break
+		}
+		held = append(held, f)
+	}
+
+	_, _, err := tree.InsertWithInfo(1000, 1)
+	assertEqual(t, true, errors.Is(err, memory.ErrBufferPoolFull), errMessage(err))
+	assertEqual(t, poolSize, tree.bufferManager.PinnedPageCount(), "the descent releases its pins")
+
+	for _, f := range held {
+		tree.bufferManager.Unpin(f)
+	}
+	inserted, _, err := tree.InsertWithInfo(1000, 1)
+	assertEqual(t, true, inserted, errMessage(err))
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
+
+func Test_insertReleasesPinnedInnerNodesOnFullBufferPool(t *testing.T) {
+	const poolSize = 48
+	tree := newTestTree(t, poolSize)
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	if err := tree.PinInternalNodes(); err != nil {
+		t.Fatal(err)
+	}
+	var held []*memory.Frame
+	for {
+		f, err := tree.bufferManager.GetNewPageFrame()
+		if err != nil {
+			break
+		}
+		held = append(held, f)
+	}
+	defer func() {
+		for _, f := range held {
+			tree.bufferManager.Unpin(f)
+		}
+	}()
+
+	// the leaf does not fit until the pinned inner nodes are released
+	inserted, _, err := tree.InsertWithInfo(1000, 1)
+	assertEqual(t, true, inserted, errMessage(err))
+	v, ok := tree.Get(1000)
+	assertEqual(t, true, ok, "")
+	assertEqual(t, 1, v, "")
+}
+
+func Test_readErrorAtEveryLevel(t *testing.T) {
+	dm := &faultyDiskManager{DiskManager: io.NewDiskManager(filepath.Join(t.TempDir(), "index_test"))}
+	t.Cleanup(dm.DiskManager.(*io.DefaultDiskManager).Shutdown)
+	tree, err := NewBPlusTree("primary", memory.NewBufferPoolManager(dm, 64), NewBPlusTreeMetadata("primary"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100 {
+		tree.Insert(i, i)
+	}
+	assertEqual(t, true, tree.bufferManager.FlushAllPages(), "")
+
+	// the page ids on the path from the root to the leaf of key 50
+	const k = 50
+	path := []int{tree.metadata.rootPageId}
+	for node := tree.Root; !node.isLeaf(); {
+		inner := node.(*innerNode)
+		childPageId := int(inner.children[inner.childIndex(k)])
+		path = append(path, childPageId)
+		if node, err = fetchNodeByPage(tree.bufferManager, tree.metadata, childPageId); err != nil {
+			t.Fatal(err)
+		}
+		tree.bufferManager.Unpin(node.getFrame())
+	}
+	assertEqual(t, true, len(path) >= 3, "the tree has inner nodes below the root")
+
+	for level, pageId := range path {
+		dm.failReadOf = pageId
+		m := NewBPlusTreeMetadata("primary")
+		m.rootPageId = tree.metadata.rootPageId
+		reopened, err := NewBPlusTree("primary", memory.NewBufferPoolManager(dm, 64), m)
+		if level == 0 {
+			assertEqual(t, true, errors.Is(err, errInjectedRead), errMessage(err))
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, ok := reopened.Get(k)
+		assertEqual(t, false, ok, fmt.Sprintf("get with unreadable page at level %d", level))
+		_, _, err = reopened.InsertWithInfo(k, 1)
+		assertEqual(t, true, errors.Is(err, errInjectedRead), errMessage(err))
+		assertEqual(t, true, errors.Is(reopened.CheckIntegrity(), errInjectedRead), "")
+		assertEqual(t, 1, reopened.bufferManager.PinnedPageCount(), "failed reads release their pins")
+	}
+}
+
+func Test_deleteWhere(t *testing.T) {
+	tree := newTestTree(t, 64)
+	for _, k := range rand.New(rand.NewSource(33)).Perm(100) {
+		tree.Insert(k, k)
+	}
+
+	deleted, err := tree.DeleteWhere(func(key int, rid int) bool { return key%2 == 0 })
+	assertEqual(t, nil, err, "")
+	assertEqual(t, 50, deleted, "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+
+	keys, _ := tree.ToSlice()
+	assertEqual(t, 50, len(keys), "")
+	for i, k := range keys {
+		assertEqual(t, 2*i+1, k, "only odd keys remain")
+	}
+	_, ok := tree.Get(42)
+	assertEqual(t, false, ok, "")
+
+	// deleted keys can be inserted again
+	assertEqual(t, true, tree.Insert(42, 7), "")
+	v, ok := tree.Get(42)
+	assertEqual(t, true, ok, "")
+	assertEqual(t, 7, v, "")
+
+	deleted, err = tree.DeleteWhere(func(key int, rid int) bool { return false })
+	assertEqual(t, nil, err, "")
+	assertEqual(t, 0, deleted, "")
+}
+
+func Test_deleteWhereRange(t *testing.T) {
+	tree := newTestTree(t, 16)
+	for k := range 60 {
+		tree.Insert(k, k)
+	}
+
+	// a contiguous range empties whole leaves, which are merged away
+	deleted, err := tree.DeleteWhere(func(key int, rid int) bool { return key >= 10 && key < 40 })
+	assertEqual(t, nil, err, "")
+	assertEqual(t, 30, deleted, "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	_, _, _, err = tree.FreeSpaceReport()
+	assertEqual(t, nil, err, errMessage(err))
+	keys, _ := tree.ToSlice()
+	assertEqual(t, 30, len(keys), "")
+	assertEqual(t, 9, keys[9], "")
+	assertEqual(t, 40, keys[10], "")
+
+	for k := 40; k < 50; k++ {
+		assertEqual(t, true, tree.Remove(k), "")
+	}
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
+
+func Test_maxKeysPerNode(t *testing.T) {
+	for _, n := range []int{3, 5} {
+		tree := newTestTree(t, 128, WithMaxKeysPerNode(n))
+		assertEqual(t, 2*n, tree.Root.getMaxSize(), "")
+		maxChildren, grewAt := 0, []int{}
+		for i := 1; i <= 60; i++ {
+			_, grew, err := tree.InsertWithInfo(i, i)
+			assertEqual(t, nil, err, "")
+			if grew {
+				grewAt = append(grewAt, i)
+			}
+			tree.LevelOrder(func(level int, node BPlusTreeNode) {
+				switch node := node.(type) {
+				case *leafNode:
+					assertEqual(t, true, len(node.keys) <= n, fmt.Sprintf("leaf of %d keys with n=%d", len(node.keys), n))
+				case *innerNode:
+					assertEqual(t, true, len(node.children) <= n, fmt.Sprintf("inner node of %d children with n=%d", len(node.children), n))
+					maxChildren = max(maxChildren, len(node.children))
+				}
+			})
+		}
+		assertEqual(t, n+1, grewAt[0], "a leaf root splits when it overflows n keys")
+		assertEqual(t, n, maxChildren, "inner nodes fill up to n children before splitting")
+		assertEqual(t, true, len(grewAt) >= 2, "inner nodes split too")
+	}
+
+	// the cap overrides the order, and must be within the same bounds
+	m := NewBPlusTreeMetadata("primary", WithOrder(8), WithMaxKeysPerNode(4))
+	assertEqual(t, 4, m.order, "")
+	_, err := NewBPlusTree("primary", newTestBufferPool(t, 4), NewBPlusTreeMetadata("primary", WithMaxKeysPerNode(MinOrder-1)))
+	assertEqual(t, true, errors.Is(err, ErrInvalidOrder), errMessage(err))
+}
+
+func Test_select(t *testing.T) {
+	tree := newTestTree(t, 64)
+	_, _, ok := tree.Select(0)
+	assertEqual(t, false, ok, "an empty tree has no keys")
+
+	for _, k := range rand.New(rand.NewSource(35)).Perm(99) {
+		tree.Insert(k*10, k)
+	}
+	for _, n := range []int{0, 49, 98} {
+		key, rid, ok := tree.Select(n)
+		assertEqual(t, true, ok, "")
+		assertEqual(t, n*10, key, "")
+		assertEqual(t, n, rid, "")
+	}
+	for _, n := range []int{-1, 99, 1000} {
+		_, _, ok := tree.Select(n)
+		assertEqual(t, false, ok, fmt.Sprintf("rank %d is out of range", n))
+	}
+}
+
+func Test_successorAndPredecessor(t *testing.T) {
+	tree := newTestTree(t, 16)
+	_, _, ok := tree.Successor(0)
+	assertEqual(t, false, ok, "empty tree")
+	_, _, ok = tree.Predecessor(0)
+	assertEqual(t, false, ok, "empty tree")
+
+	for _, k := range rand.New(rand.NewSource(43)).Perm(100) {
+		tree.Insert(k*10, k)
+	}
+	boundaries := 0
+	for k := 10; k < 1000; k += 10 {
+		_, prevLeaf, _ := tree.GetWithLeaf(k - 10)
+		_, leaf, _ := tree.GetWithLeaf(k)
+		if prevLeaf == leaf {
+			continue
+		}
+		// k-10 is the last key of a leaf and k the first key of its right sibling
+		boundaries++
+		for _, probe := range []int{k - 10, k - 5} {
+			key, rid, ok := tree.Successor(probe)
+			assertEqual(t, true, ok, "")
+			assertEqual(t, k, key, fmt.Sprintf("successor of %d", probe))
+			assertEqual(t, k/10, rid, "")
+		}
+		for _, probe := range []int{k, k - 5} {
+			key, rid, ok := tree.Predecessor(probe)
+			assertEqual(t, true, ok, "")
+			assertEqual(t, k-10, key, fmt.Sprintf("predecessor of %d", probe))
+			assertEqual(t, k/10-1, rid, "")
+		}
+	}
+	assertEqual(t, true, boundaries > 1, "keys span several leaves")
+
+	_, _, ok = tree.Successor(990)
+	assertEqual(t, false, ok, "no key after the largest key")
+	_, _, ok = tree.Predecessor(0)
+	assertEqual(t, false, ok, "no key before the smallest key")
+	key, _, _ := tree.Successor(-50)
+	assertEqual(t, 0, key, "")
+	key, _, _ = tree.Predecessor(5000)
+	assertEqual(t, 990, key, "")
+	assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "only the root stays pinned")
+}
+
+func Test_reloadsEvictedRoot(t *testing.T) {
+	const poolSize = 4
+	tree := newTestTree(t, poolSize)
+	for i := range 30 {
+		tree.Insert(i, i)
+	}
+	rootPageId := tree.metadata.rootPageId
+
+	// lose the pin on the root and reuse every frame, evicting the root
+	tree.bufferManager.Unpin(tree.Root.getFrame())
+	for range poolSize {
+		f, err := tree.bufferManager.GetNewPageFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree.bufferManager.Unpin(f)
+	}
+	assertEqual(t, true, tree.Root.getFrame().PageId != rootPageId, "the root frame was reused")
+
+	v, ok := tree.Get(7)
+	assertEqual(t, true, ok, "")
+	assertEqual(t, 7, v, "")
+	for i := 30; i < 60; i++ {
+		inserted, _, err := tree.InsertWithInfo(i, i)
+		assertEqual(t, true, inserted, errMessage(err))
+	}
+	keys, _ := tree.ToSlice()
+	assertEqual(t, 60, len(keys), "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "the reloaded root is pinned again")
+}
+
+func Test_rootStaysPinned(t *testing.T) {
+	tree := newTestTree(t, 4)
+	for _, k := range rand.New(rand.NewSource(45)).Perm(300) {
+		tree.Insert(k, k)
+		tree.Get(k / 2)
+		root := tree.Root.getFrame()
+		assertEqual(t, tree.metadata.rootPageId, root.PageId, "the root stays resident")
+		assertEqual(t, true, root.IsPinned(), "")
+		assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "the root holds the only pin")
+	}
+
+	tree.Close()
+	assertEqual(t, 0, tree.bufferManager.PinnedPageCount(), "")
+	_, _, err := tree.InsertWithInfo(1000, 1)
+	assertEqual(t, ErrTreeClosed, err, "")
+	_, ok := tree.Get(1)
+	assertEqual(t, false, ok, "")
+}
+
+func Test_reverseComparator(t *testing.T) {
+	reverse := func(a, b int) int { return cmp.Compare(b, a) }
+	tree := newTestTree(t, 16, WithComparator(reverse))
+	for _, k := range rand.New(rand.NewSource(46)).Perm(200) {
+		tree.Insert(k, k+1)
+	}
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+
+	keys, _ := tree.ToSlice()
+	expected := make([]int, 200)
+	for i := range expected {
+		expected[i] = 199 - i
+	}
+	assertEqual(t, true, slices.Equal(expected, keys), fmt.Sprintf("keys in descending order: %v", keys))
+	for k := range 200 {
+		v, ok := tree.Get(k)
+		assertEqual(t, true, ok, "")
+		assertEqual(t, k+1, v, "")
+	}
+
+	// successor, predecessor and rank follow the comparator too
+	key, _, _ := tree.Successor(100)
+	assertEqual(t, 99, key, "")
+	key, _, _ = tree.Predecessor(100)
+	assertEqual(t, 101, key, "")
+	rank, _ := tree.Rank(199)
+	assertEqual(t, 0, rank, "")
+
+	it, err := tree.SeekLast()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range 200 {
+		assertEqual(t, k, it.Key(), "walking backwards visits the keys in ascending order")
+		it.Prev()
+	}
+	assertEqual(t, false, it.Valid(), "")
+}
+
+func Test_concurrentReadersAndWriter(t *testing.T) {
+	tree := newTestTree(t, 64)
+	for k := range 200 {
+		tree.Insert(k, k)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for k := 200; k < 600; k++ {
+			tree.Insert(k, k)
+		}
+	}()
+	for r := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(r)))
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// keys inserted before the writer started are always visible
+				k := rng.Intn(200)
+				if v, ok := tree.Get(k); !ok || v != k {
+					t.Errorf("get %d: %d, %t", k, v, ok)
+					return
+				}
+				if i%32 != 0 {
+					continue
+				}
+				keys, _ := tree.ToSlice()
+				if !slices.IsSorted(keys) || len(keys) < 200 {
+					t.Errorf("scan returned %d keys out of order", len(keys))
+					return
+				}
+				if it, err := tree.SeekLast(); err != nil || !it.Valid() {
+					t.Errorf("seek last: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	keys, _ := tree.ToSlice()
+	assertEqual(t, 600, len(keys), "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
+
+func Test_rootSwapWhileReading(t *testing.T) {
+	tree := newTestTree(t, 32, WithOrder(MinOrder))
+	for k := range 20 {
+		tree.Insert(k, k)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	swaps := 0
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for k := 20; swaps < 4; k++ {
+			_, grew, err := tree.InsertWithInfo(k, k)
+			if err != nil {
+				t.Errorf("insert %d: %v", k, err)
+				return
+			}
+			if grew {
+				swaps++
+			}
+		}
+	}()
+	for r := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := r; ; k = (k + 1) % 20 {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if v, ok := tree.Get(k); !ok || v != k {
+					t.Errorf("get %d: %d, %t", k, v, ok)
+					return
+				}
+				// the root node and the root page id are published together
+				tree.mu.RLock()
+				root, err := tree.root()
+				rootPageId := tree.rootPage()
+				tree.mu.RUnlock()
+				if err != nil {
+					t.Errorf("root: %v", err)
+					return
+				}
+				if root.getPageId() != rootPageId {
+					t.Errorf("root node of page %d, root page id %d", root.getPageId(), rootPageId)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assertEqual(t, 4, swaps, "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
+
+func Test_readsDuringSplit(t *testing.T) {
+	SetDebugAssertions(true)
+	t.Cleanup(func() { SetDebugAssertions(false) })
+
+	// a leaf split whose sibling is not yet linked into the parent, as seen halfway through a split
+	tree := newTestTree(t, 32, WithOrder(MinOrder), WithMoveRight())
+	for k := 0; k < 200; k += 10 {
+		tree.Insert(k, k)
+	}
+	leaf, err := tree.Root.(*innerNode).findLeaf(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var split *nodeSplit
+	for k := 1; split == nil; k++ {
+		_, split, _ = leaf.insert(k, LeafValue{RecordId: k})
+	}
+	tree.bufferManager.Unpin(leaf.frame)
+	sibling, err := fetchNodeByPage(tree.bufferManager, tree.metadata, split.pageId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := slices.Clone(sibling.(*leafNode).keys)
+	tree.bufferManager.Unpin(sibling.getFrame())
+
+	// the keys that moved into the sibling are found through the sibling link
+	for _, k := range moved {
+		v, ok := tree.Get(k)
+		assertEqual(t, true, ok, fmt.Sprintf("moved key %d", k))
+		assertEqual(t, k, v, "")
+	}
+	_, ok := tree.Get(5)
+	assertEqual(t, false, ok, "a missing key is not found in the sibling")
+	tree.metadata.moveRight = false
+	_, ok = tree.Get(moved[len(moved)-1])
+	assertEqual(t, false, ok, "without moving right the unlinked sibling is not reached")
+
+	// linking a child page that was never written is caught
+	f, err := tree.bufferManager.GetNewPageFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.bufferManager.Unpin(f)
+	msg := recoverPanic(func() { assertLinkedChild("test", tree.bufferManager, tree.metadata, f.PageId) })
+	assertEqual(t, true, strings.Contains(msg, "without children"), msg)
+
+	// readers interleaved with a splitting writer always find every published key
+	tree = newTestTree(t, 64, WithOrder(MinOrder), WithMoveRight())
+	var published atomic.Int64
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for k := range 400 {
+			tree.Insert(k, k)
+			published.Store(int64(k + 1))
+		}
+	}()
+	for r := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(r)))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				n := published.Load()
+				if n == 0 {
+					continue
+				}
+				k := rng.Intn(int(n))
+				if v, ok := tree.Get(k); !ok || v != k {
+					t.Errorf("get %d: %d, %t", k, v, ok)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
+
+func Test_appendHint(t *testing.T) {
+	plain := newTestTree(t, 8)
+	tree := newTestTree(t, 8, WithAppendHint())
+	for k := range 500 {
+		plain.Insert(k, k)
+		tree.Insert(k, k)
+	}
+	// keys out of order take the regular path
+	for _, k := range []int{-10, 250, 1000, 777, -3} {
+		tree.Insert(k, k)
+	}
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	keys, rids := tree.ToSlice()
+	assertEqual(t, 504, len(keys), "the duplicate 250 is not inserted again")
+	assertEqual(t, true, slices.IsSorted(keys), "")
+	assertEqual(t, true, slices.Equal(keys, rids), "")
+	for _, k := range []int{-10, 0, 499, 777, 1000} {
+		v, ok := tree.Get(k)
+		assertEqual(t, true, ok, "")
+		assertEqual(t, k, v, "")
+	}
+	assertEqual(t, 2, tree.bufferManager.PinnedPageCount(), "the root and the rightmost leaf")
+
+	// appended leaves are left full rather than half full
+	_, _, plainLeaves, _, _ := plain.SizeInfo()
+	_, _, leaves, _, _ := tree.SizeInfo()
+	assertEqual(t, true, leaves < plainLeaves*2/3, fmt.Sprintf("%d leaves with the hint, %d without", leaves, plainLeaves))
+
+	tree.Close()
+	assertEqual(t, 0, tree.bufferManager.PinnedPageCount(), "")
+}
+
+func Benchmark_sequentialInserts(b *testing.B) {
+	for _, test := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "plain"},
+		{name: "append-hint", opts: []Option{WithAppendHint()}},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			bpm := newTestBufferPool(b, 6)
+			tree, err := NewBPlusTree("primary", bpm, NewBPlusTreeMetadata("primary", test.opts...))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for k := range b.N {
+				tree.Insert(k, k)
+			}
+			b.ReportMetric(float64(bpm.Stats().Misses)/float64(b.N), "misses/op")
+		})
+	}
+}
+
+func Test_recordIdsInRange(t *testing.T) {
+	tree := newTestTree(t, 16)
+	assertEqual(t, 0, len(tree.RecordIdsInRange(0, 100)), "empty tree")
+	for _, k := range rand.New(rand.NewSource(52)).Perm(200) {
+		tree.Insert(k*2, k*2+1) // even keys only
+	}
+
+	tests := []struct {
+		lo, hi   int
+		expected []int // the keys in range
+	}{
+		{lo: 10, hi: 20, expected: []int{10, 12, 14, 16, 18}},
+		{lo: 11, hi: 21, expected: []int{12, 14, 16, 18, 20}},
+		{lo: -50, hi: 3, expected: []int{0, 2}},
+		{lo: 396, hi: 1000, expected: []int{396, 398}},
+		{lo: 20, hi: 20, expected: nil},
+		{lo: 30, hi: 10, expected: nil},
+	}
+	for _, test := range tests {
+		rids := tree.RecordIdsInRange(test.lo, test.hi)
+		assertEqual(t, len(test.expected), len(rids), fmt.Sprintf("[%d, %d)", test.lo, test.hi))
+		for i, k := range test.expected {
+			assertEqual(t, k+1, rids[i], "")
+		}
+	}
+
+	// a range spanning many leaves, appended to a slice with enough capacity
+	dst := make([]int, 1, 256)
+	dst[0] = -1
+	dst = tree.AppendRecordIdsInRange(dst, 100, 300)
+	assertEqual(t, 101, len(dst), "")
+	assertEqual(t, 256, cap(dst), "the caller's slice is reused")
+	assertEqual(t, -1, dst[0], "")
+	for i, rid := range dst[1:] {
+		assertEqual(t, 100+2*i+1, rid, "")
+	}
+}
+
+func Test_splitCascadesToNewRoot(t *testing.T) {
+	tree := newTestTree(t, 8, WithOrder(MinOrder))
+	height := func() int {
+		levels := 0
+		tree.LevelOrder(func(level int, node BPlusTreeNode) { levels = max(levels, level+1) })
+		return levels
+	}
+
+	// grow the tree to three levels, then insert until a leaf split cascades through
+	// both inner levels (the middle level and the root) and creates a new root
+	inserted := 0
+	for ; height() < 3; inserted++ {
+		tree.Insert(inserted, inserted)
+	}
+	for grew := false; !grew; inserted++ {
+		var err error
+		_, grew, err = tree.InsertWithInfo(inserted, inserted)
+		assertEqual(t, nil, err, "")
+	}
+	assertEqual(t, 4, height(), "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	keys, _ := tree.ToSlice()
+	assertEqual(t, inserted, len(keys), "")
+	for k := range inserted {
+		_, ok := tree.Get(k)
+		assertEqual(t, true, ok, fmt.Sprintf("get %d", k))
+	}
+}
+
+func Test_rootSplitKeepsSeparator(t *testing.T) {
+	tree := newTestTree(t, 8, WithOrder(MinOrder))
+	// fill the root leaf, the next insert splits it and has no ancestor to push the split key into
+	for k := range tree.Order() {
+		tree.Insert(k*10, k)
+	}
+	assertEqual(t, true, tree.Root.isLeaf(), "")
+	inserted, grew, err := tree.InsertWithInfo(tree.Order()*10, tree.Order())
+	assertEqual(t, nil, err, "")
+	assertEqual(t, true, inserted, "")
+	assertEqual(t, true, grew, "the split root is replaced by a new root")
+
+	// the new root holds the separator key, which routes the keys on either side of the split
+	root := tree.Root.(*innerNode)
+	assertEqual(t, 2, len(root.children), "")
+	separator := root.keys[1]
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	for k := 0; k <= tree.Order(); k++ {
+		v, ok := tree.Get(k * 10)
+		assertEqual(t, true, ok, fmt.Sprintf("get %d across the split key %d", k*10, separator))
+		assertEqual(t, k, v, "")
+	}
+	_, left, _ := tree.GetWithLeaf(separator - 1)
+	_, right, _ := tree.GetWithLeaf(separator)
+	assertEqual(t, true, left != right, "the split key separates two leaves")
+}
+
+func Test_rank(t *testing.T) {
+	tree := newTestTree(t, 64)
+	rank, exists := tree.Rank(5)
+	assertEqual(t, 0, rank, "")
+	assertEqual(t, false, exists, "")
+
+	for _, k := range rand.New(rand.NewSource(36)).Perm(99) {
+		tree.Insert(k*10, k)
+	}
+	tests := []struct {
+		key    int
+		rank   int
+		exists bool
+	}{
+		{key: 0, rank: 0, exists: true},     // smallest key
+		{key: -5, rank: 0, exists: false},   // below the smallest key
+		{key: 495, rank: 50, exists: false}, // absent key between 490 and 500
+		{key: 500, rank: 50, exists: true},
+		{key: 980, rank: 98, exists: true},   // largest key
+		{key: 2000, rank: 99, exists: false}, // above the largest key
+	}
+	for _, test := range tests {
+		rank, exists := tree.Rank(test.key)
+		assertEqual(t, test.rank, rank, fmt.Sprintf("rank of %d", test.key))
+		assertEqual(t, test.exists, exists, fmt.Sprintf("key %d", test.key))
+
+		// select is the inverse of rank for existing keys
+		if test.exists {
+			key, _, _ := tree.Select(rank)
+			assertEqual(t, test.key, key, "")
+		}
+	}
+}
+
+func Test_readYourWrites(t *testing.T) {
+	// the root stays pinned, a leaf split pins the leaf and its new sibling, and a root split
+	// saves the new root to the header page, so four frames is the smallest pool an insert fits in
+	tree := newTestTree(t, 4)
+	keys := rand.New(rand.NewSource(40)).Perm(500)
+	for i, k := range keys {
+		inserted, _, err := tree.InsertWithInfo(k, k+1)
+		assertEqual(t, true, inserted, errMessage(err))
+		v, ok := tree.Get(k)
+		assertEqual(t, true, ok, fmt.Sprintf("get %d right after inserting it", k))
+		assertEqual(t, k+1, v, "")
+		assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "only the root stays pinned")
+
+		// keys inserted earlier survive the evictions caused by later splits
+		if i%50 == 0 {
+			for _, prev := range keys[:i] {
+				v, ok := tree.Get(prev)
+				assertEqual(t, true, ok, fmt.Sprintf("get %d after inserting %d", prev, k))
+				assertEqual(t, prev+1, v, "")
+			}
+		}
+	}
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
+
+// Creates an empty B+ tree backed by a new database file in a temporary directory.
+func newTestTree(t *testing.T, bufferSize int, opts ...Option) *bPlusTree {
+	t.Helper()
+	bpm := newTestBufferPool(t, bufferSize)
+	tree, err := NewBPlusTree("primary", bpm, NewBPlusTreeMetadata("primary", opts...))
+	if err != nil {
+		t.Fatalf("unable to create tree: %v", err)
+	}
+	return tree
+}
+
+// Creates a buffer pool backed by a new database file in a temporary directory.
+func newTestBufferPool(t testing.TB, bufferSize int) *memory.BufferPoolManager {
+	t.Helper()
+	dm := io.NewDiskManager(filepath.Join(t.TempDir(), "index_test"))
+	t.Cleanup(dm.(*io.DefaultDiskManager).Shutdown)
+	return memory.NewBufferPoolManager(dm, bufferSize)
+}
+
+func errMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func assertEqual[T comparable](t *testing.T, expected T, actual T, msg string) {
+	t.Helper()
+	if expected == actual {
+		return
+	}
+	if msg != "" {
+		t.Errorf("expected (%+v) is not equal to actual (%+v): (%v)", expected, actual, msg)
+	} else {
+		t.Errorf("expected (%+v) is not equal to actual (%+v)", expected, actual)
+	}
+}
+
+func Test_sizeInfo(t *testing.T) {
+	tree := newTestTree(t, 16)
+	pages, bytes, leafPages, innerPages, err := tree.SizeInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, 1, pages, "an empty tree is a single leaf")
+	assertEqual(t, int64(io.PageSize), bytes, "")
+	assertEqual(t, 1, leafPages, "")
+	assertEqual(t, 0, innerPages, "")
+
+	// Sequential inserts split the leaves as [1,2] [3,4] [5,6] [7,8,9] under a single root
+	for i := 1; i <= 9; i++ {
+		tree.Insert(i, i)
+	}
+	pages, bytes, leafPages, innerPages, err = tree.SizeInfo()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertEqual(t, 4, leafPages, "")
+	assertEqual(t, 1, innerPages, "")
+	assertEqual(t, 5, pages, "")
+	assertEqual(t, int64(5*io.PageSize), bytes, "")
+}
+
+var errInjectedRead = errors.New("injected read error")
+
+// A disk manager that fails to read a single page.
+type faultyDiskManager struct {
+	io.DiskManager
+	failReadOf int
+}
+
+func (d *faultyDiskManager) ReadPage(pageId int, buf []byte) error {
+	if pageId == d.failReadOf {
+		return errInjectedRead
+	}
+	return d.DiskManager.ReadPage(pageId, buf)
+}
+
+func Test_scanKeys(t *testing.T) {
+	tree := newTestTree(t, 8, WithInlineValues(4))
+	want := rand.New(rand.NewSource(1)).Perm(300)
+	for _, k := range want {
+		if k%2 == 0 {
+			tree.InsertInline(k, []byte{byte(k)})
+		} else {
+			tree.Insert(k, k)
+		}
+	}
+	slices.Sort(want)
+
+	decoded := decodedValues.Load()
+	var keys []int
+	err := tree.ScanKeys(func(key int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assertEqual(t, nil, err, "")
+	assertEqual(t, true, slices.Equal(want, keys), "")
+	assertEqual(t, decoded, decodedValues.Load(), "no values are decoded")
+
+	// the scan stops when fn returns false
+	keys = keys[:0]
+	tree.ScanKeys(func(key int) bool {
+		keys = append(keys, key)
+		return key < 9
+	})
+	assertEqual(t, 10, len(keys), "")
+}
+
+func Test_wouldSplit(t *testing.T) {
+	tree := newTestTree(t, 8)
+	for k := range tree.Order() - 1 {
+		tree.Insert(k*10, k)
+	}
+	assertEqual(t, false, tree.WouldSplit(5), "the leaf has room for one more key")
+	tree.Insert(5, 5)
+
+	// the root leaf is full
+	assertEqual(t, true, tree.WouldSplit(15), "")
+	assertEqual(t, false, tree.WouldSplit(10), "an existing key does not split the leaf")
+	_, ok := tree.Root.(*leafNode)
+	assertEqual(t, true, ok, "WouldSplit does not insert")
+
+	_, grew, err := tree.InsertWithInfo(15, 15)
+	assertEqual(t, nil, err, "")
+	assertEqual(t, true, grew, "the leaf split")
+	assertEqual(t, false, tree.WouldSplit(16), "")
+	assertEqual(t, false, tree.WouldSplit(-1), "")
+}
+
+func Test_inMemoryTree(t *testing.T) {
+	bpm := memory.NewInMemoryBufferPoolManager(1024)
+	tree, err := NewBPlusTree("primary", bpm, NewBPlusTreeMetadata("primary"))
+	assertEqual(t, nil, err, "")
+
+	want := rand.New(rand.NewSource(7)).Perm(1000)
+	for _, k := range want {
+		assertEqual(t, true, tree.Insert(k-500, k), "")
+	}
+	slices.Sort(want)
+	for _, k := range want {
+		v, ok := tree.Get(k - 500)
+		assertEqual(t, true, ok, "")
+		assertEqual(t, k, v, "")
+	}
+	keys, rids := tree.ToSlice()
+	assertEqual(t, true, slices.Equal(want, rids), "")
+	assertEqual(t, true, slices.IsSorted(keys), "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+
+	it, err := tree.SeekLast()
+	assertEqual(t, nil, err, "")
+	for n := 0; it.Valid() && n < 10; it.Prev() {
+		assertEqual(t, 499-n, it.Key(), "")
+		n++
+	}
+	deleted, err := tree.DeleteWhere(func(key int, rid int) bool { return key%2 == 0 })
+	assertEqual(t, nil, err, "")
+	assertEqual(t, 500, deleted, "")
+	_, ok := tree.Get(0)
+	assertEqual(t, false, ok, "")
+
+	// nothing is ever evicted or flushed, so every page the tree allocated stays in memory,
+	// next to the header page
+	assertEqual(t, true, bpm.FlushAllPages(), "")
+	pages, _, _, _, err := tree.SizeInfo()
+	assertEqual(t, nil, err, "")
+	assertEqual(t, pages+1, bpm.DirtyPageCount(), "")
+
+	// the pool is bounded: once every frame holds a page, the tree cannot grow
+	small, err := NewBPlusTree("primary", memory.NewInMemoryBufferPoolManager(4), NewBPlusTreeMetadata("primary"))
+	assertEqual(t, nil, err, "")
+	inserted := 0
+	for k := range 100 {
+		if small.Insert(k, k) {
+			inserted++
+		}
+	}
+	assertEqual(t, true, inserted < 100, fmt.Sprintf("%d keys inserted", inserted))
+	assertEqual(t, nil, small.CheckIntegrity(), "")
+}
+
+func Test_coldScan(t *testing.T) {
+	tree := newTestTree(t, 8)
+	for _, k := range rand.New(rand.NewSource(81)).Perm(500) {
+		tree.Insert(k, -k)
+	}
+	hot := tree.bufferManager.Stats()
+	var keys []int
+	err := tree.ColdScan(func(key int, rid int) bool {
+		assertEqual(t, -key, rid, "")
+		keys = append(keys, key)
+		return true
+	})
+	assertEqual(t, nil, err, "")
+	assertEqual(t, hot, tree.bufferManager.Stats(), "the scan does not go through the buffer pool")
+	want, _ := tree.ToSlice()
+	assertEqual(t, true, slices.Equal(want, keys), "")
+	assertEqual(t, 500, len(keys), "")
+}
+
+func Benchmark_coldScan(b *testing.B) {
+	for _, test := range []struct {
+		name string
+		scan func(tree *bPlusTree)
+	}{
+		{name: "pool-scan", scan: func(tree *bPlusTree) { tree.ToSlice() }},
+		{name: "cold-scan", scan: func(tree *bPlusTree) { tree.ColdScan(func(key int, rid int) bool { return true }) }},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			dm := io.NewDiskManager(filepath.Join(b.TempDir(), "index_test"))
+			b.Cleanup(dm.(*io.DefaultDiskManager).Shutdown)
+			loader, err := NewBPlusTree("primary", memory.NewBufferPoolManager(dm, 64), NewBPlusTreeMetadata("primary"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, k := range rand.New(rand.NewSource(3)).Perm(3000) {
+				loader.Insert(k, k)
+			}
+			loader.bufferManager.FlushAllPages()
+
+			// reopen the tree on a cold pool, and warm up the pages of a few hot keys
+			bpm := memory.NewBufferPoolManager(dm, 64)
+			m := NewBPlusTreeMetadata("primary")
+			m.rootPageId = loader.metadata.rootPageId
+			tree, err := NewBPlusTree("primary", bpm, m)
+			if err != nil {
+				b.Fatal(err)
+			}
+			hotKeys := []int{100, 900, 1700, 2500}
+			for range 2 {
+				for _, k := range hotKeys {
+					tree.Get(k)
+				}
+			}
+			stats := bpm.Stats()
+			b.ResetTimer()
+			for range b.N {
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					test.scan(tree)
+				}()
+				// point queries on the hot keys while the scan runs
+				for scanning := true; scanning; {
+					select {
+					case <-done:
+						scanning = false
+					default:
+					}
+					for _, k := range hotKeys {
+						tree.Get(k)
+					}
+				}
+			}
+			b.StopTimer()
+			// the misses of a pool scan include the pages it reads itself
+			after := bpm.Stats()
+			hits, misses := after.Hits-stats.Hits, after.Misses-stats.Misses
+			b.ReportMetric(100*float64(hits)/float64(max(hits+misses, 1)), "pool-hit-%")
+			b.ReportMetric(float64(misses)/float64(b.N), "misses/op")
+		})
+	}
+}
+
+func Test_indexesShareBufferPool(t *testing.T) {
+	dm := io.NewDiskManager(filepath.Join(t.TempDir(), "index_test"))
+	defer dm.(*io.DefaultDiskManager).Shutdown()
+	evictions := 0
+	bpm := memory.NewBufferPoolManager(dm, 8, memory.WithOnEvict(func(pageId, frameId int, wasDirty bool) {
+		evictions++
+	}))
+	primary, err := NewBPlusTree("primary", bpm, NewBPlusTreeMetadata("primary"))
+	assertEqual(t, nil, err, "")
+	secondary, err := NewBPlusTree("secondary", bpm, NewBPlusTreeMetadata("secondary", WithOrder(5)))
+	assertEqual(t, nil, err, "")
+
+	for _, k := range rand.New(rand.NewSource(70)).Perm(300) {
+		assertEqual(t, true, primary.Insert(k, k), "")
+		assertEqual(t, true, secondary.Insert(-k, k+1), "")
+	}
+	assertEqual(t, true, evictions > 0, "the indexes evict each other's pages")
+	assertEqual(t, 2, bpm.PinnedPageCount(), "the root of each index")
+
+	check := func(primary, secondary *bPlusTree) {
+		t.Helper()
+		assertEqual(t, nil, primary.CheckIntegrity(), "")
+		assertEqual(t, nil, secondary.CheckIntegrity(), "")
+		for k := range 300 {
+			v, ok := primary.Get(k)
+			assertEqual(t, true, ok && v == k, fmt.Sprintf("primary key %d", k))
+			v, ok = secondary.Get(-k)
+			assertEqual(t, true, ok && v == k+1, fmt.Sprintf("secondary key %d", -k))
+		}
+		_, ok := primary.Get(-1)
+		assertEqual(t, false, ok, "")
+		orphans, _ := primary.FindOrphanPages()
+		secondaryPages, _, _, _, _ := secondary.SizeInfo()
+		assertEqual(t, secondaryPages, len(orphans), "the pages of the other index are not reachable")
+	}
+	check(primary, secondary)
+
+	// reopen both indexes on a new pool shared over the same file
+	assertEqual(t, true, bpm.FlushAllPages(), "")
+	bpm = memory.NewBufferPoolManager(dm, 8)
+	primary, err = OpenBPlusTree("primary", bpm, primary.RootPageId())
+	assertEqual(t, nil, err, "")
+	secondary, err = OpenBPlusTree("secondary", bpm, secondary.RootPageId(), WithOrder(5))
+	assertEqual(t, nil, err, "")
+	check(primary, secondary)
+}
+
+func Test_insertRelinksCrashedSplit(t *testing.T) {
+	tree := newTestTree(t, 32, WithOrder(MinOrder))
+	keys := make([]int, 0)
+	for k := 0; k < 200; k += 10 {
+		tree.Insert(k, k)
+		keys = append(keys, k)
+	}
+	// split the leaf of key 0 without linking the new leaf into the parent, as left behind
+	// by a crash between writing the new leaf and updating the parent
+	leaf, err := tree.Root.(*innerNode).findLeaf(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var split *nodeSplit
+	for k := 1; split == nil; k++ {
+		_, split, _ = leaf.insert(k, LeafValue{RecordId: k})
+		keys = append(keys, k)
+	}
+	last := leaf.keys[len(leaf.keys)-1]
+	tree.bufferManager.Unpin(leaf.frame)
+	assertEqual(t, true, tree.CheckIntegrity() != nil, "the new leaf is not reachable from the parent")
+	_, ok := tree.Get(split.key)
+	assertEqual(t, false, ok, "")
+
+	// an insert into the key range of the new leaf links it into the parent
+	k := split.key + 1
+	assertEqual(t, true, k > last, "")
+	assertEqual(t, true, tree.Insert(k, k), "")
+	keys = append(keys, k)
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	for _, k := range keys {
+		v, ok := tree.Get(k)
+		assertEqual(t, true, ok, fmt.Sprintf("get %d", k))
+		assertEqual(t, k, v, "")
+	}
+	got, _ := tree.ToSlice()
+	slices.Sort(keys)
+	assertEqual(t, true, slices.Equal(keys, got), fmt.Sprint(got))
+}
+
+func Test_insertsPrintNothing(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	t.Cleanup(func() { os.Stdout = stdout })
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	// a small pool also exercises evictions, splits of leaves and inner nodes and root growth
+	tree := newTestTree(t, 16, WithOrder(MinOrder))
+	for _, k := range rand.New(rand.NewSource(53)).Perm(500) {
+		tree.Insert(k, k)
+	}
+	tree.Get(42)
+	os.Stdout = stdout
+	w.Close()
+
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "", out.String(), "inserts write nothing to stdout")
+	assertEqual(t, "", logged.String(), "inserts log nothing")
+	keys, _ := tree.ToSlice()
+	assertEqual(t, 500, len(keys), "")
+}
+
+func Test_dumpAndRestoreFile(t *testing.T) {
+	tree := newTestTree(t, 16)
+	for _, k := range rand.New(rand.NewSource(71)).Perm(300) {
+		tree.Insert(k, k*3)
+	}
+	// compacting frees the pages of the old tree, which the dump skips
+	tree.DeleteWhere(func(key int, rid int) bool { return key%3 == 0 })
+	_, err := tree.Compact()
+	assertEqual(t, nil, err, "")
+	allocated := tree.bufferManager.AllocatedPageIds()
+
+	var dump bytes.Buffer
+	assertEqual(t, nil, tree.bufferManager.DumpFile(&dump), "")
+	const headerSize, frameSize = 12, 8 + io.PageSize + 4
+	assertEqual(t, headerSize+len(allocated)*frameSize, dump.Len(), "one frame per allocated page")
+
+	dm := io.NewDiskManager(filepath.Join(t.TempDir(), "restored"))
+	defer dm.(*io.DefaultDiskManager).Shutdown()
+	assertEqual(t, nil, memory.RestoreFile(&dump, dm), "")
+	restored, err := OpenBPlusTree("primary", memory.NewBufferPoolManager(dm, 16), tree.RootPageId(), WithVerifyOnOpen())
+	assertEqual(t, nil, err, "")
+	assertEqual(t, nil, restored.CheckIntegrity(), "")
+	for k := range 300 {
+		want, wantOk := tree.Get(k)
+		got, ok := restored.Get(k)
+		assertEqual(t, wantOk, ok, fmt.Sprintf("get %d", k))
+		assertEqual(t, want, got, "")
+	}
+}
@@ -0,0 +1,211 @@
+package index
+
+import (
+	"fmt"
+	"wtfDB/memory"
+)
+
+/*
+Compact rebuilds the tree bottom-up into new pages, and returns the number of pages the tree
+shrank by. The entries are packed into full leaves in key order, the inner levels are built
+above them, the new root is swapped in and the pages of the old tree are freed. Removes only
+merge nodes that drop below half full, so this reclaims the space of the nodes that deletes
+left between half full and full. Record ids, inline values and insert sequence numbers are kept.
+
+The tree latch is held for the whole rebuild. If the rebuild fails, eg. because the buffer
+pool is full, the old tree stays in place, and the pages of the partial rebuild are left
+as orphans, see ReclaimOrphans. A pinned page of the old tree is not freed.
+*/
+func (t *bPlusTree) Compact() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.compact()
+}
+
+func (t *bPlusTree) compact() (int, error) {
+	oldPages := make([]int, 0)
+	if err := t.levelOrder(func(level int, node BPlusTreeNode) {
+		oldPages = append(oldPages, node.getPageId())
+	}); err != nil {
+		return 0, fmt.Errorf("unable to walk the tree: %w", err)
+	}
+	// the pins of PinInternalNodes and the append hint are on pages of the old tree
+	t.unpinInternalNodes()
+	t.releaseAppendLeaf()
+
+	b := &treeBuilder{tree: t}
+	err := t.forEachLeafFrom(firstChild, func(pageId int, leaf *leafNode) error {
+		for i := range leaf.keys {
+			if err := b.add(leaf, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.abort()
+		return 0, err
+	}
+	root, err := b.finish()
+	if err != nil {
+		return 0, err
+	}
+	t.metadata.height = b.height
+	if err := t.updateRoot(root); err != nil {
+		// the pages of the old tree are kept, since the header page still points to its root
+		return 0, err
+	}
+
+	freed := 0
+	for _, pageId := range oldPages {
+		deleted, err := t.bufferManager.DeletePage(pageId)
+		if err != nil {
+			return freed - b.pages, fmt.Errorf("unable to free page %d of the old tree: %w", pageId, err)
+		}
+		if deleted {
+			freed++
+		}
+	}
+	return freed - b.pages, nil
+}
+
+// A treeBuilder builds a tree bottom-up from entries added in key order: leaves are filled
+// one at a time, and the levels above them are built once every leaf is written.
+type treeBuilder struct {
+	tree   *bPlusTree
+	leaf   *leafNode   // the leaf being filled, pinned, nil before the first entry
+	level  []nodeSplit // the first key and page id of every written node of the level being built
+	pages  int         // the number of pages written
+	height int         // the number of inner levels built above the leaves
+}
+
+// Appends entry i of src to the leaf being filled, starting a new leaf when it is full.
+func (b *treeBuilder) add(src *leafNode, i int) error {
+	if b.leaf == nil || b.leaf.getMaxSize()-b.leaf.getSize() < 1 {
+		if err := b.startLeaf(); err != nil {
+			return err
+		}
+	}
+	m := b.tree.metadata
+	l := b.leaf
+	l.keys = append(l.keys, src.keys[i])
+	l.recordIds = append(l.recordIds, src.recordIds[i])
+	if m.insertSequence {
+		l.sequences = append(l.sequences, src.sequences[i])
+	}
+	if m.inlineSize > 0 {
+		l.inline = append(l.inline, src.inline[i])
+	}
+	return nil
+}
+
+// Starts a new leaf to the right of the leaf being filled, which is written.
+func (b *treeBuilder) startLeaf() error {
+	next := newLeafNode(b.tree.bufferManager, b.tree.metadata)
+	if next == nil {
+		return fmt.Errorf("unable to create a leaf: %w", memory.ErrBufferPoolFull)
+	}
+	if b.leaf != nil {
+		b.leaf.rightSibling = next.getPageId()
+		if err := b.writeLeaf(); err != nil {
+			b.tree.bufferManager.Unpin(next.frame)
+			return err
+		}
+	}
+	b.leaf = next
+	return nil
+}
+
+// Writes the leaf being filled to its page and unpins it.
+func (b *treeBuilder) writeLeaf() error {
+	l := b.leaf
+	b.leaf = nil
+	defer b.tree.bufferManager.Unpin(l.frame)
+	if err := l.toBytes(); err != nil {
+		return err
+	}
+	b.tree.bufferManager.MarkDirty(l.frame)
+	b.level = append(b.level, nodeSplit{key: l.keys[0], pageId: l.getPageId()})
+	b.pages++
+	return nil
+}
+
+// Releases the leaf being filled when the rebuild is given up.
+func (b *treeBuilder) abort() {
+	if b.leaf != nil {
+		b.tree.bufferManager.Unpin(b.leaf.frame)
+		b.leaf = nil
+	}
+}
+
+// Writes the last leaf and builds the inner levels above the leaves, and returns the root, pinned.
+func (b *treeBuilder) finish() (BPlusTreeNode, error) {
+	if b.leaf == nil {
+		// an empty tree is a single empty leaf
+		if err := b.startLeaf(); err != nil {
+			return nil, err
+		}
+		b.pages++
+		return b.leaf, nil
+	}
+	if err := b.writeLeaf(); err != nil {
+		return nil, err
+	}
+	for len(b.level) > 1 {
+		if err := b.buildInnerLevel(); err != nil {
+			return nil, err
+		}
+		b.height++
+	}
+	return fetchNodeByPage(b.tree.bufferManager, b.tree.metadata, b.level[0].pageId)
+}
+
+/*
+Builds the level of inner nodes above the nodes of the current level. The children are spread
+evenly over the fewest inner nodes that hold them, so that no inner node is left with a single
+child. Every inner node is written and unpinned before the next one is created.
+*/
+func (b *treeBuilder) buildInnerLevel() error {
+	m := b.tree.metadata
+	children := b.level
+	n := (len(children) + m.order - 1) / m.order
+	b.level = make([]nodeSplit, 0, n)
+	var prev *innerNode
+	for i := range n {
+		group := children[i*len(children)/n : (i+1)*len(children)/n]
+		node := newInnerNode(b.tree.bufferManager, m)
+		if node == nil {
+			if prev != nil {
+				b.tree.bufferManager.Unpin(prev.frame)
+			}
+			return fmt.Errorf("unable to create an inner node: %w", memory.ErrBufferPoolFull)
+		}
+		for j, child := range group {
+			if j > 0 {
+				node.keys = append(node.keys, child.key)
+			}
+			node.children = append(node.children, uint64(child.pageId))
+		}
+		if prev != nil {
+			prev.rightSibling = node.getPageId()
+			if err := b.writeInner(prev); err != nil {
+				b.tree.bufferManager.Unpin(node.frame)
+				return err
+			}
+		}
+		b.level = append(b.level, nodeSplit{key: group[0].key, pageId: node.getPageId()})
+		prev = node
+	}
+	return b.writeInner(prev)
+}
+
+// Writes an inner node to its page and unpins it.
+func (b *treeBuilder) writeInner(n *innerNode) error {
+	defer b.tree.bufferManager.Unpin(n.frame)
+	if err := n.toBytes(); err != nil {
+		return err
+	}
+	b.tree.bufferManager.MarkDirty(n.frame)
+	b.pages++
+	return nil
+}
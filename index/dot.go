@@ -0,0 +1,58 @@
+package index
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"wtfDB/memory"
+)
+
+/*
+ToDOT writes the structure of the tree to w as a Graphviz DOT graph, eg. to be rendered
+with `dot -Tsvg`. Every page is drawn as a node labeled with its page id and keys; the
+invalid first key of an inner node is drawn as "*". Child pointers are drawn as edges
+labeled with the page id they point to, and the leaf sibling chain as dashed edges.
+
+Nodes are visited level by level, see LevelOrder. Returns the first error of a page that
+cannot be loaded or of a write to w.
+*/
+func (t *bPlusTree) ToDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph bplustree {\n")
+	b.WriteString("\tnode [shape=record];\n")
+	err := t.LevelOrder(func(level int, node BPlusTreeNode) {
+		pageId := node.getPageId()
+		switch n := node.(type) {
+		case *leafNode:
+			fmt.Fprintf(&b, "\tpage%d [label=\"leaf %d|%s\"];\n", pageId, pageId, dotKeys(n.keys, false))
+			if n.rightSibling != memory.InvalidPageId {
+				fmt.Fprintf(&b, "\tpage%d -> page%d [style=dashed];\n", pageId, n.rightSibling)
+			}
+		case *innerNode:
+			fmt.Fprintf(&b, "\tpage%d [label=\"inner %d|%s\"];\n", pageId, pageId, dotKeys(n.keys, true))
+			for _, child := range n.children {
+				fmt.Fprintf(&b, "\tpage%d -> page%d [label=\"%d\"];\n", pageId, child, child)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	b.WriteString("}\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// Formats keys as the fields of a record label. The first key of an inner node is the invalid key.
+func dotKeys(keys []int, inner bool) string {
+	fields := make([]string, len(keys))
+	for i, k := range keys {
+		if inner && i == 0 {
+			fields[i] = "*"
+			continue
+		}
+		fields[i] = strconv.Itoa(k)
+	}
+	return strings.Join(fields, "|")
+}
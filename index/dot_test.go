@@ -0,0 +1,30 @@
+package index
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_toDOT(t *testing.T) {
+	tree := newTestTree(t, 16)
+	for k := range 40 {
+		tree.Insert(k, k)
+	}
+	pages, _, leafPages, _, err := tree.SizeInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := tree.ToDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	dot := b.String()
+	assertEqual(t, true, strings.HasPrefix(dot, "digraph bplustree {"), dot)
+	assertEqual(t, pages, strings.Count(dot, "[label=\"leaf ")+strings.Count(dot, "[label=\"inner "), "a node per page")
+	assertEqual(t, leafPages, strings.Count(dot, "[label=\"leaf "), "")
+	// every page but the root has a parent, and the leaves are chained left to right
+	assertEqual(t, pages-1, strings.Count(dot, "-> page")-strings.Count(dot, "[style=dashed]"), "child edges")
+	assertEqual(t, leafPages-1, strings.Count(dot, "[style=dashed]"), "sibling edges")
+	assertEqual(t, true, strings.Contains(dot, "|0|1"), "keys of the leftmost leaf")
+}
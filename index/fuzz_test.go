@@ -0,0 +1,73 @@
+package index
+
+import "testing"
+
+/*
+FuzzTreeOps interprets its input as a sequence of 3 byte operations (opcode, key, value)
+that are applied both to a B+ tree and to a reference map. The opcode modulo 3 selects the
+operation: 0 inserts the key, 1 looks it up and 2 removes it, which borrows from or merges
+underfull nodes. After every operation the results must agree with the reference map and
+the tree must pass CheckIntegrity.
+
+Keys are taken from a single (signed) byte so that duplicate inserts and lookups of
+existing keys are common.
+*/
+func FuzzTreeOps(f *testing.F) {
+	f.Add([]byte{0, 1, 1, 0, 2, 2, 0, 3, 3, 0, 4, 4, 0, 5, 5, 1, 3, 0, 1, 9, 0})
+	f.Add([]byte{0, 200, 1, 0, 100, 2, 0, 150, 3, 0, 100, 4, 1, 100, 0, 0, 255, 5, 0, 0, 6})
+	seq := make([]byte, 0, 3*64)
+	for i := range 64 {
+		seq = append(seq, 0, byte(i*7), byte(i))
+	}
+	f.Add(seq)
+	// fill the tree up to a few levels, then drain it from both ends towards the middle
+	seq = seq[:0]
+	for i := range 64 {
+		seq = append(seq, 0, byte(i), byte(i))
+	}
+	for i := range 32 {
+		seq = append(seq, 2, byte(i), 0, 2, byte(63-i), 0, 1, byte(i+1), 0)
+	}
+	f.Add(seq)
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		if len(ops) > 3*512 {
+			ops = ops[:3*512]
+		}
+		tree := newTestTree(t, 512)
+		reference := make(map[int]int)
+		for i := 0; i+2 < len(ops); i += 3 {
+			k, v := int(int8(ops[i+1])), int(int8(ops[i+2]))
+			switch ops[i] % 3 {
+			case 0:
+				tree.Insert(k, v)
+				if _, ok := reference[k]; !ok {
+					reference[k] = v
+				}
+			case 1:
+				got, ok := tree.Get(k)
+				want, exists := reference[k]
+				if ok != exists || got != want {
+					t.Fatalf("op %d: Get(%d) = (%d, %v), expected (%d, %v)", i/3, k, got, ok, want, exists)
+				}
+			case 2:
+				_, exists := reference[k]
+				if removed := tree.Remove(k); removed != exists {
+					t.Fatalf("op %d: Remove(%d) = %v, expected %v", i/3, k, removed, exists)
+				}
+				delete(reference, k)
+			}
+			if err := tree.CheckIntegrity(); err != nil {
+				t.Fatalf("op %d: %v", i/3, err)
+			}
+		}
+		if keys, _ := tree.ToSlice(); len(keys) != len(reference) {
+			t.Fatalf("tree holds %d keys, expected %d", len(keys), len(reference))
+		}
+		for k, want := range reference {
+			if got, ok := tree.Get(k); !ok || got != want {
+				t.Fatalf("Get(%d) = (%d, %v), expected (%d, true)", k, got, ok, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package index
+
+import (
+	"encoding/binary"
+	"fmt"
+	"wtfDB/memory"
+)
+
+/*
+The header page is page 0 of every database file that holds trees. It is a catalog of the
+trees in the file, so that they can be opened again by name after a restart:
+
+ 1. page type, literal value 2 indicates the header page (4 bytes)
+ 2. the number of entries (4 bytes)
+ 3. one entry per tree: the root page id (8 bytes), the order (4 bytes), the length of the
+    index name (1 byte) and the index name
+
+Page 0 is reserved for the header page when the first tree of the file is created, and
+NewBPlusTree opens the tree of the same name from the header page if it exists, and creates
+it otherwise. The root page id of a tree is saved whenever its root changes. The header page
+is write-through, so the saved root page id is on disk at once (see
+memory.BufferPoolManager.SetWriteThrough), but the pages of the tree are not: they must still
+be flushed with FlushAllPages before the file can be reopened. A root change whose save fails
+reports the error, and the header page keeps the previous root page id.
+
+The order of a tree is saved with its root page id; its other options are not, so the tree
+must be opened with the same options it was created with. A file whose page 0 holds a node,
+eg. one written before the header page was introduced, cannot be opened: NewBPlusTree returns
+ErrInvalidHeaderPage rather than run a tree whose root could not be saved.
+*/
+const (
+	headerPageId         = 0
+	headerPageType       = 2
+	headerPageHeaderSize = 8
+	headerEntrySize      = 8 + 4 + 1 // root page id, order, name length; followed by the name
+)
+
+var (
+	ErrInvalidHeaderPage = fmt.Errorf("page 0 is not a header page")
+	ErrHeaderPageFull    = fmt.Errorf("header page is full")
+	ErrIndexNotFound     = fmt.Errorf("index not found in the header page")
+)
+
+// An entry of the header page.
+type headerEntry struct {
+	rootPageId int
+	order      int
+	indexName  string
+}
+
+/*
+Saves the root page id, order and index name of the tree into the header page of b, replacing
+the entry of the same index name. The header page is created if the file has no pages yet.
+Returns ErrInvalidHeaderPage if page 0 holds something else, and ErrHeaderPageFull if the
+entries do not fit in the page.
+*/
+func (m *BPlusTreeMetadata) Save(b *memory.BufferPoolManager) error {
+	if len(m.indexName) > 255 {
+		return fmt.Errorf("%w: index name %q is longer than 255 bytes", ErrHeaderPageFull, m.indexName)
+	}
+	f, err := reserveHeaderPage(b)
+	if err != nil {
+		return err
+	}
+	defer b.Unpin(f)
+	entries, err := readHeaderPage(f.Data)
+	if err != nil {
+		return err
+	}
+	entry := headerEntry{rootPageId: m.rootPageId, order: m.order, indexName: m.indexName}
+	replaced := false
+	for i := range entries {
+		if entries[i].indexName == m.indexName {
+			entries[i], replaced = entry, true
+		}
+	}
+	if !replaced {
+		entries = append(entries, entry)
+	}
+	if err := writeHeaderPage(f.Data, entries); err != nil {
+		return err
+	}
+	b.SetWriteThrough(headerPageId, true)
+	b.MarkDirty(f)
+	return nil
+}
+
+/*
+Loads the root page id and order of the tree of the same index name from the header page
+of b. Returns ErrIndexNotFound if the file has no header page yet, or no entry for the index,
+and ErrInvalidHeaderPage if page 0 holds something else.
+*/
+func (m *BPlusTreeMetadata) Load(b *memory.BufferPoolManager) error {
+	if !b.IsAllocated(headerPageId) {
+		return fmt.Errorf("%w: %q, the file has no header page", ErrIndexNotFound, m.indexName)
+	}
+	entries, err := loadHeaderPage(b)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.indexName == m.indexName {
+			m.rootPageId, m.order = entry.rootPageId, entry.order
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrIndexNotFound, m.indexName)
+}
+
+// Returns the entries of the header page of b, which are none if the file has no pages yet.
+// Returns ErrInvalidHeaderPage if page 0 holds something else.
+func loadHeaderPage(b *memory.BufferPoolManager) ([]headerEntry, error) {
+	if !b.IsAllocated(headerPageId) {
+		return nil, nil
+	}
+	f, err := b.GetPage(headerPageId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header page: %w", err)
+	}
+	defer b.Unpin(f)
+	return readHeaderPage(f.Data)
+}
+
+// Returns the pinned header page of b, and creates it as page 0 if the file has no pages yet.
+func reserveHeaderPage(b *memory.BufferPoolManager) (*memory.Frame, error) {
+	if b.IsAllocated(headerPageId) {
+		f, err := b.GetPage(headerPageId)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the header page: %w", err)
+		}
+		return f, nil
+	}
+	f, err := b.GetNewPageFrame()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the header page: %w", err)
+	}
+	if f.PageId != headerPageId {
+		b.Unpin(f)
+		return nil, fmt.Errorf("%w: page 0 was deleted, the header page must be the first page of the file", ErrInvalidHeaderPage)
+	}
+	// an empty header page, written through like every later update of the page
+	if err := writeHeaderPage(f.Data, nil); err != nil {
+		b.Unpin(f)
+		return nil, err
+	}
+	b.SetWriteThrough(headerPageId, true)
+	b.MarkDirty(f)
+	return f, nil
+}
+
+// Parses the entries of a header page.
+func readHeaderPage(data []byte) ([]headerEntry, error) {
+	if binary.BigEndian.Uint32(data[0:]) != headerPageType {
+		return nil, ErrInvalidHeaderPage
+	}
+	count := int(binary.BigEndian.Uint32(data[4:]))
+	entries := make([]headerEntry, 0, count)
+	offset := headerPageHeaderSize
+	for range count {
+		if offset+headerEntrySize > len(data) {
+			return nil, fmt.Errorf("%w: entry %d does not fit in the page", ErrInvalidHeaderPage, len(entries))
+		}
+		entry := headerEntry{
+			rootPageId: getPageId(data[offset:]),
+			order:      int(binary.BigEndian.Uint32(data[offset+8:])),
+		}
+		nameLen := int(data[offset+12])
+		offset += headerEntrySize
+		if offset+nameLen > len(data) {
+			return nil, fmt.Errorf("%w: the index name of entry %d does not fit in the page", ErrInvalidHeaderPage, len(entries))
+		}
+		entry.indexName = string(data[offset : offset+nameLen])
+		offset += nameLen
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Serializes the entries into a header page.
+func writeHeaderPage(data []byte, entries []headerEntry) error {
+	size := headerPageHeaderSize
+	for _, entry := range entries {
+		size += headerEntrySize + len(entry.indexName)
+	}
+	if size > len(data) {
+		return fmt.Errorf("%w: %d entries take %d bytes", ErrHeaderPageFull, len(entries), size)
+	}
+	clear(data)
+	binary.BigEndian.PutUint32(data[0:], headerPageType)
+	binary.BigEndian.PutUint32(data[4:], uint32(len(entries)))
+	offset := headerPageHeaderSize
+	for _, entry := range entries {
+		putPageId(data[offset:], entry.rootPageId)
+		binary.BigEndian.PutUint32(data[offset+8:], uint32(entry.order))
+		data[offset+12] = byte(len(entry.indexName))
+		offset += headerEntrySize
+		offset += copy(data[offset:], entry.indexName)
+	}
+	return nil
+}
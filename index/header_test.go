@@ -0,0 +1,128 @@
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+	"wtfDB/io"
+	"wtfDB/memory"
+)
+
+func Test_headerPageReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index_test")
+	dm := io.NewDiskManager(path)
+	bpm := memory.NewBufferPoolManager(dm, 16)
+	primary, err := NewBPlusTree("primary", bpm, NewBPlusTreeMetadata("primary", WithOrder(5)))
+	assertEqual(t, nil, err, errMessage(err))
+	secondary, err := NewBPlusTree("secondary", bpm, NewBPlusTreeMetadata("secondary"))
+	assertEqual(t, nil, err, errMessage(err))
+	for k := range 200 {
+		assertEqual(t, true, primary.Insert(k, k*10), "")
+		assertEqual(t, true, secondary.Insert(-k, k), "")
+	}
+	assertEqual(t, false, primary.RootPageId() == headerPageId, "page 0 is the header page")
+	orphans, err := primary.FindOrphanPages()
+	assertEqual(t, nil, err, errMessage(err))
+	assertEqual(t, false, len(orphans) > 0 && orphans[0] == headerPageId, "the header page is no orphan")
+	assertEqual(t, true, bpm.FlushAllPages(), "")
+	dm.(*io.DefaultDiskManager).Shutdown()
+
+	// a new pool over the same file finds both roots in the header page
+	dm = io.NewDiskManager(path)
+	t.Cleanup(dm.(*io.DefaultDiskManager).Shutdown)
+	bpm = memory.NewBufferPoolManager(dm, 16)
+	m := NewBPlusTreeMetadata("primary")
+	reopened, err := NewBPlusTree("primary", bpm, m)
+	assertEqual(t, nil, err, errMessage(err))
+	assertEqual(t, primary.RootPageId(), reopened.RootPageId(), "")
+	assertEqual(t, 5, m.order, "the order is loaded from the header page")
+	for k := range 200 {
+		v, ok := reopened.Get(k)
+		assertEqual(t, true, ok, "")
+		assertEqual(t, k*10, v, "")
+	}
+	reopenedSecondary, err := NewBPlusTree("secondary", bpm, NewBPlusTreeMetadata("secondary"))
+	assertEqual(t, nil, err, errMessage(err))
+	assertEqual(t, secondary.RootPageId(), reopenedSecondary.RootPageId(), "")
+	v, ok := reopenedSecondary.Get(-199)
+	assertEqual(t, true, ok, "")
+	assertEqual(t, 199, v, "")
+}
+
+func Test_headerPageWriteThrough(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index_test")
+	dm := io.NewDiskManager(path)
+	t.Cleanup(dm.(*io.DefaultDiskManager).Shutdown)
+	bpm := memory.NewBufferPoolManager(dm, 16)
+	tree, err := NewBPlusTree("primary", bpm, NewBPlusTreeMetadata("primary"))
+	assertEqual(t, nil, err, errMessage(err))
+	for k := range 50 {
+		assertEqual(t, true, tree.Insert(k, k), "")
+	}
+
+	// the header page is written through as the root changes, so a pool that never flushed
+	// its other pages has the latest root page id on disk
+	m := NewBPlusTreeMetadata("primary")
+	err = m.Load(memory.NewBufferPoolManager(dm, 4))
+	assertEqual(t, nil, err, errMessage(err))
+	assertEqual(t, tree.RootPageId(), m.rootPageId, "")
+
+	// but the tree cannot be opened from it, since its root was never flushed
+	_, err = NewBPlusTree("primary", memory.NewBufferPoolManager(dm, 4), NewBPlusTreeMetadata("primary"))
+	assertEqual(t, true, err != nil, "")
+
+	m = NewBPlusTreeMetadata("missing")
+	err = m.Load(bpm)
+	assertEqual(t, true, errors.Is(err, ErrIndexNotFound), errMessage(err))
+	assertEqual(t, memory.InvalidPageId, m.rootPageId, "")
+}
+
+func Test_headerPageOfOldFile(t *testing.T) {
+	// a file written before the header page, whose root leaf is page 0
+	bpm := newTestBufferPool(t, 8)
+	leaf := newLeafNode(bpm, NewBPlusTreeMetadata("primary"))
+	assertEqual(t, headerPageId, leaf.getPageId(), "")
+	assertEqual(t, nil, leaf.toBytes(), "")
+	bpm.MarkDirty(leaf.frame)
+	bpm.Unpin(leaf.frame)
+
+	// neither a new tree nor the tree on page 0 can be opened, since no root could be saved
+	_, err := NewBPlusTree("secondary", bpm, NewBPlusTreeMetadata("secondary"))
+	assertEqual(t, true, errors.Is(err, ErrInvalidHeaderPage), errMessage(err))
+	_, err = OpenBPlusTree("primary", bpm, headerPageId)
+	assertEqual(t, true, errors.Is(err, ErrInvalidHeaderPage), errMessage(err))
+	err = NewBPlusTreeMetadata("primary").Save(bpm)
+	assertEqual(t, true, errors.Is(err, ErrInvalidHeaderPage), errMessage(err))
+	assertEqual(t, 0, bpm.PinnedPageCount(), "")
+}
+
+func Test_headerPageSaveFails(t *testing.T) {
+	tree := newTestTree(t, 16)
+	bpm := tree.bufferManager
+	setHeaderPageType := func(pageType uint32) {
+		f, err := bpm.GetPage(headerPageId)
+		assertEqual(t, nil, err, errMessage(err))
+		binary.BigEndian.PutUint32(f.Data, pageType)
+		bpm.Unpin(f)
+	}
+
+	// a root split whose new root cannot be saved reports the error
+	setHeaderPageType(0)
+	var err error
+	for k := 0; err == nil; k++ {
+		_, _, err = tree.InsertWithInfo(k, k)
+	}
+	assertEqual(t, true, errors.Is(err, ErrInvalidHeaderPage), errMessage(err))
+	assertEqual(t, 1, tree.metadata.height, "")
+
+	// the old root of a shrinking tree is not freed when the new root cannot be saved
+	oldRoot := tree.RootPageId()
+	tree.mu.Lock()
+	for k, err := 0, error(nil); !errors.Is(err, ErrInvalidHeaderPage); k++ {
+		_, err = tree.remove(k)
+	}
+	tree.mu.Unlock()
+	assertEqual(t, true, tree.Root.isLeaf(), "")
+	assertEqual(t, true, bpm.IsAllocated(oldRoot), "")
+}
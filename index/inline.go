@@ -0,0 +1,148 @@
+package index
+
+import (
+	"fmt"
+	"log"
+	"slices"
+)
+
+// MaxInlineValueSize is the largest inline value size, since the length of an inline value is stored in a single byte.
+const MaxInlineValueSize = 255
+
+// Tags of a leaf value in the tagged value format, see WithInlineValues.
+const (
+	recordIdTag    = 0
+	inlineValueTag = 1
+)
+
+var (
+	ErrInvalidInlineSize    = fmt.Errorf("invalid inline value size")
+	ErrInlineValueTooLarge  = fmt.Errorf("value is too large to store inline")
+	ErrInlineValuesDisabled = fmt.Errorf("inline values are not enabled")
+)
+
+/*
+WithInlineValues lets leaves store values of up to n bytes inline with their keys (see
+InsertInline), which saves the fetch of a tuple for indexes whose values are tiny. Larger
+values are stored elsewhere by the caller and inserted as a record id pointer with Insert,
+so a leaf can hold both kinds of values.
+
+Every leaf value is stored in a tagged format: a tag byte tells an inline value, stored as
+a length byte followed by the value, from a record id. Every entry reserves room for the
+larger of the two, which lowers the max order of the tree. Like the record id encoding,
+this is not recorded on the page, so a tree must always be opened with the same setting
+it was created with.
+*/
+func WithInlineValues(n int) Option {
+	return func(m *BPlusTreeMetadata) {
+		m.inlineSize = n
+	}
+}
+
+// LeafValue is the value of a key: either a value stored inline in the leaf or a record id.
+type LeafValue struct {
+	RecordId int    // the record id of the value, when it is not stored inline
+	Inline   []byte // the value stored inline in the leaf, nil for a record id
+}
+
+// IsInline reports whether the value is stored inline in the leaf.
+func (v LeafValue) IsInline() bool {
+	return v.Inline != nil
+}
+
+/*
+Inserts key k with a value that is stored inline in its leaf, like Insert does for a record id.
+Returns ErrInlineValueTooLarge if the value is larger than the inline value size of the tree,
+in which case the caller stores the value elsewhere and inserts its record id instead.
+*/
+func (t *bPlusTree) InsertInline(k int, value []byte) (bool, error) {
+	if t.metadata.inlineSize == 0 {
+		return false, ErrInlineValuesDisabled
+	}
+	if len(value) > t.metadata.inlineSize {
+		return false, fmt.Errorf("%w: %d bytes, at most %d", ErrInlineValueTooLarge, len(value), t.metadata.inlineSize)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// an empty value is inline too, only a nil value is a record id
+	return t.insert(k, LeafValue{Inline: append([]byte{}, value...)})
+}
+
+// Returns the value of key k, which is either stored inline or a record id, and whether k exists.
+func (t *bPlusTree) GetValue(k int) (LeafValue, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	root, err := t.root()
+	if err != nil {
+		log.Println(err)
+		return LeafValue{}, false
+	}
+	leaf, ok := root.(*leafNode)
+	if !ok {
+		leaf, err = root.(*innerNode).findLeaf(k)
+		if err != nil {
+			log.Println(err)
+			return LeafValue{}, false
+		}
+		defer t.bufferManager.Unpin(leaf.frame)
+	}
+	return leaf.getValue(k)
+}
+
+func (l *leafNode) getValue(k int) (LeafValue, bool) {
+	pos, ok := l.locate(k)
+	if !ok {
+		return LeafValue{}, false
+	}
+	v := LeafValue{RecordId: l.recordIds[pos]}
+	if l.treeMetadata.inlineSize > 0 && l.inline[pos] != nil {
+		v = LeafValue{Inline: slices.Clone(l.inline[pos])}
+	}
+	return v, true
+}
+
+// Returns the number of bytes a leaf value takes in the tagged value format, at most.
+func (m *BPlusTreeMetadata) taggedValueSize() int {
+	return 1 + max(m.recordIdSize, 1+m.inlineSize)
+}
+
+// Encodes an inline value, or rid if value is nil, at the start of b in the tagged value
+// format, and returns the number of bytes written.
+func (m *BPlusTreeMetadata) putTaggedValue(b []byte, rid int, value []byte) int {
+	if value == nil {
+		b[0] = recordIdTag
+		return 1 + m.putRecordId(b[1:], rid)
+	}
+	b[0] = inlineValueTag
+	b[1] = byte(len(value))
+	return 2 + copy(b[2:], value)
+}
+
+// Decodes a value from the start of b in the tagged value format, and returns the record id
+// or inline value (nil for a record id) with the number of bytes read.
+func (m *BPlusTreeMetadata) getTaggedValue(b []byte) (int, []byte, int, error) {
+	if len(b) < 2 {
+		return 0, nil, 0, fmt.Errorf("value does not fit in the page")
+	}
+	switch b[0] {
+	case recordIdTag:
+		rid, n, err := m.getRecordId(b[1:])
+		return rid, nil, 1 + n, err
+	case inlineValueTag:
+		n := int(b[1])
+		if 2+n > len(b) {
+			return 0, nil, 0, fmt.Errorf("inline value of %d bytes does not fit in the page", n)
+		}
+		return 0, slices.Clone(b[2 : 2+n]), 2 + n, nil
+	}
+	return 0, nil, 0, fmt.Errorf("unknown value tag %d", b[0])
+}
+
+// Returns the encoded size of an inline value, or of rid if value is nil.
+func (m *BPlusTreeMetadata) taggedValueLen(rid int, value []byte) int {
+	if value != nil {
+		return 2 + len(value)
+	}
+	var buf [ValueTypeSize + 2]byte
+	return 1 + m.putRecordId(buf[:], rid)
+}
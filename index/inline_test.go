@@ -0,0 +1,74 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func Test_inlineValues(t *testing.T) {
+	tree := newTestTree(t, 8, WithInlineValues(4))
+	values := map[int][]byte{}
+	for k := range 40 {
+		if k%3 == 0 {
+			// a large value is stored elsewhere, and pointed to by its record id
+			large := bytes.Repeat([]byte{byte(k)}, 16)
+			_, err := tree.InsertInline(k, large)
+			assertEqual(t, true, errors.Is(err, ErrInlineValueTooLarge), fmt.Sprint(err))
+			assertEqual(t, true, tree.Insert(k, 1000+k), "")
+			continue
+		}
+		values[k] = []byte(fmt.Sprint(k))
+		inserted, err := tree.InsertInline(k, values[k])
+		assertEqual(t, nil, err, "")
+		assertEqual(t, true, inserted, "")
+	}
+	inserted, err := tree.InsertInline(40, []byte{})
+	assertEqual(t, true, inserted && err == nil, fmt.Sprint(err))
+	values[40] = []byte{}
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+
+	// the first leaf holds both kinds of values
+	leaf, err := tree.Root.(*innerNode).findLeaf(0)
+	assertEqual(t, nil, err, "")
+	assertEqual(t, true, leaf.inline[0] == nil, "")
+	assertEqual(t, "1", string(leaf.inline[1]), "")
+	tree.bufferManager.Unpin(leaf.frame)
+
+	for k := range 41 {
+		v, ok := tree.GetValue(k)
+		assertEqual(t, true, ok, "")
+		if want, inline := values[k]; inline {
+			assertEqual(t, true, v.IsInline(), fmt.Sprintf("key %d", k))
+			assertEqual(t, string(want), string(v.Inline), "")
+		} else {
+			assertEqual(t, false, v.IsInline(), fmt.Sprintf("key %d", k))
+			assertEqual(t, 1000+k, v.RecordId, "")
+		}
+	}
+	_, ok := tree.GetValue(100)
+	assertEqual(t, false, ok, "")
+
+	// an overwrite replaces an inline value by a record id and back, and the tree keeps a copy
+	// of the value it was given
+	tree = newTestTree(t, 8, WithInlineValues(4), WithOverwrite())
+	value := []byte("a")
+	_, err = tree.InsertInline(1, value)
+	assertEqual(t, nil, err, "")
+	value[0] = 'x'
+	v, _ := tree.GetValue(1)
+	assertEqual(t, "a", string(v.Inline), "")
+	tree.Insert(1, 7)
+	v, _ = tree.GetValue(1)
+	assertEqual(t, false, v.IsInline(), "")
+	assertEqual(t, 7, v.RecordId, "")
+	_, err = tree.InsertInline(1, []byte("b"))
+	assertEqual(t, nil, err, "")
+	v, _ = tree.GetValue(1)
+	assertEqual(t, "b", string(v.Inline), "")
+
+	tree = newTestTree(t, 8)
+	_, err = tree.InsertInline(1, []byte{1})
+	assertEqual(t, ErrInlineValuesDisabled, err, "")
+}
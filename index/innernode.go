@@ -25,12 +25,13 @@ To satisfy the B+ tree invariant:
 (3) During deletion, two half-full internal pages are  merged, to ensure the node is at least half-full
 
 A inner node includes:
-	1. header (12 bytes);
+	1. header (20 bytes);
 		1.1 the type of node (leaf or internal) (4 bytes),
-		1.2 the number of keys (4 bytes),
-		1.3 right sibling pointer (4 bytes)
+		1.2 the number of keys and child pointers (4 bytes),
+		1.3 right sibling page id (8 bytes)
+		1.4 the version of the page, bumped on every write of the node (4 bytes)
 	2. a list of n keys
-	3. a list of pointers to n+1 children.
+	3. a list of page ids of n+1 children (8 bytes each).
 
 -----(Internal page structure/layout copied from the CMU db impl)------
  * Internal page format (keys are stored in increasing order):
@@ -47,9 +48,8 @@ A inner node includes:
 */
 
 // All sizes are in bytes
-const InternalPageHeaderSize = 12
-const InternalPageSlotCount = io.PageSize - InternalPageHeaderSize/(KeySize+ValueTypeSize)
-const NonExistentSiblingLink = math.MaxInt
+const InternalPageHeaderSize = 20
+const InternalPageSlotCount = (io.PageSize - InternalPageHeaderSize) / (KeySize + PageIdSize)
 
 // For use with methods that do not need a non-nil pointer/value receiver
 var InnerNode innerNode
@@ -60,6 +60,7 @@ type innerNode struct {
 	keys          []int
 	children      []uint64 // page numbers of child nodes
 	rightSibling  int
+	version       uint32        // the version of the page, see pageVersion
 	frame         *memory.Frame // page on which this node is serialized on
 }
 
@@ -83,7 +84,7 @@ func newInnerNode(b *memory.BufferPoolManager, m *BPlusTreeMetadata) *innerNode
 	}
 }
 
-func createInnerNodeFromPage(b *memory.BufferPoolManager, m *BPlusTreeMetadata, f *memory.Frame) *innerNode {
+func createInnerNodeFromPage(b *memory.BufferPoolManager, m *BPlusTreeMetadata, f *memory.Frame) (*innerNode, error) {
 	inner := &innerNode{
 		treeMetadata:  m,
 		bufferManager: b,
@@ -92,8 +93,10 @@ func createInnerNodeFromPage(b *memory.BufferPoolManager, m *BPlusTreeMetadata,
 		// rightSibling:  memory.InvalidPageId,
 		frame: f,
 	}
-	_, _ = inner.fromBytes(f.Data) // modifies new inner node
-	return inner
+	if _, err := inner.fromBytes(f.Data); err != nil { // modifies new inner node
+		return nil, err
+	}
+	return inner, nil
 }
 
 func (i *innerNode) isLeaf() bool {
@@ -105,19 +108,18 @@ func (n *innerNode) getSize() int {
 	return len(n.keys) + len(n.children)
 }
 
-// Returns the max number of key/pointer pairs stored in the leaf
-// assuming (4k page size - 16 page header size)/ (64+64) ~~ approx. 255 keys
+// Returns the max size of the node, which is derived from the order of the tree.
+// The size counts both keys and child pointers, so the node holds up to order key/child pointer pairs.
 func (i *innerNode) getMaxSize() int {
-	// return InternalPageSlotCount
-	return 4 * 2
+	return 2 * i.treeMetadata.order
 }
 
 func (i *innerNode) getPageId() int {
 	return i.frame.PageId
 }
 
-func (i *innerNode) getParent() *innerNode {
-	return i.treeMetadata.removeAncestor()
+func (i *innerNode) getFrame() *memory.Frame {
+	return i.frame
 }
 
 func (i *innerNode) getSeparatorKey() (int, bool) {
@@ -127,20 +129,67 @@ func (i *innerNode) getSeparatorKey() (int, bool) {
 	return i.keys[1], true // get the second item in the list because the first is a null key
 }
 
+// Return the value associated with a given key by descending into the child subtree
+// that covers the key. Returns the zero value and false if the key does not exist or
+// if a child page cannot be loaded.
 func (n *innerNode) get(key int) (int, bool) {
-	pos, _ := slices.BinarySearch(n.keys, key)
-	childPageId := n.children[pos-1]
-	childPage, err := n.bufferManager.GetPage(int(childPageId))
+	leaf, err := n.findLeaf(key)
 	if err != nil {
 		log.Println(err)
-		return -1, false
+		return 0, false
 	}
-	node, err := n.fromBytes(childPage.Data)
-	if err != nil {
-		log.Println(err)
-		return -1, false
+	defer n.bufferManager.Unpin(leaf.frame)
+	return leaf.get(key)
+}
+
+/*
+Finds the leaf node that covers key k, ie. the leaf in which k is located or would be inserted.
+
+Unlike search, this is a read-only descent: ancestors are not recorded on the tree's
+seen stack and every inner node loaded on the way down is unpinned once its child
+pointer has been read. The returned leaf is pinned and must be unpinned by the caller.
+*/
+func (n *innerNode) findLeaf(k int) (*leafNode, error) {
+	return n.descend(func(c *innerNode) int { return c.childIndex(k) })
+}
+
+// Descends from n to a leaf, following the child pointer at the index pick returns for
+// every inner node on the way. Inner nodes are unpinned once their child pointer has been
+// read; the returned leaf is pinned and must be unpinned by the caller.
+// n is the root, since the type of every child is checked against its level (see fetchChild).
+func (n *innerNode) descend(pick func(*innerNode) int) (*leafNode, error) {
+	childPageId := int(n.children[pick(n)])
+	for level := 1; ; level++ {
+		child, err := fetchChild(n.bufferManager, n.treeMetadata, childPageId, level)
+		if err != nil {
+			return nil, err
+		}
+		switch c := child.(type) {
+		case *leafNode:
+			return c, nil
+		case *innerNode:
+			childPageId = int(c.children[pick(c)])
+			n.bufferManager.Unpin(c.frame)
+		}
 	}
-	return node.get(key)
+}
+
+// Returns the index of the child pointer that covers key k, ie. the position of
+// the last key that is less than or equal to k. The first key is the invalid
+// (min) key, which is never compared, so every k maps onto some child.
+func (n *innerNode) childIndex(k int) int {
+	pos, found := n.treeMetadata.searchKeys(n.keys[1:], k)
+	if found {
+		return pos + 1
+	}
+	return pos
+}
+
+// Returns the index of the child pointer that covers the keys right before k, ie. the
+// subtree that holds the largest key less than k.
+func (n *innerNode) childIndexBefore(k int) int {
+	pos, _ := n.treeMetadata.searchKeys(n.keys[1:], k)
+	return pos
 }
 
 /*
@@ -154,97 +203,159 @@ pointer in the node points to the subtree holding items greater than or equal to
 
 Other pointers are reference subtrees between the two keys: Ki-1 ≤ Ks < Ki, where K is a set of
 keys, and Ks is a key that belongs to the subtree.
+
+The page id of every inner node on the path (starting with n) is pushed onto the tree's seen
+stack, so that splits can be propagated up to the ancestors. Inner nodes loaded during the
+traversal are unpinned once their child pointer has been read, so a descent never holds more
+than n and one other page; ancestors are loaded again when a split has to be pushed into them.
+The returned leaf is pinned and must be unpinned by the caller. If a page on the path cannot
+be loaded (eg. because every frame of the buffer pool is pinned), the error is returned, and
+so is a child of the wrong type for its level below n, which is the root (see fetchChild).
 */
-func (n *innerNode) search(k int) (*leafNode, bool) {
-	var currNode *innerNode
-	currPageFrame := n.frame
-	currNode = n
-	// perform lookup in inner node for the next page pointer
-	for getPageType(currPageFrame) == 0 {
+func (n *innerNode) search(k int) (*leafNode, error) {
+	currNode := n
+	for level := 1; ; level++ {
 		// mark current node as seen
-		n.treeMetadata.seen = append(n.treeMetadata.seen, n) // append node to seen nodes (this includes any inner root node)
+		n.treeMetadata.seen = append(n.treeMetadata.seen, currNode.getPageId()) // this includes any inner root node
 		// get next page pointer/id using binary search
-		pos, _ := slices.BinarySearch(currNode.keys, k)
-		fmt.Printf("Inner node: getting corresponding pointer for key at position: %d\n", pos)
-		var nextPageId int
-		if pos == 0 || (pos < len(currNode.keys) && k >= currNode.keys[pos]) {
-			nextPageId = int(currNode.children[pos])
-		} else {
-			nextPageId = int(currNode.children[pos-1])
+		nextPageId := int(currNode.children[currNode.childIndex(k)])
+		if currNode != n {
+			n.bufferManager.Unpin(currNode.frame)
+		}
+		// load next page into memory and pin it
+		next, err := fetchChild(n.bufferManager, n.treeMetadata, nextPageId, level)
+		if err != nil {
+			return nil, err
 		}
-		fmt.Printf("Inner node: got corresponding page pointer: %d\n", nextPageId)
-		// load next page into memory
-		currPageFrame, _ = n.bufferManager.GetPage(nextPageId) // load next page into memory and pin it
-		if getPageType(currPageFrame) == 0 {
-			currNode = createInnerNodeFromPage(n.bufferManager, n.treeMetadata, currPageFrame)
+		switch c := next.(type) {
+		case *leafNode:
+			return c, nil
+		case *innerNode:
+			currNode = c
 		}
 	}
-	return createLeafNodeFromPage(n.bufferManager, n.treeMetadata, currPageFrame).search(k)
 }
 
-// Insert a key and page pointer pair into node.
-// Returns true, if key/child pointer insertion was successful. Otherwise false,
-// if insertion failed.
-func (n *innerNode) insert(key int, pageId int) bool {
+/*
+Insert a key and page pointer pair into node.
+Returns true, if key/child pointer insertion was successful. Otherwise false,
+if insertion failed.
+
+The node's frame must be pinned by the caller. When the node is full it is split into
+two: the keys are redistributed evenly between this node and a new right sibling, and the
+middle key has to be pushed up into the parent. The split is returned to the caller, which
+pushes it up into the parent, or grows the tree by a level if this node is the root. When no
+page can be allocated for the new sibling, the node is left as is and an error wrapping
+memory.ErrBufferPoolFull is returned.
+*/
+func (n *innerNode) insert(key int, pageId int) (bool, *nodeSplit, error) {
 	// perform lookup of where to insert
-	fmt.Printf("Inner node: inserting k,v pair: %+v,%+v\n", key, pageId)
 	// case 0. internal node is nil
 	if n == nil {
 		log.Println(ErrNilNode.Error())
-		return false
+		return false, nil, nil
 	}
 
-	// Pin frame because it is being written to.
-	n.bufferManager.Pin(n.frame)
-
 	// case 1. internal node is not full
 	if n.getMaxSize()-n.getSize() >= 1 {
-		fmt.Printf("Innernode: is not full inserting k,v pair: %d,%d\n", key, pageId)
 		n.sInsert(key, uint64(pageId))
 		n.toBytes()
-		fmt.Printf("Innernode: updated inner node: %+v\n", n)
-		return true
+		n.bufferManager.MarkDirty(n.frame)
+		assertNode("inner insert", n)
+		return true, nil, nil
 	}
 
 	// case 2. internal node is full
 	// to split inner node, redistribute keys evenly, but push up middle key
-	newPageFrame, err := n.bufferManager.GetNewPageFrame()
-	if err != nil {
-		return false
-	}
 	newNode := newInnerNode(n.bufferManager, n.treeMetadata)
-	newNode.frame = newPageFrame
-	// create new right node and redistribute keys
+	if newNode == nil {
+		return false, nil, fmt.Errorf("%w: unable to split inner node %d", memory.ErrBufferPoolFull, n.getPageId())
+	}
+	defer n.bufferManager.Unpin(newNode.frame)
 	n.sInsert(key, uint64(pageId))
 	mid := len(n.keys) / 2
-	newNode.keys = n.keys[mid+1:]
-	newNode.children = n.children[mid+1:]
-	newNode.rightSibling = NonExistentSiblingLink
+	separatorKey := n.keys[mid]
+	// the child pointer of the separator key becomes the first (invalid key) pointer of the new node
+	newNode.keys = append(newNode.keys, n.keys[mid+1:]...)
+	newNode.children = slices.Clone(n.children[mid:])
+	newNode.rightSibling = n.rightSibling
 
 	// update the split node
-	n.keys = n.keys[:mid]
-	n.children = n.children[:mid]
-	n.rightSibling = newPageFrame.PageId
+	n.keys = slices.Clip(n.keys[:mid])
+	n.children = slices.Clip(n.children[:mid])
+	n.rightSibling = newNode.getPageId()
 
 	// persist changes to frame/page in memory
 	newNode.toBytes()
 	n.toBytes()
+	n.bufferManager.MarkDirty(newNode.frame)
+	n.bufferManager.MarkDirty(n.frame)
 
-	// copy new separator key into parent and unpin parent node after update
-	// todo: set parent pointer
-	separatorKey := n.keys[mid]
-	n.getParent().insert(separatorKey, newNode.frame.PageId)
-	n.bufferManager.Unpin(n.getParent().frame)
-	return true
+	assertSplit("inner split", n, newNode, separatorKey)
+	// the separator key is pushed up into the parent by the caller
+	return true, &nodeSplit{key: separatorKey, pageId: newNode.getPageId()}, nil
+}
+
+// Reports whether the node is less than half full, which an inner node other than the root must not be.
+func (n *innerNode) underflows() bool {
+	return n.getSize() < n.getMaxSize()/2
+}
+
+/*
+Moves every key and child pointer of its right sibling r into n, and links n to the right
+sibling of r. The separator key of r is pulled down from the parent to become the key of the
+first child pointer of r, which has the invalid key in r. The caller removes the separator
+key and the child pointer of r from the parent, and frees the page of r, which is left as is.
+*/
+func (n *innerNode) merge(r *innerNode, separator int) {
+	n.keys = append(n.keys, separator)
+	n.keys = append(n.keys, r.keys[1:]...)
+	n.children = append(n.children, r.children...)
+	n.rightSibling = r.rightSibling
+}
+
+/*
+Redistributes the child pointers of n and its right sibling r evenly between the two nodes,
+rotating keys through the parent: the separator key of r is pulled down, and the key of the
+first child pointer that moves to r is pushed up in its place. Returns the new separator key
+of r, which the caller stores in the parent.
+*/
+func (n *innerNode) redistribute(r *innerNode, separator int) int {
+	keys := slices.Concat(n.keys[1:], []int{separator}, r.keys[1:])
+	children := slices.Concat(n.children, r.children)
+	mid := len(children) / 2
+	n.keys = slices.Concat([]int{math.MinInt}, keys[:mid-1])
+	n.children = slices.Clone(children[:mid])
+	r.keys = slices.Concat([]int{math.MinInt}, keys[mid:])
+	r.children = slices.Clone(children[mid:])
+	return keys[mid-1]
+}
+
+/*
+Creates a new root inner node whose first child pointer is the page of the current root,
+and records it as the root of the tree. This is called when the current root is split.
+
+The new root is returned pinned; the tree takes over that pin once the split key of
+the old root has been inserted into it.
+*/
+func newRootNode(b *memory.BufferPoolManager, m *BPlusTreeMetadata, childPageId int) *innerNode {
+	root := newInnerNode(b, m)
+	if root == nil {
+		return nil
+	}
+	root.children = append(root.children, uint64(childPageId))
+	m.rootPageId = root.getPageId()
+	return root
 }
 
 func (n *innerNode) sInsert(k int, pageId uint64) {
-	pos, found := slices.BinarySearch(n.keys, k)
+	// the first key is the invalid key, which is never compared
+	pos, found := n.treeMetadata.searchKeys(n.keys[1:], k)
 	if found {
 		return // only support unique keys
 	}
-	n.keys = slices.Insert(n.keys, pos, k)
-	n.children = slices.Insert(n.children, pos, pageId) // there's n+1 children for n keys
+	n.keys = slices.Insert(n.keys, pos+1, k)
+	n.children = slices.Insert(n.children, pos+1, pageId) // there's n+1 children for n keys
 }
 
 // toBytes serializes an inner node to a slice of bytes
@@ -252,19 +363,20 @@ func (n *innerNode) toBytes() error {
 	if len(n.children) != len(n.keys) {
 		return fmt.Errorf("number of children equal to the number of keys")
 	}
-	// clear buffer contents before write
-	n.frame.ZeroBuffer()
 	// insert header values
 	binary.BigEndian.PutUint32(n.frame.Data[0:], uint32(0))
 	binary.BigEndian.PutUint32(n.frame.Data[4:], uint32(n.getSize()))
-	binary.BigEndian.PutUint32(n.frame.Data[8:], uint32(n.rightSibling))
+	putPageId(n.frame.Data[8:], n.rightSibling)
+	n.version = bumpVersion(n.frame.Data[innerVersionOffset:])
 	for i := range n.keys {
-		binary.BigEndian.PutUint64(n.frame.Data[12+i*8:], uint64(n.keys[i])) // todo: dynamically set key size based on key type
+		binary.BigEndian.PutUint64(n.frame.Data[InternalPageHeaderSize+i*KeySize:], uint64(n.keys[i])) // todo: dynamically set key size based on key type
 	}
-	childrenOffset := 12 + (8 * len(n.keys))
+	childrenOffset := InternalPageHeaderSize + (KeySize * len(n.keys))
 	for i := range n.children {
-		binary.BigEndian.PutUint64(n.frame.Data[childrenOffset+i*8:], uint64(n.children[i]))
+		binary.BigEndian.PutUint64(n.frame.Data[childrenOffset+i*PageIdSize:], n.children[i])
 	}
+	// only the bytes past the node are cleared, the used region has just been overwritten
+	n.frame.ZeroFrom(childrenOffset + len(n.children)*PageIdSize)
 	return nil
 }
 
@@ -280,21 +392,26 @@ func (n *innerNode) fromBytes(data []byte) (BPlusTreeNode, error) {
 	if pageType != uint32(0) {
 		return nil, fmt.Errorf("not an inner node")
 	}
-	keyCount := binary.BigEndian.Uint32(data[4:])
-	rightSibling := binary.BigEndian.Uint32(data[8:])
+	size := int(binary.BigEndian.Uint32(data[4:]))
+	rightSibling := getPageId(data[8:])
+	keyCount := size / 2 // the size counts both keys and child pointers
+	if InternalPageHeaderSize+keyCount*(KeySize+PageIdSize) > len(data) {
+		return nil, fmt.Errorf("inner page size %d does not fit in the page", size)
+	}
 	// parse keys
 	keys, pagePointers := []int{}, []uint64{}
-	for i := 0; i < int(keyCount/2); i++ {
-		keys = append(keys, int(binary.BigEndian.Uint64(data[InternalPageHeaderSize+i*8:])))
+	for i := 0; i < keyCount; i++ {
+		keys = append(keys, int(binary.BigEndian.Uint64(data[InternalPageHeaderSize+i*KeySize:])))
 	}
 	// parse page pointers
-	childrenOffset := int(InternalPageHeaderSize + keyCount*8)
-	for i := 0; i < int(keyCount/2); i++ {
-		pagePointers = append(pagePointers, binary.LittleEndian.Uint64(data[childrenOffset+i*8:]))
+	childrenOffset := InternalPageHeaderSize + keyCount*KeySize
+	for i := 0; i < keyCount; i++ {
+		pagePointers = append(pagePointers, binary.BigEndian.Uint64(data[childrenOffset+i*PageIdSize:]))
 	}
 	n.keys = keys
 	n.children = pagePointers
-	n.rightSibling = int(rightSibling)
+	n.rightSibling = rightSibling
+	n.version = binary.BigEndian.Uint32(data[innerVersionOffset:])
 
 	// return &innerNode{
 	// 	keys:          keys,
@@ -0,0 +1,140 @@
+package index
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func Test_innerNodeRoundTripsWidePageIds(t *testing.T) {
+	bpm := newTestBufferPool(t, 4)
+	m := NewBPlusTreeMetadata("primary")
+	inner := newInnerNode(bpm, m)
+	inner.keys = []int{math.MinInt, 10, 20}
+	inner.children = []uint64{3, 1 << 32, 1<<40 + 9} // beyond the range of a 32-bit page id
+	inner.rightSibling = 1<<33 + 1
+	if err := inner.toBytes(); err != nil {
+		t.Fatalf("unable to serialize inner node: %v", err)
+	}
+
+	decoded := &innerNode{treeMetadata: m, bufferManager: bpm, frame: inner.frame}
+	if _, err := decoded.fromBytes(inner.frame.Data); err != nil {
+		t.Fatalf("unable to deserialize inner node: %v", err)
+	}
+	assertEqual(t, 1<<33+1, decoded.rightSibling, "right sibling page id must not be truncated")
+	assertEqual(t, len(inner.keys), len(decoded.keys), "")
+	assertEqual(t, len(inner.children), len(decoded.children), "")
+	for i := range inner.keys {
+		assertEqual(t, inner.keys[i], decoded.keys[i], "")
+		assertEqual(t, inner.children[i], decoded.children[i], "child page ids must not be truncated")
+	}
+}
+
+func Test_innerNodeChildrenAreBigEndian(t *testing.T) {
+	bpm := newTestBufferPool(t, 4)
+	m := NewBPlusTreeMetadata("primary")
+	inner := newInnerNode(bpm, m)
+	inner.keys = []int{math.MinInt, 10, 20, 30}
+	// child page ids whose bytes differ, so that a reader of the wrong byte order reverses them
+	inner.children = []uint64{0x0102030405060708, 0x1122334455667788, 7, 1 << 56}
+	if err := inner.toBytes(); err != nil {
+		t.Fatalf("unable to serialize inner node: %v", err)
+	}
+	childrenOffset := InternalPageHeaderSize + KeySize*len(inner.keys)
+	want := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	assertEqual(t, true, slices.Equal(want, inner.frame.Data[childrenOffset:childrenOffset+PageIdSize]), "")
+
+	decoded := &innerNode{treeMetadata: m, bufferManager: bpm, frame: inner.frame}
+	if _, err := decoded.fromBytes(inner.frame.Data); err != nil {
+		t.Fatalf("unable to deserialize inner node: %v", err)
+	}
+	if !slices.Equal(inner.children, decoded.children) {
+		t.Fatalf("children %x became %x", inner.children, decoded.children)
+	}
+}
+
+func Test_innerNodeSiblingChain(t *testing.T) {
+	tree := newTestTree(t, 64)
+	for i := 1; i <= 40; i++ {
+		tree.Insert(i, i*10)
+	}
+	for i := 1; i <= 40; i++ {
+		v, ok := tree.Get(i)
+		assertEqual(t, true, ok, "")
+		assertEqual(t, i*10, v, "")
+	}
+
+	// Walk each level of the tree from its leftmost node along the sibling chain.
+	// The chain of a level must visit exactly the children of the level above, in order.
+	root, ok := tree.Root.(*innerNode)
+	if !ok {
+		t.Fatalf("expected the root to be an inner node")
+	}
+	parents := []*innerNode{root}
+	levels := 1
+	for {
+		var expected []uint64
+		for _, p := range parents {
+			expected = append(expected, p.children...)
+		}
+
+		var chain []BPlusTreeNode
+		pageId := int(expected[0])
+		for pageId != -1 {
+			node, err := fetchNodeByPage(tree.bufferManager, tree.metadata, pageId)
+			if err != nil {
+				t.Fatalf("unable to fetch page %d: %v", pageId, err)
+			}
+			tree.bufferManager.Unpin(node.getFrame())
+			chain = append(chain, node)
+			switch n := node.(type) {
+			case *innerNode:
+				pageId = n.rightSibling
+			case *leafNode:
+				pageId = n.rightSibling
+			}
+		}
+		assertEqual(t, len(expected), len(chain), "sibling chain must cover the whole level")
+		for i := range chain {
+			assertEqual(t, int(expected[i]), chain[i].getPageId(), "")
+		}
+		levels++
+		if chain[0].isLeaf() {
+			break
+		}
+		parents = parents[:0]
+		for _, node := range chain {
+			parents = append(parents, node.(*innerNode))
+		}
+	}
+	assertEqual(t, true, levels > 3, "expected a tree with more than one level of inner nodes")
+}
+
+func Test_innerNodeRoundTripProperty(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	bpm := newTestBufferPool(t, 4)
+	m := NewBPlusTreeMetadata("primary")
+	inner := newInnerNode(bpm, m)
+	for range 1000 {
+		n := 1 + rng.Intn(inner.getMaxSize()/2)
+		inner.keys = append([]int{math.MinInt}, randomSortedKeys(rng, n-1)...)
+		inner.children = make([]uint64, n)
+		for i := range inner.children {
+			inner.children[i] = uint64(rng.Int63n(1 << 48))
+		}
+		inner.rightSibling = rng.Intn(1<<40) - 1
+		if err := inner.toBytes(); err != nil {
+			t.Fatalf("unable to serialize inner node %+v: %v", inner, err)
+		}
+
+		decoded := &innerNode{treeMetadata: m, bufferManager: bpm, frame: inner.frame}
+		if _, err := decoded.fromBytes(inner.frame.Data); err != nil {
+			t.Fatalf("unable to deserialize inner node %+v: %v", inner, err)
+		}
+		if !slices.Equal(inner.keys, decoded.keys) || !slices.Equal(inner.children, decoded.children) ||
+			inner.rightSibling != decoded.rightSibling {
+			t.Fatalf("inner node did not round-trip: %+v became %+v", inner, decoded)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package index
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"wtfDB/memory"
+)
+
+var ErrCorruptTree = fmt.Errorf("b+ tree integrity violation")
+
+/*
+CheckIntegrity walks the whole tree and verifies its structural invariants:
+  - keys within every node are strictly increasing, and an inner node's first key is the invalid (min) key
+  - every node has as many keys as record ids/child pointers and does not exceed its max size
+  - every key of a subtree lies within the key range its parent assigns to it
+  - non-root nodes are not empty, and all leaves are at the same depth, the height of the tree
+  - the right sibling links of each level chain the nodes of that level from left to right,
+    and the last node of a level has no right sibling
+
+Returns an error wrapping ErrCorruptTree that describes the first violation found,
+or the error of a page that could not be loaded. Every page touched is unpinned again.
+*/
+func (t *bPlusTree) CheckIntegrity() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	c := &integrityCheck{
+		tree:      t,
+		leafLevel: -1,
+		lastNode:  make(map[int]BPlusTreeNode),
+	}
+	if err := c.checkSubtree(t.rootPage(), 0, nil, nil); err != nil {
+		return err
+	}
+	for level, node := range c.lastNode {
+		if sibling := rightSiblingOf(node); sibling != memory.InvalidPageId {
+			return c.violation(node, "last node of level %d has right sibling %d", level, sibling)
+		}
+	}
+	return nil
+}
+
+type integrityCheck struct {
+	tree      *bPlusTree
+	leafLevel int                   // the level of the leaves, -1 until the first leaf is visited
+	lastNode  map[int]BPlusTreeNode // the last node visited on each level, to check the sibling chain
+}
+
+// Checks the subtree rooted at pageId, whose keys k must satisfy lo <= k < hi in the order of the
+// tree's comparator; a nil bound leaves that side of the range open.
+// Nodes are visited depth first, left to right, so the nodes of each level are visited in sibling order.
+func (c *integrityCheck) checkSubtree(pageId int, level int, lo *int, hi *int) error {
+	node, err := fetchNodeByPage(c.tree.bufferManager, c.tree.metadata, pageId)
+	if err != nil {
+		return err
+	}
+	c.tree.bufferManager.Unpin(node.getFrame())
+
+	if prev, ok := c.lastNode[level]; ok && rightSiblingOf(prev) != pageId {
+		return c.violation(prev, "right sibling is %d, expected %d", rightSiblingOf(prev), pageId)
+	}
+	c.lastNode[level] = node
+	if node.getSize() > node.getMaxSize() {
+		return c.violation(node, "size %d exceeds max size %d", node.getSize(), node.getMaxSize())
+	}
+
+	switch n := node.(type) {
+	case *leafNode:
+		if c.leafLevel == -1 {
+			c.leafLevel = level
+		}
+		if level != c.leafLevel {
+			return c.violation(n, "leaf at level %d, expected all leaves at level %d", level, c.leafLevel)
+		}
+		if height := c.tree.metadata.height; height >= 0 && level != height {
+			return c.violation(n, "leaf at level %d, but the tree has height %d", level, height)
+		}
+		if len(n.keys) != len(n.recordIds) {
+			return c.violation(n, "%d keys but %d record ids", len(n.keys), len(n.recordIds))
+		}
+		if len(n.keys) == 0 && level > 0 {
+			return c.violation(n, "non-root leaf is empty")
+		}
+		return c.checkKeys(n, n.keys, lo, hi)
+	case *innerNode:
+		if len(n.keys) != len(n.children) {
+			return c.violation(n, "%d keys but %d children", len(n.keys), len(n.children))
+		}
+		if len(n.children) == 0 {
+			return c.violation(n, "inner node has no children")
+		}
+		if n.keys[0] != math.MinInt {
+			return c.violation(n, "first key is %d, expected the invalid key", n.keys[0])
+		}
+		if err := c.checkKeys(n, n.keys[1:], lo, hi); err != nil {
+			return err
+		}
+		for i, child := range n.children {
+			childLo, childHi := lo, hi
+			if i > 0 {
+				childLo = &n.keys[i]
+			}
+			if i+1 < len(n.keys) {
+				childHi = &n.keys[i+1]
+			}
+			if err := c.checkSubtree(int(child), level+1, childLo, childHi); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Checks that keys are strictly increasing and lie within [lo, hi).
+func (c *integrityCheck) checkKeys(node BPlusTreeNode, keys []int, lo *int, hi *int) error {
+	compare := c.tree.metadata.compare
+	for i, k := range keys {
+		if i > 0 && compare(keys[i-1], k) >= 0 {
+			return c.violation(node, "keys are not strictly increasing: %d before %d", keys[i-1], k)
+		}
+		if (lo != nil && compare(k, *lo) < 0) || (hi != nil && compare(k, *hi) >= 0) {
+			return c.violation(node, "key %d outside of the range [%s, %s) assigned by its parent", k, bound(lo), bound(hi))
+		}
+	}
+	return nil
+}
+
+// Formats a key range bound, an open bound is printed as "-".
+func bound(b *int) string {
+	if b == nil {
+		return "-"
+	}
+	return strconv.Itoa(*b)
+}
+
+func (c *integrityCheck) violation(node BPlusTreeNode, format string, args ...any) error {
+	return fmt.Errorf("%w: page %d: %s", ErrCorruptTree, node.getPageId(), fmt.Sprintf(format, args...))
+}
+
+func rightSiblingOf(node BPlusTreeNode) int {
+	switch n := node.(type) {
+	case *leafNode:
+		return n.rightSibling
+	case *innerNode:
+		return n.rightSibling
+	}
+	return memory.InvalidPageId
+}
@@ -0,0 +1,278 @@
+package index
+
+import (
+	"slices"
+	"wtfDB/memory"
+)
+
+/*
+An Iterator walks the entries of a B+ tree in key order.
+
+The iterator keeps a copy of the keys and record ids of the leaf it is positioned on,
+so no page stays pinned between calls, and the tree latch is only held while a leaf is
+copied: writers are not blocked by an open iterator, however long the scan takes. Next
+follows the right sibling link of the current leaf onto the next leaf, as long as the page
+of the current leaf did not change since it was copied (see pageVersion); otherwise it
+descends from the root to the leaf holding the next larger key. Leaves only link to their
+right sibling, so stepping backwards onto the previous leaf descends from the root to the
+leaf holding the largest key smaller than the first key of the current leaf. Keys are
+visited in the order of the tree's comparator.
+
+An iterator reflects the leaf it copied; inserts made while iterating may not be seen.
+*/
+type Iterator struct {
+	tree       *bPlusTree
+	leafPageId int    // the page id of the leaf the iterator is positioned on
+	version    uint32 // the page version of the leaf when it was copied
+	keys       []int  // the keys of the current leaf
+	recordIds  []int  // the record ids of the current leaf
+	pos        int    // the index of the current entry in keys, -1 once exhausted
+	err        error  // the first error encountered while moving between leaves
+
+	bounded bool // whether the iterator is limited to the keys in [lo, hi), see Scan
+	lo, hi  int
+
+	pagesVisited int // the number of leaf pages the iterator has been positioned on
+}
+
+/*
+Scan returns an iterator over the entries whose keys are in [lo, hi), positioned at the
+entry with the smallest such key, to walk the range in ascending key order with Next:
+
+	for it, err := tree.Scan(lo, hi); err == nil && it.Valid(); it.Next() {
+		...
+	}
+
+The iterator is not valid if the range is empty. Prev does not move below lo either.
+*/
+func (t *bPlusTree) Scan(lo, hi int) (*Iterator, error) {
+	it := &Iterator{tree: t, bounded: true, lo: lo, hi: hi}
+	if err := it.seek(lo, true); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+/*
+SeekLast returns an iterator positioned at the largest key of the tree, to walk
+the tree in descending key order with Prev. The iterator is not valid if the tree is empty.
+*/
+func (t *bPlusTree) SeekLast() (*Iterator, error) {
+	it := &Iterator{tree: t}
+	if err := it.load(func(n *innerNode) int { return len(n.children) - 1 }); err != nil {
+		return nil, err
+	}
+	it.pos = len(it.keys) - 1
+	return it, nil
+}
+
+// Valid reports whether the iterator is positioned at an entry, within the range of a Scan.
+func (it *Iterator) Valid() bool {
+	if it.pos < 0 || it.pos >= len(it.keys) {
+		return false
+	}
+	if !it.bounded {
+		return true
+	}
+	compare := it.tree.metadata.compare
+	return compare(it.keys[it.pos], it.lo) >= 0 && compare(it.keys[it.pos], it.hi) < 0
+}
+
+// Key returns the key of the current entry. It must only be called when the iterator is valid.
+func (it *Iterator) Key() int {
+	return it.keys[it.pos]
+}
+
+// Value returns the record id of the current entry. It must only be called when the iterator is valid.
+func (it *Iterator) Value() int {
+	return it.recordIds[it.pos]
+}
+
+/*
+PagesVisited returns the number of leaf pages the iterator has visited so far: the leaf it
+was positioned on first, plus one for every move onto a neighbouring leaf. Together with the
+number of entries visited, this shows how well a scan's leaves are filled.
+*/
+func (it *Iterator) PagesVisited() int {
+	return it.pagesVisited
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+/*
+Next moves the iterator to the entry with the next larger key, and reports whether the
+iterator is still valid. Once the last entry of the tree (or of the range of a Scan) is
+passed, the iterator is exhausted and Next keeps returning false.
+*/
+func (it *Iterator) Next() bool {
+	if !it.Valid() {
+		return false
+	}
+	if it.pos < len(it.keys)-1 {
+		it.pos++
+		return it.Valid()
+	}
+	if err := it.seek(it.keys[it.pos], false); err != nil {
+		it.err = err
+		it.keys, it.recordIds, it.pos = nil, nil, -1
+	}
+	return it.Valid()
+}
+
+/*
+Prev moves the iterator to the entry with the next smaller key, and reports whether
+the iterator is still valid. Once the first entry of the tree is passed, the iterator
+is exhausted and Prev keeps returning false.
+*/
+func (it *Iterator) Prev() bool {
+	if !it.Valid() {
+		return false
+	}
+	if it.pos > 0 {
+		it.pos--
+		return it.Valid()
+	}
+
+	// step onto the previous leaf, the leaf whose key range ends right before this one
+	first, current := it.keys[0], it.leafPageId
+	if err := it.load(func(n *innerNode) int { return n.childIndexBefore(first) }); err != nil {
+		it.err = err
+	} else if it.leafPageId != current && len(it.keys) > 0 {
+		it.pos = len(it.keys) - 1
+		return it.Valid()
+	}
+	// otherwise this is the leftmost leaf, which has no predecessor
+	it.keys, it.recordIds, it.pos = nil, nil, -1
+	return false
+}
+
+// Copies the entries of the leaf reached by descending from the root along the child
+// pointers pick returns (see innerNode.descend).
+func (it *Iterator) load(pick func(*innerNode) int) error {
+	it.tree.mu.RLock()
+	defer it.tree.mu.RUnlock()
+	node, err := it.tree.root()
+	if err != nil {
+		return err
+	}
+	var leaf *leafNode
+	switch root := node.(type) {
+	case *leafNode:
+		leaf = root
+	case *innerNode:
+		l, err := root.descend(pick)
+		if err != nil {
+			return err
+		}
+		defer it.tree.bufferManager.Unpin(l.frame)
+		leaf = l
+	default:
+		return ErrNilNode
+	}
+	it.copyLeaf(leaf)
+	return nil
+}
+
+/*
+Positions the iterator at the first entry whose key is greater than k, or equal to k if
+inclusive, and leaves it exhausted if there is no such entry. The search starts on the
+current leaf when its page did not change since it was copied, and otherwise on the leaf
+covering k, found from the root. From there it follows the right sibling links past the
+leaves that hold no such key, pinning one leaf at a time.
+*/
+func (it *Iterator) seek(k int, inclusive bool) error {
+	it.tree.mu.RLock()
+	defer it.tree.mu.RUnlock()
+	b := it.tree.bufferManager
+	leaf, err := it.currentLeaf()
+	if err != nil {
+		return err
+	}
+	if leaf == nil {
+		node, err := it.tree.root()
+		if err != nil {
+			return err
+		}
+		switch root := node.(type) {
+		case *leafNode:
+			// a root leaf is pinned once more, so that every leaf visited can be unpinned
+			b.Pin(root.frame)
+			leaf = root
+		case *innerNode:
+			if leaf, err = root.findLeaf(k); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		pos, found := leaf.locate(k)
+		if found && !inclusive {
+			pos++
+		}
+		if pos < len(leaf.keys) {
+			it.copyLeaf(leaf)
+			it.pos = pos
+			b.Unpin(leaf.frame)
+			return nil
+		}
+		next := leaf.rightSibling
+		b.Unpin(leaf.frame)
+		if next == memory.InvalidPageId {
+			it.keys, it.recordIds, it.pos = nil, nil, -1
+			return nil
+		}
+		node, err := fetchNodeByPage(b, it.tree.metadata, next)
+		if err != nil {
+			return err
+		}
+		if leaf, _ = node.(*leafNode); leaf == nil {
+			b.Unpin(node.getFrame())
+			return ErrCorruptTree
+		}
+	}
+}
+
+/*
+Returns the pinned leaf the iterator is positioned on if its page did not change since it
+was copied, and nil if it changed, eg. by an insert, or was freed by a merge. A freed page
+that is allocated again starts over with low versions, so the leaf must also still end with
+the last key the iterator copied.
+*/
+func (it *Iterator) currentLeaf() (*leafNode, error) {
+	b := it.tree.bufferManager
+	if it.pagesVisited == 0 || len(it.keys) == 0 || !b.IsAllocated(it.leafPageId) {
+		return nil, nil
+	}
+	f, err := b.GetPage(it.leafPageId)
+	if err != nil {
+		return nil, err
+	}
+	if getPageType(f) != 1 || pageVersion(f) != it.version {
+		b.Unpin(f)
+		return nil, nil
+	}
+	leaf, err := createLeafNodeFromPage(b, it.tree.metadata, f)
+	if err != nil {
+		b.Unpin(f)
+		return nil, err
+	}
+	if last, ok := lastKey(leaf); !ok || last != it.keys[len(it.keys)-1] {
+		b.Unpin(f)
+		return nil, nil
+	}
+	return leaf, nil
+}
+
+// Copies the entries of leaf, and counts it as visited if the iterator moved onto it.
+func (it *Iterator) copyLeaf(leaf *leafNode) {
+	if it.pagesVisited == 0 || it.leafPageId != leaf.getPageId() {
+		it.pagesVisited++
+	}
+	it.leafPageId = leaf.getPageId()
+	it.version = pageVersion(leaf.frame)
+	it.keys = slices.Clone(leaf.keys)
+	it.recordIds = slices.Clone(leaf.recordIds)
+}
@@ -0,0 +1,190 @@
+package index
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func Test_prevFromLast(t *testing.T) {
+	tree := newTestTree(t, 64)
+	for _, k := range rand.New(rand.NewSource(18)).Perm(50) {
+		tree.Insert(k+1, (k+1)*10)
+	}
+
+	it, err := tree.SeekLast()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the latest 10 entries in descending order
+	for k := 50; k > 40; k-- {
+		assertEqual(t, true, it.Valid(), "")
+		assertEqual(t, k, it.Key(), "")
+		assertEqual(t, k*10, it.Value(), "")
+		it.Prev()
+	}
+
+	// walking on to the first key terminates cleanly on the leftmost leaf
+	n := 10
+	for ; it.Valid(); it.Prev() {
+		assertEqual(t, 50-n, it.Key(), "")
+		n++
+	}
+	assertEqual(t, 50, n, "")
+	assertEqual(t, false, it.Prev(), "")
+	assertEqual(t, nil, it.Err(), "")
+	assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "the iterator does not keep pages pinned")
+}
+
+func Test_prevOnEmptyTree(t *testing.T) {
+	tree := newTestTree(t, 4)
+	it, err := tree.SeekLast()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, false, it.Valid(), "")
+	assertEqual(t, false, it.Prev(), "")
+}
+
+func Test_pagesVisited(t *testing.T) {
+	tree := newTestTree(t, 16)
+	for _, k := range rand.New(rand.NewSource(53)).Perm(100) {
+		tree.Insert(k, k)
+	}
+
+	// walk down to key 60, and count the leaves holding the keys visited
+	it, err := tree.SeekLast()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, it.PagesVisited(), "")
+	leaves := make(map[int]bool)
+	for ; it.Valid() && it.Key() >= 60; it.Prev() {
+		_, leaf, _ := tree.GetWithLeaf(it.Key())
+		leaves[leaf] = true
+	}
+	assertEqual(t, true, len(leaves) > 1, "the range spans several leaves")
+	// the iterator stands on the leaf holding key 59, which may be a leaf of its own
+	_, leaf, _ := tree.GetWithLeaf(59)
+	leaves[leaf] = true
+	assertEqual(t, len(leaves), it.PagesVisited(), "")
+
+	// a full scan visits every leaf once, stepping off the leftmost leaf is not a visit
+	for it.Prev() {
+	}
+	_, _, leafPages, _, _ := tree.SizeInfo()
+	assertEqual(t, leafPages, it.PagesVisited(), "")
+}
+
+func Test_iteratorDoesNotBlockWriters(t *testing.T) {
+	tree := newTestTree(t, 64, WithOrder(MinOrder))
+	for k := 0; k < 400; k += 2 {
+		tree.Insert(k, k)
+	}
+
+	// a writer inserts the odd keys, splitting the leaves the iterator walks, a few keys
+	// at a time while the iterator pauses between steps
+	step := make(chan struct{})
+	wrote := make(chan struct{})
+	go func() {
+		k := 399
+		for range step {
+			for n := 0; n < 5 && k > 0; n, k = n+1, k-2 {
+				tree.Insert(k, k)
+			}
+			wrote <- struct{}{}
+		}
+	}()
+
+	it, err := tree.SeekLast()
+	if err != nil {
+		t.Fatal(err)
+	}
+	visited := make([]int, 0)
+	for ; it.Valid(); it.Prev() {
+		if it.Key()%2 == 0 {
+			visited = append(visited, it.Key())
+		}
+		// the writer completes its inserts while the iterator is open
+		step <- struct{}{}
+		<-wrote
+	}
+	close(step)
+	assertEqual(t, nil, it.Err(), "")
+
+	// every key that existed when the iteration started is visited once, in order
+	assertEqual(t, 200, len(visited), "")
+	for i, k := range visited {
+		assertEqual(t, 398-2*i, k, "")
+	}
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
+
+func Test_scan(t *testing.T) {
+	tree := newTestTree(t, 16)
+	for _, k := range rand.New(rand.NewSource(5)).Perm(40) {
+		tree.Insert(2*k, k) // the even keys 0 to 78
+	}
+	scan := func(lo, hi int) ([]int, *Iterator) {
+		t.Helper()
+		it, err := tree.Scan(lo, hi)
+		assertEqual(t, nil, err, "")
+		var keys []int
+		for ; it.Valid(); it.Next() {
+			assertEqual(t, it.Key()/2, it.Value(), "")
+			keys = append(keys, it.Key())
+			assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "only the root stays pinned between calls")
+		}
+		assertEqual(t, nil, it.Err(), "")
+		assertEqual(t, false, it.Next(), "")
+		return keys, it
+	}
+
+	// the range starts at a missing key and stops before hi, across several leaves
+	keys, it := scan(9, 60)
+	want := []int{}
+	for k := 10; k < 60; k += 2 {
+		want = append(want, k)
+	}
+	assertEqual(t, true, slices.Equal(want, keys), fmt.Sprint(keys))
+	assertEqual(t, true, it.PagesVisited() >= 3, fmt.Sprint(it.PagesVisited()))
+	keys, _ = scan(math.MinInt, math.MaxInt)
+	assertEqual(t, 40, len(keys), "")
+	keys, _ = scan(70, 71)
+	assertEqual(t, true, slices.Equal([]int{70}, keys), fmt.Sprint(keys))
+	keys, _ = scan(71, 72)
+	assertEqual(t, 0, len(keys), "")
+	keys, _ = scan(100, 200)
+	assertEqual(t, 0, len(keys), "")
+
+	// an iterator whose leaf is split or merged underneath it finds the next leaf from the
+	// root; the entries changed in the leaf it copied may or may not be seen
+	it, err := tree.Scan(0, 1000)
+	assertEqual(t, nil, err, "")
+	keys = nil
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+		switch it.Key() {
+		case 20:
+			for k := 21; k < 30; k += 2 {
+				tree.Insert(k, k/2)
+			}
+		case 40:
+			for k := 42; k < 60; k += 2 {
+				tree.Remove(k)
+			}
+		}
+	}
+	assertEqual(t, nil, it.Err(), "")
+	assertEqual(t, true, slices.IsSorted(keys) && len(slices.Compact(slices.Clone(keys))) == len(keys), fmt.Sprint(keys))
+	for k := 0; k < 80; k += 2 {
+		if k <= 40 || k >= 60 {
+			assertEqual(t, true, slices.Contains(keys, k), fmt.Sprintf("key %d is visited", k))
+		}
+	}
+	assertEqual(t, true, slices.Contains(keys, 29), "a key inserted past the copied leaf is visited")
+	assertEqual(t, false, slices.Contains(keys, 58), "a key removed past the copied leaf is not visited")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
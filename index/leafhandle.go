@@ -0,0 +1,109 @@
+package index
+
+import (
+	"fmt"
+)
+
+/*
+A LeafHandle keeps the leaf of a key pinned for a sequence of operations on the key, eg. a
+read-modify-write, so that they do not descend the tree again. The handle holds the tree
+latch for writing until it is unlocked, so the leaf cannot change underneath it, and every
+other operation on the tree waits for Unlock.
+*/
+type LeafHandle struct {
+	tree     *bPlusTree
+	key      int
+	leaf     *leafNode // the pinned leaf that holds (or would hold) key, nil once unlocked
+	descents int       // the number of descents from the root to the leaf
+}
+
+/*
+Pins the leaf that holds k, or in which k would be inserted, and returns a handle to operate
+on k through the pinned leaf. The handle must be unlocked with Unlock.
+*/
+func (t *bPlusTree) LockLeaf(k int) (*LeafHandle, error) {
+	t.mu.Lock()
+	h := &LeafHandle{tree: t, key: k}
+	if err := h.descend(); err != nil {
+		t.mu.Unlock()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Finds and pins the leaf of the key. A root leaf is pinned once more, so that the handle
+// can always unpin its leaf.
+func (h *LeafHandle) descend() error {
+	h.descents++
+	root, err := h.tree.root()
+	if err != nil {
+		return err
+	}
+	switch n := root.(type) {
+	case *leafNode:
+		h.tree.bufferManager.Pin(n.frame)
+		h.leaf = n
+	case *innerNode:
+		leaf, err := n.findLeaf(h.key)
+		if err != nil {
+			return err
+		}
+		h.leaf = leaf
+	}
+	return nil
+}
+
+// Returns the record id of the key, and whether the key exists.
+func (h *LeafHandle) Get() (int, bool) {
+	if h.leaf == nil {
+		return 0, false
+	}
+	return h.leaf.get(h.key)
+}
+
+/*
+Sets the record id of the key: an existing key is overwritten in the pinned leaf, and a new
+key is inserted into it. When inserting the key would split the leaf, the insert takes the
+regular path of Insert instead, after which the handle pins the (new) leaf of the key.
+*/
+func (h *LeafHandle) Update(rid int) error {
+	if h.leaf == nil {
+		return ErrTreeClosed
+	}
+	m := h.tree.metadata
+	if !m.recordIdFits(rid) {
+		return fmt.Errorf("%w: %d does not fit in %d bytes", ErrRecordIdOutOfRange, rid, m.recordIdSize)
+	}
+	l := h.leaf
+	if pos, found := l.locate(h.key); found {
+		l.overwrite(pos, rid)
+		if m.inlineSize > 0 {
+			l.inline[pos] = nil
+		}
+		if err := l.toBytes(); err != nil {
+			return err
+		}
+		h.tree.bufferManager.MarkDirty(l.frame)
+		return nil
+	}
+	if l.getMaxSize()-l.getSize() >= 1 {
+		l.insert(h.key, LeafValue{RecordId: rid})
+		return nil
+	}
+	// the insert splits the leaf, so the pinned leaf is released and found again afterwards
+	h.tree.bufferManager.Unpin(l.frame)
+	h.leaf = nil
+	if _, err := h.tree.insert(h.key, LeafValue{RecordId: rid}); err != nil {
+		return err
+	}
+	return h.descend()
+}
+
+// Unpins the leaf and releases the tree latch. The handle cannot be used afterwards.
+func (h *LeafHandle) Unlock() {
+	if h.leaf != nil {
+		h.tree.bufferManager.Unpin(h.leaf.frame)
+		h.leaf = nil
+	}
+	h.tree.mu.Unlock()
+}
@@ -0,0 +1,52 @@
+package index
+
+import (
+	"testing"
+)
+
+func Test_leafHandle(t *testing.T) {
+	tree := newTestTree(t, 16)
+	for k := range 50 {
+		tree.Insert(k*2, k)
+	}
+
+	h, err := tree.LockLeaf(20)
+	assertEqual(t, nil, err, "")
+	stats := tree.bufferManager.Stats()
+	for i := range 5 {
+		v, ok := h.Get()
+		assertEqual(t, true, ok, "")
+		assertEqual(t, nil, h.Update(v+100), "")
+		v, _ = h.Get()
+		assertEqual(t, 10+(i+1)*100, v, "")
+	}
+	assertEqual(t, 1, h.descents, "every update went to the pinned leaf")
+	assertEqual(t, stats, tree.bufferManager.Stats(), "no page was fetched")
+	h.Unlock()
+	v, _ := tree.Get(20)
+	assertEqual(t, 510, v, "")
+
+	// inserting new keys into the pinned leaf until it splits falls back to the regular insert
+	h, err = tree.LockLeaf(21)
+	assertEqual(t, nil, err, "")
+	_, ok := h.Get()
+	assertEqual(t, false, ok, "")
+	assertEqual(t, nil, h.Update(7), "")
+	v, ok = h.Get()
+	assertEqual(t, true, ok, "")
+	assertEqual(t, 7, v, "")
+	h.Unlock()
+	for k := 23; k < 40; k += 2 {
+		h, err = tree.LockLeaf(k)
+		assertEqual(t, nil, err, "")
+		assertEqual(t, nil, h.Update(k), "")
+		v, ok = h.Get()
+		assertEqual(t, true, ok, "")
+		assertEqual(t, k, v, "")
+		h.Unlock()
+	}
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	keys, _ := tree.ToSlice()
+	assertEqual(t, 60, len(keys), "")
+	assertEqual(t, 1, tree.bufferManager.PinnedPageCount(), "only the root stays pinned")
+}
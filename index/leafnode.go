@@ -4,7 +4,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"log"
+	"math"
 	"slices"
+	"sync/atomic"
 	"wtfDB/io"
 	"wtfDB/memory"
 )
@@ -24,9 +26,11 @@ The layout of a leaf page is as follows:
 	1. page type (leaf or internal), literal value 1 indicates that this node is a leaf node (4 bytes)
 	2. current size, the number of key/pointer pairs the leaf node contains (4 bytes)
 	3. max size, the max number of key/pointer pairs (4 bytes)
-	4. the page id of the right sibling (or -1 if node doesn't have a right sibling) (4 bytes)
-	5. list of keys
-	6. list of record ids
+	4. the page id of the right sibling (or -1 if node doesn't have a right sibling) (8 bytes)
+	5. the version of the page, bumped on every write of the node (4 bytes)
+	6. list of keys
+	7. list of record ids
+	8. list of insert sequence numbers, only when the tree stores them
 
 --------------(Leaf page structure/layout copied from the CMU db impl)------------------------
 * Leaf page format (keys are stored in order) (structure copied from the CMU db impl):
@@ -40,20 +44,20 @@ The layout of a leaf page is as follows:
  * | RID(1) | RID(2) | ... | RID(n) |
  *  ---------------------------------
  *
- *  Header format (size in byte, 16 bytes in total):
+ *  Header format (size in byte, 24 bytes in total):
  *  -----------------------------------------------
  * | PageType (4) | CurrentSize (4) | MaxSize (4) |
  *  -----------------------------------------------
- *  -----------------
- * | NextPageId (4) |
- *  -----------------
+ *  -------------------------------
+ * | NextPageId (8) | Version (4) |
+ *  -------------------------------
  -----------------------------------------------------------------------------------------------
 */
 
 // All sizes are in bytes
 const (
-	LeafPageHeaderSize = 16
-	LeafPageSlotCount  = io.PageSize - LeafPageHeaderSize/(KeySize+ValueTypeSize)
+	LeafPageHeaderSize = 24
+	LeafPageSlotCount  = (io.PageSize - LeafPageHeaderSize) / (KeySize + ValueTypeSize)
 )
 
 var ErrBufferFrameTooSmall = fmt.Errorf("buffer frame size cannot be less leaf page header size")
@@ -65,13 +69,18 @@ type leafNode struct {
 	bufferManager *memory.BufferPoolManager
 	keys          []int
 	recordIds     []int         // TODO: update to RecordId type
+	sequences     []int         // insert sequence numbers, parallel to keys, when the tree stores them
+	inline        [][]byte      // inline values, parallel to keys and nil for a record id, when the tree stores them
 	rightSibling  int           // page number of the leaf's right sibling
+	version       uint32        // the version of the page, see pageVersion
 	frame         *memory.Frame // page on which this node is serialized on
 }
 
 /*
 Returns a pointer to a new leaf node which is associated with a newly created
 page within the buffer frame. The page is pinned.
+The empty leaf is serialized onto the page right away, so that the page is
+recognized as a leaf page even before anything is inserted into it.
 */
 func newLeafNode(m *memory.BufferPoolManager, metadata *BPlusTreeMetadata) *leafNode {
 	f, err := m.GetNewPageFrame()
@@ -79,18 +88,23 @@ func newLeafNode(m *memory.BufferPoolManager, metadata *BPlusTreeMetadata) *leaf
 		log.Printf("unable to get a new page frame: %+v", err)
 		return nil
 	}
-	return &leafNode{
+	leaf := &leafNode{
 		treeMetadata:  metadata,
 		bufferManager: m,
 		keys:          make([]int, 0),
 		recordIds:     make([]int, 0),
+		sequences:     make([]int, 0),
+		inline:        make([][]byte, 0),
 		rightSibling:  memory.InvalidPageId,
 		frame:         f,
 	}
+	leaf.toBytes()
+	m.MarkDirty(f)
+	return leaf
 }
 
 // Constructs a leafNode object using the page's data.
-func createLeafNodeFromPage(b *memory.BufferPoolManager, m *BPlusTreeMetadata, f *memory.Frame) *leafNode {
+func createLeafNodeFromPage(b *memory.BufferPoolManager, m *BPlusTreeMetadata, f *memory.Frame) (*leafNode, error) {
 	leaf := &leafNode{
 		bufferManager: b,
 		treeMetadata: m,
@@ -99,8 +113,10 @@ func createLeafNodeFromPage(b *memory.BufferPoolManager, m *BPlusTreeMetadata, f
 		rightSibling: memory.InvalidPageId,
 		frame:        f,
 	}
-	_, _ = leaf.fromBytes(f.Data) // modifies new inner node
-	return leaf
+	if _, err := leaf.fromBytes(f.Data); err != nil { // modifies new leaf node
+		return nil, err
+	}
+	return leaf, nil
 }
 
 func (l *leafNode) isLeaf() bool {
@@ -112,21 +128,18 @@ func (l *leafNode) getSize() int {
 	return len(l.keys) + len(l.recordIds)
 }
 
-// Returns the max number of key/pointer pairs stored in the leaf
-// assuming (4k page size - 16 page header size)/ (64+64) ~~ approx. 255 keys
+// Returns the max size of the node, which is derived from the order of the tree.
+// The size counts both keys and record ids, so the node holds up to order key/record id pairs.
 func (l *leafNode) getMaxSize() int {
-	// return LeafPageSlotCount
-	return 4 * 2
+	return 2 * l.treeMetadata.order
 }
 
 func (l *leafNode) getPageId() int {
 	return l.frame.PageId
 }
 
-// Returns a pointer to the inner parent node and nil when the node does not have a parent
-// This method also removes the parent from the ancestor seen list (constructed durind downwards tree traversal)
-func (l *leafNode) getParent() *innerNode {
-	return l.treeMetadata.removeAncestor()
+func (l *leafNode) getFrame() *memory.Frame {
+	return l.frame
 }
 
 func (l *leafNode) getSeparatorKey() (int, bool) {
@@ -137,9 +150,11 @@ func (l *leafNode) getSeparatorKey() (int, bool) {
 }
 
 /*
-Inserts a key and record id into the B+ tree. This B+Tree index supports only unique keys.
+Inserts a key and its value, a record id or a value stored inline (see WithInlineValues), into
+the B+ tree. This B+Tree index supports only unique keys.
 Returns true when inserting a new key. Otherwise false, when inserting an
-existing key into the B+ tree index tree.
+existing key into the B+ tree index tree, which keeps or overwrites (see WithOverwrite)
+the value of the key without splitting the leaf.
 
 Invariant: at any given time, each leaf page is at least half full.
 
@@ -147,25 +162,39 @@ There are two cases to consider:
 1. Inserting the pair (k,r) into a leaf with space
 2. Inserting the pair (k,r) into a leaf without space which causes an overflow. This results
 in splitting n into a left and right node. The right node is the newly created right node, whose split
-key is copied into the parent inner ndoe.
+key has to be copied into the parent inner node. The split is returned to the caller, which pushes it
+up into the parent, so that the leaf never has to hold on to a pin of its parent.
+
+The leaf's frame must be pinned by the caller. The new right node is unpinned before returning.
+When no page can be allocated for the new right node, the leaf is left as is and an error
+wrapping memory.ErrBufferPoolFull is returned.
 */
-func (l *leafNode) insert(k int, rid int) bool {
+func (l *leafNode) insert(k int, v LeafValue) (bool, *nodeSplit, error) {
 	// leaf node is nil
 	if l == nil {
-		return false
+		return false, nil, nil
 	}
-	l.bufferManager.Pin(l.frame)
 
-	fmt.Printf("Leafnode: inserting k,v pair: %d, %d\n", k, rid)
+	// an existing key is found before deciding to split, so it never allocates a new page
+	if pos, found := l.locate(k); found {
+		if l.treeMetadata.overwrite {
+			l.overwrite(pos, v.RecordId)
+			if l.treeMetadata.inlineSize > 0 {
+				l.inline[pos] = v.Inline
+			}
+			l.toBytes()
+			l.bufferManager.MarkDirty(l.frame)
+		}
+		return false, nil, nil
+	}
 	// case 1. l has enough space
 	if l.getMaxSize()-l.getSize() >= 1 {
-		fmt.Println("Leafnode: leaf node is not full, inserting...")
-		l.insertSort(k, rid)
+		l.insertSort(k, v)
 		l.toBytes()
-		fmt.Printf("Leafnode: updated leafnode: %+v\n\n", l)
-		return true
+		l.bufferManager.MarkDirty(l.frame)
+		assertNode("leaf insert", l)
+		return true, nil, nil
 	}
-	fmt.Printf("Before split: buffer manager: %+v\n", *l.bufferManager)
 
 	// case 2. l is full, split leaf node into two when full
 	// split l keys into L and a new node l2
@@ -175,56 +204,142 @@ func (l *leafNode) insert(k int, rid int) bool {
 	// create a new node serialized on the new page
 	// append the new k to current list of keys
 	// copy half of the keys into the new node
-	fmt.Println("Leafnode: leaf node is full, inserting k,v pair...")
 	newL := newLeafNode(l.bufferManager, l.treeMetadata)
 	if newL == nil {
-		return false
+		return false, nil, fmt.Errorf("%w: unable to split leaf %d", memory.ErrBufferPoolFull, l.getPageId())
 	}
-	l.insertSort(k, rid)
+	defer l.bufferManager.Unpin(newL.frame)
+	l.insertSort(k, v)
 
 	// copy half of the keys/record ids into the new leaf node
 	mid := len(l.keys) / 2
-	fmt.Printf("Leaf node: split key: %d\n", mid)
-	newL.keys = l.keys[mid:]
-	newL.recordIds = l.recordIds[mid:]
+	if l.treeMetadata.appendHint && l.rightSibling == memory.InvalidPageId && l.keys[len(l.keys)-1] == k {
+		// an append to the rightmost leaf only moves the appended key, the left leaf stays full
+		mid = len(l.keys) - 1
+	}
+	l.moveEntries(mid, newL)
+	newL.rightSibling = l.rightSibling
 	newL.toBytes()
-	newL.frame.FrameMetadata.IsDirty = true
-	fmt.Printf("Leafnode: new leafnode: %+v\n\n", newL)
-	fmt.Printf("Leafnode: new leafnode frame: %+v\n\n", *newL.frame)
+	l.bufferManager.MarkDirty(newL.frame)
 
 	// update current l node to keep half the existing keys and record ids
-	l.keys = l.keys[:mid]
-	l.recordIds = l.recordIds[:mid]
 	l.rightSibling = newL.frame.PageId
 	l.toBytes()
-	l.frame.FrameMetadata.IsDirty = true
-	fmt.Printf("Leafnode: existing leafnode: %+v\n\n", l)
-	fmt.Printf("Leafnode: existing leafnode frame: %+v\n\n", *l.frame)
-	fmt.Printf("After split: buffer manager: %+v\n", *l.bufferManager)
-
-	// copy new split key into parent and unpin parent node after update
-	l.getParent().insert(newL.keys[0], newL.frame.PageId)
-	l.bufferManager.Unpin(l.getParent().frame)
-	return true
+	l.bufferManager.MarkDirty(l.frame)
+
+	assertSplit("leaf split", l, newL, newL.keys[0])
+	// the split key is copied into the parent by the caller
+	return true, &nodeSplit{key: newL.keys[0], pageId: newL.frame.PageId}, nil
+}
+
+// Overwrites the record id at pos. An overwritten entry takes the next insert sequence
+// number, so that it is visited as changed by ScanSince.
+func (l *leafNode) overwrite(pos int, rid int) {
+	l.recordIds[pos] = rid
+	if l.treeMetadata.insertSequence {
+		l.sequences[pos] = l.treeMetadata.nextSequence
+		l.treeMetadata.nextSequence++
+	}
 }
 
-func (l *leafNode) insertSort(k int, rid int) {
-	pos, found := slices.BinarySearch(l.keys, k) // keys are sorted in ascending order
+/*
+Returns the position of key k in the leaf and true if k exists, or else the position at
+which k would be inserted to keep the keys sorted, and false. The position of a missing key
+is the number of keys that sort before it: 0 before the first key, len(keys) after the last.
+Every lookup of a key within a leaf goes through locate, so they all agree on the order of
+the tree's comparator.
+*/
+func (l *leafNode) locate(k int) (int, bool) {
+	return l.treeMetadata.searchKeys(l.keys, k)
+}
+
+func (l *leafNode) insertSort(k int, v LeafValue) {
+	pos, found := l.locate(k)
 	if found {
-		// overwrite record id
+		// existing keys are handled by insert
 		return
 	}
 	l.keys = slices.Insert(l.keys, pos, k)
-	l.recordIds = slices.Insert(l.recordIds, pos, rid)
+	l.recordIds = slices.Insert(l.recordIds, pos, v.RecordId)
+	if l.treeMetadata.insertSequence {
+		l.sequences = slices.Insert(l.sequences, pos, l.treeMetadata.nextSequence)
+		l.treeMetadata.nextSequence++
+	}
+	if l.treeMetadata.inlineSize > 0 {
+		l.inline = slices.Insert(l.inline, pos, v.Inline)
+	}
+}
+
+// Moves the entries of l from position mid on into r, replacing the entries of r.
+func (l *leafNode) moveEntries(mid int, r *leafNode) {
+	r.keys = slices.Clone(l.keys[mid:])
+	r.recordIds = slices.Clone(l.recordIds[mid:])
+	l.keys = slices.Clip(l.keys[:mid])
+	l.recordIds = slices.Clip(l.recordIds[:mid])
+	if l.treeMetadata.insertSequence {
+		r.sequences = slices.Clone(l.sequences[mid:])
+		l.sequences = slices.Clip(l.sequences[:mid])
+	}
+	if l.treeMetadata.inlineSize > 0 {
+		r.inline = slices.Clone(l.inline[mid:])
+		l.inline = slices.Clip(l.inline[:mid])
+	}
+}
+
+// Reports whether the leaf is less than half full, which a leaf other than the root must not be.
+func (l *leafNode) underflows() bool {
+	return l.getSize() < l.getMaxSize()/2
+}
+
+// Copies every entry of its right sibling r into l, and links l to the right sibling of r.
+// The page of r is then no longer reachable and is freed by the caller, r is left as is.
+func (l *leafNode) merge(r *leafNode) {
+	l.keys = append(l.keys, r.keys...)
+	l.recordIds = append(l.recordIds, r.recordIds...)
+	if l.treeMetadata.insertSequence {
+		l.sequences = append(l.sequences, r.sequences...)
+	}
+	if l.treeMetadata.inlineSize > 0 {
+		l.inline = append(l.inline, r.inline...)
+	}
+	l.rightSibling = r.rightSibling
+}
+
+// Redistributes the entries of l and its right sibling r evenly between the two leaves.
+// The first key of r changes, so the caller updates the separator key of r in the parent.
+func (l *leafNode) redistribute(r *leafNode) {
+	rightSibling := l.rightSibling
+	l.merge(r)
+	l.moveEntries(len(l.keys)/2, r)
+	l.rightSibling = rightSibling
 }
 
-// Return the value associated with a given key, otherwise -1.
-// Also returns true of if the key exists in the leaf node.
-// For a leaf node, returns the record id associated with the key.
+// Removes key k and its record id from the leaf, and reports whether k existed. The page is
+// not written: the caller serializes the leaf with toBytes.
+func (l *leafNode) remove(k int) bool {
+	pos, found := l.locate(k)
+	if !found {
+		return false
+	}
+	l.keys = slices.Delete(l.keys, pos, pos+1)
+	l.recordIds = slices.Delete(l.recordIds, pos, pos+1)
+	if l.treeMetadata.insertSequence {
+		l.sequences = slices.Delete(l.sequences, pos, pos+1)
+	}
+	if l.treeMetadata.inlineSize > 0 {
+		l.inline = slices.Delete(l.inline, pos, pos+1)
+	}
+	return true
+}
+
+// Return the value associated with a given key and true if the key exists in the leaf node.
+// For a leaf node, the value is the record id associated with the key.
+// When the key does not exist, the zero value and false are returned; callers must
+// rely on the boolean, since any int (including -1) is a valid record id.
 func (l *leafNode) get(key int) (int, bool) {
-	pos, ok := slices.BinarySearch(l.keys, key)
+	pos, ok := l.locate(key)
 	if !ok {
-		return -1, false
+		return 0, false
 	}
 	// todo: decode 64-bit record id
 	v := l.recordIds[pos] // encoded as a 64-bit unsigned integer
@@ -232,8 +347,37 @@ func (l *leafNode) get(key int) (int, bool) {
 	return v, true
 }
 
+/*
+Returns the leaf that holds k, starting from l and following right sibling links while k
+sorts after every key of the leaf and not before the first key of its sibling. This finds
+a key that moved into a new sibling that is not yet linked into its parent, see
+WithMoveRight. l must be pinned; it is unpinned when the lookup moves past it, and the
+returned leaf is pinned.
+*/
+func (l *leafNode) moveRight(k int) (*leafNode, error) {
+	compare := l.treeMetadata.compare
+	for l.rightSibling != memory.InvalidPageId {
+		if len(l.keys) > 0 && compare(k, l.keys[len(l.keys)-1]) <= 0 {
+			break
+		}
+		next, err := fetchNodeByPage(l.bufferManager, l.treeMetadata, l.rightSibling)
+		if err != nil {
+			l.bufferManager.Unpin(l.frame)
+			return nil, err
+		}
+		sibling, ok := next.(*leafNode)
+		if !ok || len(sibling.keys) == 0 || compare(k, sibling.keys[0]) < 0 {
+			l.bufferManager.Unpin(next.getFrame())
+			break
+		}
+		l.bufferManager.Unpin(l.frame)
+		l = sibling
+	}
+	return l, nil
+}
+
 func (l *leafNode) search(k int) (*leafNode, bool) {
-	_, ok := slices.BinarySearch(l.keys, k)
+	_, ok := l.locate(k)
 	if ok {
 		return l, true
 	}
@@ -249,9 +393,13 @@ We serialize a leaf node into a page as follows:
  1. page type (leaf or internal), literal value 1 indicates that this node is a leaf node (4 bytes)
  2. current size, the number of key/pointer pairs the leaf node contains (4 bytes)
  3. max size, the max number of key/pointer pairs (4 bytes)
- 4. the page id of the right sibling (or -1 if node doesn't have a right sibling) (4 bytes)
+ 4. the page id of the right sibling (or -1 if node doesn't have a right sibling) (8 bytes)
  5. list of keys
- 6. list of record ids
+ 6. list of record ids, either as fixed-size 4 or 8 byte values (see WithRecordIdSize) or, when
+    the tree is configured with varint record ids, as a sequence of variable-length signed varints
+ 7. list of insert sequence numbers as fixed-size 8 byte values, when the tree stores them
+
+The rest of the page is zeroed, so no bytes of a previously larger node remain on the page.
 */
 func (l *leafNode) toBytes() error {
 	if l == nil {
@@ -264,24 +412,65 @@ func (l *leafNode) toBytes() error {
 	if len(l.keys) != len(l.recordIds) {
 		return fmt.Errorf("number of keys and record ids have to be equal")
 	}
-	// clear buffer contents before write
-	l.frame.ZeroBuffer()
-
+	if l.treeMetadata.insertSequence && len(l.keys) != len(l.sequences) {
+		return fmt.Errorf("number of keys and sequence numbers have to be equal")
+	}
+	if l.treeMetadata.inlineSize > 0 && len(l.keys) != len(l.inline) {
+		return fmt.Errorf("number of keys and inline values have to be equal")
+	}
+	if size := l.encodedSize(); size > len(l.frame.Data) {
+		return fmt.Errorf("leaf node of %d bytes does not fit in the page", size)
+	}
 	binary.BigEndian.PutUint32(l.frame.Data[0:], uint32(1))
 	binary.BigEndian.PutUint32(l.frame.Data[4:], uint32(l.getSize()))
 	binary.BigEndian.PutUint32(l.frame.Data[8:], uint32(l.getMaxSize()))
-	binary.BigEndian.PutUint32(l.frame.Data[12:], uint32(l.rightSibling))
+	putPageId(l.frame.Data[12:], l.rightSibling)
+	l.version = bumpVersion(l.frame.Data[leafVersionOffset:])
 
 	for i := range l.keys {
 		binary.BigEndian.PutUint64(l.frame.Data[LeafPageHeaderSize+(KeySize*i):], uint64(l.keys[i])) // todo: dynamically set key size based on key type
 	}
-	ridOffset := LeafPageHeaderSize + len(l.keys)*KeySize
+	offset := LeafPageHeaderSize + len(l.keys)*KeySize
 	for i := range l.recordIds {
-		binary.BigEndian.PutUint64(l.frame.Data[ridOffset+(ValueTypeSize*i):], uint64(l.recordIds[i]))
+		if l.treeMetadata.inlineSize > 0 {
+			offset += l.treeMetadata.putTaggedValue(l.frame.Data[offset:], l.recordIds[i], l.inline[i])
+			continue
+		}
+		offset += l.treeMetadata.putRecordId(l.frame.Data[offset:], l.recordIds[i])
+	}
+	if l.treeMetadata.insertSequence {
+		for i := range l.sequences {
+			binary.BigEndian.PutUint64(l.frame.Data[offset:], uint64(l.sequences[i]))
+			offset += SequenceSize
+		}
 	}
+	// only the bytes past the node are cleared, the used region has just been overwritten
+	l.frame.ZeroFrom(offset)
 	return nil
 }
 
+// Returns the number of bytes the serialized leaf node occupies on its page.
+func (l *leafNode) encodedSize() int {
+	size := LeafPageHeaderSize + len(l.keys)*KeySize
+	if l.treeMetadata.insertSequence {
+		size += len(l.sequences) * SequenceSize
+	}
+	if l.treeMetadata.inlineSize > 0 {
+		for i, rid := range l.recordIds {
+			size += l.treeMetadata.taggedValueLen(rid, l.inline[i])
+		}
+		return size
+	}
+	if l.treeMetadata.varintRecordIds {
+		var buf [binary.MaxVarintLen64]byte
+		for _, rid := range l.recordIds {
+			size += binary.PutVarint(buf[:], int64(rid))
+		}
+		return size
+	}
+	return size + len(l.recordIds)*l.treeMetadata.recordIdSize
+}
+
 /*
 Deserialize leaf page (in bytes) into a leaf node structure.
 This method translates a leaf page encoded as a byte sequence into a
@@ -297,25 +486,133 @@ func (l *leafNode) fromBytes(data []byte) (BPlusTreeNode, error) {
 		return nil, fmt.Errorf("internal error -- not a leaf page")
 	}
 
+	currentSize := binary.BigEndian.Uint32(data[4:8])
+	minValueSize := l.treeMetadata.recordIdSize
+	if l.treeMetadata.varintRecordIds {
+		minValueSize = 1
+	}
+	if l.treeMetadata.inlineSize > 0 {
+		minValueSize = 2 // a tag and an empty inline value
+	}
+	if l.treeMetadata.insertSequence {
+		minValueSize += SequenceSize
+	}
+	if LeafPageHeaderSize+int(currentSize)/2*(KeySize+minValueSize) > len(data) {
+		return nil, fmt.Errorf("leaf page size %d does not fit in the page", currentSize)
+	}
+	keys, rightSibling, err := leafKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	recordIds := []int{}
+	offset := LeafPageHeaderSize + len(keys)*KeySize
+	var inline [][]byte
+	if l.treeMetadata.inlineSize > 0 {
+		inline = [][]byte{}
+	}
+	for range int(currentSize) / 2 {
+		if l.treeMetadata.inlineSize > 0 {
+			r, value, n, err := l.treeMetadata.getTaggedValue(data[offset:])
+			if err != nil {
+				return nil, fmt.Errorf("value at offset %d: %w", offset, err)
+			}
+			recordIds = append(recordIds, r)
+			inline = append(inline, value)
+			offset += n
+			continue
+		}
+		r, n, err := l.treeMetadata.getRecordId(data[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("record id at offset %d: %w", offset, err)
+		}
+		recordIds = append(recordIds, r)
+		offset += n
+	}
+	sequences := []int{}
+	if l.treeMetadata.insertSequence {
+		if offset+len(keys)*SequenceSize > len(data) {
+			return nil, fmt.Errorf("leaf page sequence numbers do not fit in the page")
+		}
+		for range keys {
+			sequences = append(sequences, int(binary.BigEndian.Uint64(data[offset:offset+SequenceSize])))
+			offset += SequenceSize
+		}
+	}
+	decodedValues.Add(int64(len(recordIds)))
+	l.keys = keys
+	l.recordIds = recordIds
+	l.sequences = sequences
+	l.inline = inline
+	l.rightSibling = rightSibling
+	l.version = binary.BigEndian.Uint32(data[leafVersionOffset:])
+	return l, nil
+}
+
+/*
+Deserializes only the keys and the right sibling of a leaf page, without decoding any of
+its values. Returns an error if the page is not a leaf page or its keys do not fit in the page.
+*/
+func leafKeys(data []byte) ([]int, int, error) {
+	if len(data) < LeafPageHeaderSize || binary.BigEndian.Uint32(data[0:4]) != 1 {
+		return nil, memory.InvalidPageId, fmt.Errorf("internal error -- not a leaf page")
+	}
 	currentSize := binary.BigEndian.Uint32(data[4:8])
 	// maxSize := binary.BigEndian.Uint32(data[8:12])
-	UrightSibling := binary.BigEndian.Uint32(data[12:16])
+	rightSibling := getPageId(data[12:leafVersionOffset])
 	// todo: dynamically determine key type
-	keys, recordIds := []int{}, []int{}
+	keys := []int{}
 	keyOffset, ridOffset := LeafPageHeaderSize, LeafPageHeaderSize+(int(currentSize)/2*KeySize)
+	if ridOffset > len(data) {
+		return nil, memory.InvalidPageId, fmt.Errorf("leaf page size %d does not fit in the page", currentSize)
+	}
 	for i := keyOffset; i < ridOffset; i = i + KeySize {
 		k := binary.BigEndian.Uint64(data[i : i+KeySize])
 		keys = append(keys, int(k))
 	}
+	return keys, rightSibling, nil
+}
 
-	count := 0
-	for i := ridOffset; count < int(currentSize)/2; i = i + ValueTypeSize {
-		r := binary.BigEndian.Uint64(data[i : i+ValueTypeSize])
-		recordIds = append(recordIds, int(r))
-		count++
+// The number of leaf values (record ids or inline values) decoded from leaf pages, which
+// lets tests verify that a scan does not decode values.
+var decodedValues atomic.Int64
+
+// Encodes rid at the start of b in the record id format of the tree, and returns the
+// number of bytes written. 4 byte record ids are stored as two's complement int32s.
+func (m *BPlusTreeMetadata) putRecordId(b []byte, rid int) int {
+	switch {
+	case m.varintRecordIds:
+		return binary.PutVarint(b, int64(rid))
+	case m.recordIdSize == 4:
+		binary.BigEndian.PutUint32(b, uint32(int32(rid)))
+	default:
+		binary.BigEndian.PutUint64(b, uint64(rid))
 	}
-	l.keys = keys
-	l.recordIds = recordIds
-	l.rightSibling = int(int32(UrightSibling))
-	return l, nil
+	return m.recordIdSize
+}
+
+// Decodes a record id from the start of b in the record id format of the tree, and
+// returns it with the number of bytes read.
+func (m *BPlusTreeMetadata) getRecordId(b []byte) (int, int, error) {
+	switch {
+	case m.varintRecordIds:
+		r, n := binary.Varint(b)
+		if n <= 0 {
+			return 0, 0, fmt.Errorf("invalid varint record id")
+		}
+		return int(r), n, nil
+	case len(b) < m.recordIdSize:
+		return 0, 0, fmt.Errorf("record id of %d bytes does not fit in the page", m.recordIdSize)
+	case m.recordIdSize == 4:
+		return int(int32(binary.BigEndian.Uint32(b))), 4, nil
+	default:
+		return int(binary.BigEndian.Uint64(b)), ValueTypeSize, nil
+	}
+}
+
+// Reports whether rid can be stored in the record id format of the tree.
+func (m *BPlusTreeMetadata) recordIdFits(rid int) bool {
+	if m.varintRecordIds || m.recordIdSize == ValueTypeSize {
+		return true
+	}
+	return rid >= math.MinInt32 && rid <= math.MaxInt32
 }
@@ -0,0 +1,300 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"testing"
+	"wtfDB/io"
+	"wtfDB/memory"
+)
+
+func Test_leafNodeRoundTripsWidePageIds(t *testing.T) {
+	bpm := newTestBufferPool(t, 4)
+	m := NewBPlusTreeMetadata("primary")
+	leaf := newLeafNode(bpm, m)
+	leaf.keys = []int{1, 2, 3}
+	leaf.recordIds = []int{1 << 40, -1, 1<<33 + 7}
+	leaf.rightSibling = 1<<32 + 5 // beyond the range of a 32-bit page id
+	if err := leaf.toBytes(); err != nil {
+		t.Fatalf("unable to serialize leaf: %v", err)
+	}
+
+	decoded := &leafNode{treeMetadata: m, bufferManager: bpm, frame: leaf.frame}
+	if _, err := decoded.fromBytes(leaf.frame.Data); err != nil {
+		t.Fatalf("unable to deserialize leaf: %v", err)
+	}
+	assertEqual(t, 1<<32+5, decoded.rightSibling, "right sibling page id must not be truncated")
+	for i := range leaf.recordIds {
+		assertEqual(t, leaf.keys[i], decoded.keys[i], "")
+		assertEqual(t, leaf.recordIds[i], decoded.recordIds[i], "record ids must not be truncated")
+	}
+
+	// a leaf without a right sibling keeps the invalid page id
+	leaf.rightSibling = -1
+	leaf.toBytes()
+	decoded.fromBytes(leaf.frame.Data)
+	assertEqual(t, -1, decoded.rightSibling, "")
+}
+
+func Test_leafNodeVarintRecordIds(t *testing.T) {
+	bpm := newTestBufferPool(t, 4)
+	fixed := newLeafNode(bpm, NewBPlusTreeMetadata("primary"))
+	varint := newLeafNode(bpm, NewBPlusTreeMetadata("primary", WithVarintRecordIds()))
+	for _, leaf := range []*leafNode{fixed, varint} {
+		leaf.keys = []int{10, 20, 30, 40}
+		leaf.recordIds = []int{0, 1, -1, 1 << 40}
+		if err := leaf.toBytes(); err != nil {
+			t.Fatalf("unable to serialize leaf: %v", err)
+		}
+	}
+
+	decoded := &leafNode{treeMetadata: varint.treeMetadata, bufferManager: bpm, frame: varint.frame}
+	if _, err := decoded.fromBytes(varint.frame.Data); err != nil {
+		t.Fatalf("unable to deserialize leaf: %v", err)
+	}
+	for i := range varint.keys {
+		assertEqual(t, varint.keys[i], decoded.keys[i], "")
+		assertEqual(t, varint.recordIds[i], decoded.recordIds[i], "")
+	}
+
+	// 3 one byte varints and a 6 byte varint instead of 4 * 8 bytes
+	assertEqual(t, LeafPageHeaderSize+4*KeySize+4*ValueTypeSize, fixed.encodedSize(), "")
+	assertEqual(t, LeafPageHeaderSize+4*KeySize+3+6, varint.encodedSize(), "")
+}
+
+func Test_leafNodeRecordIdSize(t *testing.T) {
+	bpm := newTestBufferPool(t, 4)
+	for _, size := range []int{4, 8} {
+		m := NewBPlusTreeMetadata("primary", WithRecordIdSize(size))
+		leaf := newLeafNode(bpm, m)
+		leaf.keys = []int{10, 20, 30, 40}
+		leaf.recordIds = []int{0, 1, -1, math.MaxInt32}
+		if err := leaf.toBytes(); err != nil {
+			t.Fatalf("unable to serialize leaf: %v", err)
+		}
+		decoded := &leafNode{treeMetadata: m, bufferManager: bpm, frame: leaf.frame}
+		if _, err := decoded.fromBytes(leaf.frame.Data); err != nil {
+			t.Fatalf("unable to deserialize leaf: %v", err)
+		}
+		assertEqual(t, true, slices.Equal(leaf.keys, decoded.keys), "")
+		assertEqual(t, true, slices.Equal(leaf.recordIds, decoded.recordIds), fmt.Sprintf("%d byte record ids", size))
+		assertEqual(t, LeafPageHeaderSize+4*KeySize+4*size, leaf.encodedSize(), "")
+	}
+
+	// a 12 byte entry instead of a 16 byte entry fits more entries on a leaf page
+	narrow := NewBPlusTreeMetadata("primary", WithRecordIdSize(4))
+	wide := NewBPlusTreeMetadata("primary")
+	assertEqual(t, (io.PageSize-LeafPageHeaderSize)/12, narrow.leafSlotCount(), "")
+	assertEqual(t, (io.PageSize-LeafPageHeaderSize)/16, wide.leafSlotCount(), "")
+
+	_, err := NewBPlusTree("primary", newTestBufferPool(t, 4), NewBPlusTreeMetadata("primary", WithRecordIdSize(2)))
+	assertEqual(t, true, errors.Is(err, ErrInvalidRecordIdSize), errMessage(err))
+
+	tree := newTestTree(t, 8, WithRecordIdSize(4))
+	_, _, err = tree.InsertWithInfo(1, math.MaxInt32+1)
+	assertEqual(t, true, errors.Is(err, ErrRecordIdOutOfRange), errMessage(err))
+	inserted, _, err := tree.InsertWithInfo(1, math.MinInt32)
+	assertEqual(t, true, inserted, errMessage(err))
+}
+
+func Test_leafNodeRoundTripProperty(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	bpm := newTestBufferPool(t, 4)
+	for _, m := range []*BPlusTreeMetadata{
+		NewBPlusTreeMetadata("primary"),
+		NewBPlusTreeMetadata("primary", WithVarintRecordIds()),
+	} {
+		leaf := newLeafNode(bpm, m)
+		for range 1000 {
+			n := rng.Intn(leaf.getMaxSize()/2 + 1)
+			leaf.keys = randomSortedKeys(rng, n)
+			leaf.recordIds = make([]int, n)
+			for i := range leaf.recordIds {
+				leaf.recordIds[i] = int(rng.Int63()) - rng.Intn(2)*math.MaxInt
+			}
+			leaf.rightSibling = rng.Intn(1<<40) - 1
+			if err := leaf.toBytes(); err != nil {
+				t.Fatalf("unable to serialize leaf %+v: %v", leaf, err)
+			}
+
+			decoded := &leafNode{treeMetadata: m, bufferManager: bpm, frame: leaf.frame}
+			if _, err := decoded.fromBytes(leaf.frame.Data); err != nil {
+				t.Fatalf("unable to deserialize leaf %+v: %v", leaf, err)
+			}
+			if !slices.Equal(leaf.keys, decoded.keys) || !slices.Equal(leaf.recordIds, decoded.recordIds) ||
+				leaf.rightSibling != decoded.rightSibling {
+				t.Fatalf("leaf did not round-trip: %+v became %+v", leaf, decoded)
+			}
+		}
+	}
+}
+
+func Test_toBytesLeavesNoStaleBytes(t *testing.T) {
+	bpm := newTestBufferPool(t, 4)
+	m := NewBPlusTreeMetadata("primary")
+	leaf := newLeafNode(bpm, m)
+	leaf.keys = []int{1, 2, 3, 4, 5, 6}
+	leaf.recordIds = []int{-1, -2, -3, -4, -5, -6}
+	leaf.toBytes()
+	leaf.keys, leaf.recordIds = leaf.keys[:2], leaf.recordIds[:2]
+	leaf.toBytes()
+	for i, b := range leaf.frame.Data[leaf.encodedSize():] {
+		assertEqual(t, byte(0), b, fmt.Sprintf("leaf page byte %d", leaf.encodedSize()+i))
+	}
+
+	inner := newInnerNode(bpm, m)
+	inner.keys = []int{math.MinInt, 10, 20, 30}
+	inner.children = []uint64{1, 2, 3, 4}
+	inner.toBytes()
+	inner.keys, inner.children = inner.keys[:2], inner.children[:2]
+	inner.toBytes()
+	end := InternalPageHeaderSize + 2*(KeySize+PageIdSize)
+	for i, b := range inner.frame.Data[end:] {
+		assertEqual(t, byte(0), b, fmt.Sprintf("inner page byte %d", end+i))
+	}
+}
+
+// Compares serializing a small leaf with zeroing the whole page first, as toBytes used to.
+func Benchmark_leafNodeToBytes(b *testing.B) {
+	bpm := newTestBufferPool(b, 4)
+	leaf := newLeafNode(bpm, NewBPlusTreeMetadata("primary"))
+	leaf.keys = []int{1, 2, 3}
+	leaf.recordIds = []int{1, 2, 3}
+	b.Run("zero-tail", func(b *testing.B) {
+		for range b.N {
+			leaf.toBytes()
+		}
+	})
+	b.Run("zero-page", func(b *testing.B) {
+		for range b.N {
+			leaf.frame.ZeroBuffer()
+			leaf.toBytes()
+		}
+	})
+}
+
+// Returns n distinct keys in ascending order.
+func randomSortedKeys(rng *rand.Rand, n int) []int {
+	seen := make(map[int]bool, n)
+	keys := make([]int, 0, n)
+	for len(keys) < n {
+		k := int(rng.Int63()) - math.MaxInt/2
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func Test_duplicateIntoFullLeaf(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts []Option
+		want int
+	}{
+		{name: "no-op", want: 3},
+		{name: "overwrite", opts: []Option{WithOverwrite()}, want: 42},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tree := newTestTree(t, 8, test.opts...)
+			root := tree.Root.(*leafNode)
+			for k := range tree.Order() {
+				assertEqual(t, true, tree.Insert(k, k), "")
+			}
+			assertEqual(t, root.getMaxSize(), root.getSize(), "the root leaf is full")
+
+			assertEqual(t, false, tree.Insert(3, 42), "an existing key is not inserted again")
+			v, _ := tree.Get(3)
+			assertEqual(t, test.want, v, "")
+			assertEqual(t, root.getMaxSize(), root.getSize(), "")
+
+			// the leaf was not split, so the next page id is still the one after the root
+			f, err := tree.bufferManager.GetNewPageFrame()
+			assertEqual(t, nil, err, "")
+			assertEqual(t, root.getPageId()+1, f.PageId, "no page was allocated for the duplicate")
+		})
+	}
+}
+
+func Test_leafNodeLocate(t *testing.T) {
+	l := &leafNode{treeMetadata: NewBPlusTreeMetadata("primary"), keys: []int{10, 20, 30}}
+	tests := []struct {
+		key   int
+		pos   int
+		found bool
+	}{
+		{key: 10, pos: 0, found: true},  // the first key
+		{key: 20, pos: 1, found: true},  // a middle key
+		{key: 30, pos: 2, found: true},  // the last key
+		{key: 5, pos: 0, found: false},  // before the first key
+		{key: 35, pos: 3, found: false}, // after the last key
+		{key: 25, pos: 2, found: false}, // between two keys
+	}
+	for _, tt := range tests {
+		pos, found := l.locate(tt.key)
+		assertEqual(t, tt.pos, pos, fmt.Sprintf("locate %d", tt.key))
+		assertEqual(t, tt.found, found, fmt.Sprintf("locate %d", tt.key))
+	}
+
+	// positions follow the order of the tree's comparator
+	desc := &leafNode{
+		treeMetadata: NewBPlusTreeMetadata("primary", WithComparator(func(a, b int) int { return b - a })),
+		keys:         []int{30, 20, 10},
+	}
+	pos, found := desc.locate(25)
+	assertEqual(t, 1, pos, "")
+	assertEqual(t, false, found, "")
+	pos, found = (&leafNode{treeMetadata: l.treeMetadata}).locate(1)
+	assertEqual(t, 0, pos, "an empty leaf")
+	assertEqual(t, false, found, "")
+}
+
+func Test_pageVersion(t *testing.T) {
+	dm := io.NewDiskManager(filepath.Join(t.TempDir(), "index_test"))
+	t.Cleanup(dm.(*io.DefaultDiskManager).Shutdown)
+	bpm := memory.NewBufferPoolManager(dm, 4)
+	m := NewBPlusTreeMetadata("primary")
+	leaf := newLeafNode(bpm, m)
+	inner := newRootNode(bpm, m, leaf.getPageId())
+	versions := []uint32{pageVersion(leaf.frame), pageVersion(inner.frame)}
+	for i := uint32(1); i <= 2; i++ {
+		leaf.keys, leaf.recordIds = append(leaf.keys, int(i)), append(leaf.recordIds, int(i))
+		assertEqual(t, nil, leaf.toBytes(), "")
+		inner.sInsert(int(i), uint64(i))
+		assertEqual(t, nil, inner.toBytes(), "")
+		assertEqual(t, versions[0]+i, pageVersion(leaf.frame), "every write bumps the leaf version")
+		assertEqual(t, versions[1]+i, pageVersion(inner.frame), "every write bumps the inner version")
+		assertEqual(t, pageVersion(leaf.frame), leaf.version, "")
+		assertEqual(t, pageVersion(inner.frame), inner.version, "")
+	}
+
+	// a copy of the node parsed from the same page bumps the version of the page, not its own
+	copied, err := createLeafNodeFromPage(bpm, m, leaf.frame)
+	assertEqual(t, nil, err, "")
+	assertEqual(t, nil, copied.toBytes(), "")
+	assertEqual(t, nil, leaf.toBytes(), "")
+	assertEqual(t, versions[0]+4, pageVersion(leaf.frame), "")
+
+	// the versions are written to disk with the pages
+	bpm.MarkDirty(leaf.frame)
+	bpm.MarkDirty(inner.frame)
+	bpm.Unpin(leaf.frame)
+	bpm.Unpin(inner.frame)
+	assertEqual(t, true, bpm.FlushAllPages(), "")
+	reloaded := memory.NewBufferPoolManager(dm, 4)
+	for _, want := range []struct {
+		pageId  int
+		version uint32
+	}{{leaf.getPageId(), versions[0] + 4}, {inner.getPageId(), versions[1] + 2}} {
+		node, err := fetchNodeByPage(reloaded, m, want.pageId)
+		assertEqual(t, nil, err, "")
+		assertEqual(t, want.version, pageVersion(node.getFrame()), fmt.Sprintf("page %d", want.pageId))
+		reloaded.Unpin(node.getFrame())
+	}
+}
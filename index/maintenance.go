@@ -0,0 +1,178 @@
+package index
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	ErrMaintenanceRunning         = fmt.Errorf("maintenance is already running")
+	ErrInvalidMaintenanceInterval = fmt.Errorf("maintenance interval must be positive")
+)
+
+// A Clock delivers the ticks on which background maintenance checks the tree, see MaintenanceConfig.
+type Clock interface {
+	// Tick returns a channel that delivers the time every d, and a function that stops the ticks.
+	Tick(d time.Duration) (<-chan time.Time, func())
+}
+
+// The wall clock, which ticks with a time.Ticker.
+type systemClock struct{}
+
+func (systemClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+/*
+MaintenanceConfig configures the background maintenance of a tree, see StartMaintenance.
+A threshold of zero disables the maintenance it triggers.
+*/
+type MaintenanceConfig struct {
+	Interval           time.Duration // how often the fragmentation metrics are checked
+	MaxDirtyRatio      float64       // flush the buffer pool when the fraction of dirty frames exceeds this
+	MaxUnderfullLeaves float64       // compact the tree when the fraction of underfull leaves exceeds this
+	MaxOrphans         int           // reclaim orphaned pages when there are more than this many
+
+	Clock Clock                // the clock that ticks the checks, the wall clock if nil
+	OnRun func(MaintenanceRun) // called after every check, eg. to log what was done, may be nil
+}
+
+// FragmentationMetrics describe how much a tree would gain from maintenance, see Fragmentation.
+type FragmentationMetrics struct {
+	DirtyRatio      float64 // the fraction of the frames of the buffer pool that hold a dirty page
+	UnderfullLeaves float64 // the fraction of the leaves that are less than half full
+	Orphans         int     // the number of allocated pages that cannot be reached from the root
+}
+
+// MaintenanceRun reports one check of the background maintenance and what it did.
+type MaintenanceRun struct {
+	Metrics   FragmentationMetrics
+	Compacted bool  // the tree was rebuilt with Compact
+	Reclaimed int   // the number of orphaned pages freed
+	Flushed   bool  // the dirty pages of the buffer pool were flushed
+	Err       error // the error of the check or of the maintenance, if any
+}
+
+// The background maintenance goroutine of a tree.
+type maintenance struct {
+	stop chan struct{} // closed to stop the goroutine
+	done chan struct{} // closed when the goroutine has returned
+}
+
+/*
+Returns the fragmentation metrics of the tree. Every leaf is read, and the orphaned pages are
+found as with FindOrphanPages, which walks the whole tree, so this is as expensive as a scan.
+*/
+func (t *bPlusTree) Fragmentation() (FragmentationMetrics, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var metrics FragmentationMetrics
+	metrics.DirtyRatio = float64(t.bufferManager.DirtyPageCount()) / float64(t.bufferManager.Size())
+	leaves, underfull := 0, 0
+	err := t.forEachLeafFrom(firstChild, func(pageId int, leaf *leafNode) error {
+		leaves++
+		if 2*len(leaf.keys) < t.metadata.order {
+			underfull++
+		}
+		return nil
+	})
+	if err != nil {
+		return metrics, err
+	}
+	if leaves > 1 {
+		// a root leaf is never underfull, it may hold as few entries as the tree has
+		metrics.UnderfullLeaves = float64(underfull) / float64(leaves)
+	}
+	orphans, err := t.findOrphanPages()
+	if err != nil {
+		return metrics, err
+	}
+	metrics.Orphans = len(orphans)
+	return metrics, nil
+}
+
+/*
+Starts a goroutine that checks the fragmentation metrics of the tree every cfg.Interval, and
+runs the maintenance whose threshold is crossed: Compact when too many leaves are underfull,
+ReclaimOrphans when too many pages are orphaned, and a flush of the buffer pool when too many
+frames are dirty. The metrics are read under the tree latch for reading, and every maintenance
+takes the tree latch for writing only while it runs, so other operations interleave between
+them. The maintenance runs until StopMaintenance or Close.
+
+Returns ErrMaintenanceRunning if the maintenance of the tree was started already.
+*/
+func (t *bPlusTree) StartMaintenance(cfg MaintenanceConfig) error {
+	if cfg.Interval <= 0 {
+		return ErrInvalidMaintenanceInterval
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = systemClock{}
+	}
+	t.maintenanceMu.Lock()
+	defer t.maintenanceMu.Unlock()
+	if t.maintenance != nil {
+		return ErrMaintenanceRunning
+	}
+	m := &maintenance{stop: make(chan struct{}), done: make(chan struct{})}
+	t.maintenance = m
+	ticks, stopTicks := cfg.Clock.Tick(cfg.Interval)
+	go func() {
+		defer close(m.done)
+		defer stopTicks()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticks:
+				run := t.runMaintenance(cfg)
+				if cfg.OnRun != nil {
+					cfg.OnRun(run)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stops the background maintenance of the tree, and waits for a running check to finish.
+// Does nothing if the maintenance is not running.
+func (t *bPlusTree) StopMaintenance() {
+	t.maintenanceMu.Lock()
+	defer t.maintenanceMu.Unlock()
+	if t.maintenance == nil {
+		return
+	}
+	close(t.maintenance.stop)
+	<-t.maintenance.done
+	t.maintenance = nil
+}
+
+// Checks the fragmentation metrics and runs the maintenance whose threshold is crossed.
+func (t *bPlusTree) runMaintenance(cfg MaintenanceConfig) MaintenanceRun {
+	var run MaintenanceRun
+	run.Metrics, run.Err = t.Fragmentation()
+	if run.Err != nil {
+		return run
+	}
+	if cfg.MaxUnderfullLeaves > 0 && run.Metrics.UnderfullLeaves > cfg.MaxUnderfullLeaves {
+		// the pages of the old tree are freed by the rebuild
+		if _, run.Err = t.Compact(); run.Err != nil {
+			return run
+		}
+		run.Compacted = true
+	}
+	if cfg.MaxOrphans > 0 && run.Metrics.Orphans > cfg.MaxOrphans {
+		if run.Reclaimed, run.Err = t.ReclaimOrphans(); run.Err != nil {
+			return run
+		}
+	}
+	if cfg.MaxDirtyRatio > 0 && run.Metrics.DirtyRatio > cfg.MaxDirtyRatio {
+		if !t.bufferManager.FlushAllPages() {
+			run.Err = fmt.Errorf("unable to flush the buffer pool")
+			return run
+		}
+		run.Flushed = true
+	}
+	return run
+}
@@ -0,0 +1,126 @@
+package index
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_compact(t *testing.T) {
+	tree := newTestTree(t, 64, WithOrder(4), WithInsertSequence())
+	for k := range 400 {
+		tree.Insert(k, k)
+	}
+	deleted, err := tree.DeleteWhere(func(key int, rid int) bool { return key%8 != 0 })
+	assertEqual(t, nil, err, "")
+	assertEqual(t, 350, deleted, "")
+	before, _, _, _, _ := tree.SizeInfo()
+
+	shrunk, err := tree.Compact()
+	assertEqual(t, nil, err, "")
+	after, _, leaves, _, _ := tree.SizeInfo()
+	assertEqual(t, before-after, shrunk, "")
+	assertEqual(t, 13, leaves, "50 entries are packed into full leaves")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	orphans, err := tree.FindOrphanPages()
+	assertEqual(t, nil, err, "")
+	assertEqual(t, 0, len(orphans), "the pages of the old tree are freed")
+
+	keys, rids := tree.ToSlice()
+	assertEqual(t, 50, len(keys), "")
+	for i, k := range keys {
+		assertEqual(t, i*8, k, "")
+		assertEqual(t, k, rids[i], "")
+	}
+	// insert sequence numbers are kept
+	var seqs []int
+	tree.ScanByInsertOrder(func(key int, rid int, seq int) error {
+		seqs = append(seqs, seq)
+		return nil
+	})
+	assertEqual(t, true, slices.Equal([]int{0, 8, 16}, seqs[:3]), "")
+
+	// the compacted tree takes inserts, and an empty tree compacts into a single leaf
+	tree.Insert(1, 1)
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+	tree.DeleteWhere(func(key int, rid int) bool { return true })
+	_, err = tree.Compact()
+	assertEqual(t, nil, err, "")
+	pages, _, _, _, _ := tree.SizeInfo()
+	assertEqual(t, 1, pages, "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
+
+// A clock that ticks when the test tells it to.
+type manualClock struct {
+	ticks chan time.Time
+}
+
+func (c *manualClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	return c.ticks, func() {}
+}
+
+func Test_maintenance(t *testing.T) {
+	tree := newTestTree(t, 64, WithOrder(4))
+	for k := range 400 {
+		tree.Insert(k, k)
+	}
+	clock := &manualClock{ticks: make(chan time.Time)}
+	runs := make(chan MaintenanceRun)
+	err := tree.StartMaintenance(MaintenanceConfig{
+		Interval:           time.Minute,
+		MaxUnderfullLeaves: 0.5,
+		Clock:              clock,
+		OnRun:              func(run MaintenanceRun) { runs <- run },
+	})
+	assertEqual(t, nil, err, "")
+	t.Cleanup(tree.StopMaintenance)
+	assertEqual(t, ErrMaintenanceRunning, tree.StartMaintenance(MaintenanceConfig{Interval: time.Minute}), "")
+
+	// leaves split in half are not underfull
+	clock.ticks <- time.Now()
+	run := <-runs
+	assertEqual(t, nil, run.Err, "")
+	assertEqual(t, 0.0, run.Metrics.UnderfullLeaves, "")
+	assertEqual(t, false, run.Compacted, "")
+
+	// removes rebalance the leaves, so most leaves are left underfull by dropping entries in
+	// place, like a tree written before removes rebalanced; the next check compacts the tree
+	trimLeaves(t, tree, func(key int) bool { return key%8 != 0 })
+	before, _, _, _, _ := tree.SizeInfo()
+	clock.ticks <- time.Now()
+	run = <-runs
+	assertEqual(t, nil, run.Err, "")
+	assertEqual(t, true, run.Metrics.UnderfullLeaves > 0.5, "")
+	assertEqual(t, true, run.Compacted, "")
+	after, _, _, _, _ := tree.SizeInfo()
+	assertEqual(t, true, after < before, "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+
+	clock.ticks <- time.Now()
+	run = <-runs
+	assertEqual(t, false, run.Compacted, "a compacted tree is not compacted again")
+
+	tree.StopMaintenance()
+	assertEqual(t, nil, tree.StartMaintenance(MaintenanceConfig{Interval: time.Minute, Clock: clock}), "a stopped maintenance can be started again")
+}
+
+// Drops the entries whose keys match drop from every leaf in place, without rebalancing.
+func trimLeaves(t *testing.T, tree *bPlusTree, drop func(key int) bool) {
+	err := tree.ForEachLeafPage(func(pageId int, leaf *leafNode) error {
+		keep := 0
+		for i, k := range leaf.keys {
+			if !drop(k) {
+				leaf.keys[keep], leaf.recordIds[keep] = k, leaf.recordIds[i]
+				keep++
+			}
+		}
+		leaf.keys, leaf.recordIds = leaf.keys[:keep], leaf.recordIds[:keep]
+		if err := leaf.toBytes(); err != nil {
+			return err
+		}
+		tree.bufferManager.MarkDirty(leaf.frame)
+		return nil
+	})
+	assertEqual(t, nil, err, errMessage(err))
+}
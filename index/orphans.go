@@ -0,0 +1,94 @@
+package index
+
+import (
+	"fmt"
+	"slices"
+)
+
+var ErrPageLeak = fmt.Errorf("pages are neither free nor reachable from the root")
+
+/*
+Returns the ids of the orphaned pages in ascending order: the pages that are allocated in the
+buffer pool, but that cannot be reached from the root of the tree. Pages leak this way when a
+node is allocated and then never linked into the tree, eg. after a failed split. The tree is
+walked level by level from the root, see LevelOrder.
+
+This assumes the tree is the only user of its buffer pool and database file: the pages of
+any other structure in the file are reported as orphans too, except for the header page.
+Returns an error if a page cannot be loaded, since the reachable pages are then unknown.
+*/
+func (t *bPlusTree) FindOrphanPages() ([]int, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.findOrphanPages()
+}
+
+func (t *bPlusTree) findOrphanPages() ([]int, error) {
+	reachable := map[int]bool{headerPageId: true}
+	err := t.levelOrder(func(level int, node BPlusTreeNode) {
+		reachable[node.getPageId()] = true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to walk the tree: %w", err)
+	}
+	orphans := slices.DeleteFunc(t.bufferManager.AllocatedPageIds(), func(pageId int) bool {
+		return reachable[pageId]
+	})
+	return orphans, nil
+}
+
+/*
+Frees the orphaned pages of the tree (see FindOrphanPages) by deleting them from the buffer
+pool, and returns the number of pages freed. An orphan that is pinned is skipped. Deleted pages
+at the end of the file can then be dropped with BufferPoolManager.Truncate.
+*/
+func (t *bPlusTree) ReclaimOrphans() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	orphans, err := t.findOrphanPages()
+	if err != nil {
+		return 0, err
+	}
+	freed := 0
+	for _, pageId := range orphans {
+		deleted, err := t.bufferManager.DeletePage(pageId)
+		if err != nil {
+			return freed, fmt.Errorf("unable to free orphaned page %d: %w", pageId, err)
+		}
+		if deleted {
+			freed++
+		}
+	}
+	return freed, nil
+}
+
+/*
+Reconciles the pages of the database file: the free pages of the buffer pool, the pages that
+are reachable from the root of the tree, and the total number of pages of the file (see
+BufferPoolManager.PageCounts). Every page is either free or used by the tree, so the free and
+used pages add up to the total for a healthy tree. Otherwise the counts are returned with
+ErrPageLeak: the pages that are unaccounted for leaked (see FindOrphanPages), and more pages
+than the total means a free page is still linked into the tree, which is corruption.
+
+Like FindOrphanPages, this assumes the tree is the only user of its buffer pool and file,
+and the header page counts as used.
+*/
+func (t *bPlusTree) FreeSpaceReport() (freePages int, usedPages int, totalPages int, err error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	err = t.levelOrder(func(level int, node BPlusTreeNode) {
+		usedPages++
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to walk the tree: %w", err)
+	}
+	usedPages++ // the header page
+	freePages, totalPages, err = t.bufferManager.PageCounts()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to count the pages of the file: %w", err)
+	}
+	if freePages+usedPages != totalPages {
+		err = fmt.Errorf("%w: %d free and %d used of %d pages", ErrPageLeak, freePages, usedPages, totalPages)
+	}
+	return freePages, usedPages, totalPages, err
+}
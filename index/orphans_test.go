@@ -0,0 +1,69 @@
+package index
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func Test_reclaimOrphans(t *testing.T) {
+	tree := newTestTree(t, 8)
+	for k := range 20 {
+		tree.Insert(k, k)
+	}
+	orphans, err := tree.FindOrphanPages()
+	assertEqual(t, nil, err, "")
+	assertEqual(t, 0, len(orphans), "")
+
+	// leak a node that is allocated but never linked into the tree, like a failed split
+	leaf := newLeafNode(tree.bufferManager, tree.metadata)
+	leaked := leaf.getPageId()
+	tree.bufferManager.Unpin(leaf.frame)
+	for k := 20; k < 40; k++ {
+		tree.Insert(k, k)
+	}
+	orphans, err = tree.FindOrphanPages()
+	assertEqual(t, nil, err, "")
+	assertEqual(t, true, slices.Equal([]int{leaked}, orphans), "")
+
+	freed, err := tree.ReclaimOrphans()
+	assertEqual(t, nil, err, "")
+	assertEqual(t, 1, freed, "")
+	assertEqual(t, false, slices.Contains(tree.bufferManager.AllocatedPageIds(), leaked), "")
+	orphans, _ = tree.FindOrphanPages()
+	assertEqual(t, 0, len(orphans), "")
+
+	keys, _ := tree.ToSlice()
+	assertEqual(t, 40, len(keys), "")
+	assertEqual(t, nil, tree.CheckIntegrity(), "")
+}
+
+func Test_freeSpaceReport(t *testing.T) {
+	tree := newTestTree(t, 8)
+	for k := range 40 {
+		tree.Insert(k, k)
+	}
+	// compacting the tree after deleting most keys frees the old pages
+	tree.DeleteWhere(func(key int, rid int) bool { return key < 30 })
+	_, err := tree.Compact()
+	assertEqual(t, nil, err, "")
+	free, used, total, err := tree.FreeSpaceReport()
+	assertEqual(t, nil, err, errMessage(err))
+	assertEqual(t, true, free > 0, "")
+	assertEqual(t, total, free+used, "")
+	pages, _, _, _, _ := tree.SizeInfo()
+	assertEqual(t, pages+1, used, "the pages of the tree and the header page")
+
+	// a node that is allocated but never linked into the tree is neither free nor used
+	leaf := newLeafNode(tree.bufferManager, tree.metadata)
+	tree.bufferManager.Unpin(leaf.frame)
+	free, used, total, err = tree.FreeSpaceReport()
+	assertEqual(t, true, errors.Is(err, ErrPageLeak), errMessage(err))
+	assertEqual(t, total-1, free+used, "")
+
+	_, err = tree.ReclaimOrphans()
+	assertEqual(t, nil, err, "")
+	free, used, total, err = tree.FreeSpaceReport()
+	assertEqual(t, nil, err, errMessage(err))
+	assertEqual(t, total, free+used, "")
+}
@@ -0,0 +1,18 @@
+package index
+
+/*
+Resolves a lookup through a secondary index: the secondary tree maps a secondary key to the
+primary key of the row (instead of a record id), which is then looked up in the primary tree
+to get the record id of the row. Returns false if either key does not exist, eg. when the
+secondary index still references a row that was removed from the primary index.
+
+Storing the primary key keeps the secondary index stable when a row moves and its record id
+changes, at the cost of a second descent per lookup. The trees may share a buffer pool.
+*/
+func ResolveViaPrimary(secondaryTree, primaryTree *bPlusTree, secKey int) (int, bool) {
+	primaryKey, ok := secondaryTree.Get(secKey)
+	if !ok {
+		return 0, false
+	}
+	return primaryTree.Get(primaryKey)
+}
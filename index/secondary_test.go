@@ -0,0 +1,32 @@
+package index
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_resolveViaPrimary(t *testing.T) {
+	bpm := newTestBufferPool(t, 16)
+	primary, err := NewBPlusTree("primary", bpm, NewBPlusTreeMetadata("primary"))
+	assertEqual(t, nil, err, "")
+	secondary, err := NewBPlusTree("by_email", bpm, NewBPlusTreeMetadata("by_email"))
+	assertEqual(t, nil, err, "")
+
+	// rows with primary key id, a secondary key 1000+id and the record id 10*id
+	for id := range 50 {
+		assertEqual(t, true, primary.Insert(id, 10*id), "")
+		assertEqual(t, true, secondary.Insert(1000+id, id), "")
+	}
+	for id := range 50 {
+		rid, ok := ResolveViaPrimary(secondary, primary, 1000+id)
+		assertEqual(t, true, ok, fmt.Sprintf("secondary key %d", 1000+id))
+		assertEqual(t, 10*id, rid, "")
+	}
+	_, ok := ResolveViaPrimary(secondary, primary, 2000)
+	assertEqual(t, false, ok, "the secondary key does not exist")
+
+	// a secondary entry that references a removed row does not resolve
+	assertEqual(t, true, primary.Remove(7), "")
+	_, ok = ResolveViaPrimary(secondary, primary, 1007)
+	assertEqual(t, false, ok, "the primary key does not exist")
+}
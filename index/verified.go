@@ -0,0 +1,70 @@
+package index
+
+import (
+	"fmt"
+)
+
+/*
+A VerifiedTree is a debug wrapper of a tree that keeps a shadow map of its entries, and checks
+every Get, Insert and Remove through the wrapper against the shadow map. A result that differs
+from the shadow map panics, at the operation that diverged, which catches serialization and
+navigation bugs much earlier than a failed lookup would. It is meant for tests.
+
+The tree must only be changed through the wrapper. A VerifiedTree is not safe for concurrent use.
+*/
+type VerifiedTree struct {
+	tree   *bPlusTree
+	shadow map[int]int
+}
+
+// Wraps t in a VerifiedTree, whose shadow map starts out with the entries of t.
+func NewVerifiedTree(t *bPlusTree) *VerifiedTree {
+	v := &VerifiedTree{tree: t, shadow: make(map[int]int)}
+	keys, rids := t.ToSlice()
+	for i, k := range keys {
+		v.shadow[k] = rids[i]
+	}
+	return v
+}
+
+// Returns the value of k like bPlusTree.Get, and panics if it differs from the shadow map.
+func (v *VerifiedTree) Get(k int) (int, bool) {
+	got, ok := v.tree.Get(k)
+	want, exists := v.shadow[k]
+	if ok != exists || got != want {
+		panic(fmt.Sprintf("verified tree: get %d: got %d, %t, expected %d, %t", k, got, ok, want, exists))
+	}
+	return got, ok
+}
+
+// Inserts k like bPlusTree.Insert, and panics if the insert or a Get of k afterwards differs
+// from the shadow map.
+func (v *VerifiedTree) Insert(k int, rid int) bool {
+	_, exists := v.shadow[k]
+	inserted := v.tree.Insert(k, rid)
+	if inserted == exists {
+		panic(fmt.Sprintf("verified tree: insert %d: inserted is %t, but the key exists is %t", k, inserted, exists))
+	}
+	if !exists || v.tree.metadata.overwrite {
+		v.shadow[k] = rid
+	}
+	v.Get(k)
+	return inserted
+}
+
+// Removes k, and panics if the result or a Get of k afterwards differs from the shadow map.
+// Reports whether k existed.
+func (v *VerifiedTree) Remove(k int) bool {
+	_, exists := v.shadow[k]
+	if removed := v.tree.Remove(k); removed != exists {
+		panic(fmt.Sprintf("verified tree: remove %d: removed is %t, but the key exists is %t", k, removed, exists))
+	}
+	delete(v.shadow, k)
+	v.Get(k)
+	return exists
+}
+
+// Returns the number of entries of the shadow map, which is the number of entries of the tree.
+func (v *VerifiedTree) Len() int {
+	return len(v.shadow)
+}
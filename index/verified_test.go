@@ -0,0 +1,34 @@
+package index
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func Test_verifiedTree(t *testing.T) {
+	tree := newTestTree(t, 64, WithOrder(MinOrder))
+	tree.Insert(7, 70)
+	v := NewVerifiedTree(tree)
+	assertEqual(t, 1, v.Len(), "the shadow map starts with the entries of the tree")
+
+	rng := rand.New(rand.NewSource(61))
+	for range 3000 {
+		k := rng.Intn(300)
+		switch op := rng.Intn(10); {
+		case op < 5:
+			v.Insert(k, rng.Intn(1000))
+		case op < 8:
+			v.Get(k)
+		default:
+			v.Remove(k)
+		}
+	}
+	keys, _ := tree.ToSlice()
+	assertEqual(t, v.Len(), len(keys), "")
+
+	// a change behind the back of the wrapper is caught by the next Get of the key
+	tree.DeleteWhere(func(key int, rid int) bool { return key == keys[0] })
+	msg := recoverPanic(func() { v.Get(keys[0]) })
+	assertEqual(t, true, strings.HasPrefix(msg, "verified tree: get"), msg)
+}
@@ -0,0 +1,154 @@
+package io
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// The size of one entry of the block map: the file offset, capacity and length of a block.
+const blockEntrySize = 16 // bytes
+
+var ErrorBlockMap = fmt.Errorf("error reading or writing the block map")
+
+/*
+WithCompression makes the disk manager compress pages with compress/flate on write and
+decompress them on read. Pages stay PageSize bytes in memory; only their representation
+on disk is variable in size.
+
+Compressed pages are stored as blocks in the database file. A side index, the block map,
+is kept in a file next to the database file (with a ".blocks" suffix) and maps each page id
+to the offset, capacity and compressed length of its block. A rewritten page is stored in
+place when it fits the capacity of its block, and otherwise in a new block at the end of
+the file; the old block is not reused. This suits cold, read-mostly indexes, where pages
+are rarely rewritten.
+*/
+func WithCompression() Option {
+	return func(d *DefaultDiskManager) {
+		d.compressed = true
+	}
+}
+
+// The location of a compressed page in the database file.
+type block struct {
+	offset   int64
+	capacity uint32
+	length   uint32 // 0 if the page was never written
+}
+
+// Opens the block map file of a compressed database file, and loads it into memory.
+func (d *DefaultDiskManager) openBlockMap(fileName string) error {
+	f, err := os.OpenFile(fileName+".blocks", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	d.blockFile = f
+	d.blocks = make([]block, len(data)/blockEntrySize)
+	for i := range d.blocks {
+		entry := data[i*blockEntrySize:]
+		d.blocks[i] = block{
+			offset:   int64(binary.BigEndian.Uint64(entry[0:])),
+			capacity: binary.BigEndian.Uint32(entry[8:]),
+			length:   binary.BigEndian.Uint32(entry[12:]),
+		}
+		d.fileEnd = max(d.fileEnd, d.blocks[i].offset+int64(d.blocks[i].capacity))
+	}
+	return nil
+}
+
+// Compresses data and writes it to the block of the page, then records the block in the block map.
+func (d *DefaultDiskManager) writeCompressedPage(pageId int, data []byte) error {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return ErrorWriteToDisk
+	}
+	if err := w.Close(); err != nil {
+		return ErrorWriteToDisk
+	}
+
+	for len(d.blocks) <= pageId {
+		d.blocks = append(d.blocks, block{})
+	}
+	b := d.blocks[pageId]
+	if buf.Len() > int(b.capacity) {
+		b = block{offset: d.fileEnd, capacity: uint32(buf.Len())}
+		d.fileEnd += int64(b.capacity)
+	}
+	b.length = uint32(buf.Len())
+
+	if _, err := d.dbFile.WriteAt(buf.Bytes(), b.offset); err != nil {
+		log.Printf("error writing compressed page %d at offset %d", pageId, b.offset)
+		return ErrorWriteToDisk
+	}
+	if err := d.writeBlockEntry(pageId, b); err != nil {
+		return err
+	}
+	d.blocks[pageId] = b
+	return nil
+}
+
+func (d *DefaultDiskManager) writeBlockEntry(pageId int, b block) error {
+	entry := make([]byte, blockEntrySize)
+	binary.BigEndian.PutUint64(entry[0:], uint64(b.offset))
+	binary.BigEndian.PutUint32(entry[8:], b.capacity)
+	binary.BigEndian.PutUint32(entry[12:], b.length)
+	if _, err := d.blockFile.WriteAt(entry, int64(pageId)*blockEntrySize); err != nil {
+		log.Printf("error writing block map entry of page %d", pageId)
+		return ErrorBlockMap
+	}
+	return nil
+}
+
+// Reads the block of the page and decompresses it into buf. A page that was never written reads as zeroes.
+func (d *DefaultDiskManager) readCompressedPage(pageId int, buf []byte) error {
+	clear(buf)
+	if pageId >= len(d.blocks) || d.blocks[pageId].length == 0 {
+		return nil
+	}
+	b := d.blocks[pageId]
+	data := make([]byte, b.length)
+	if _, err := d.dbFile.ReadAt(data, b.offset); err != nil {
+		log.Printf("error reading compressed page %d at offset %d", pageId, b.offset)
+		return ErrorReadFromDisk
+	}
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	// every block holds a whole page, so a block that decompresses to less is truncated or corrupt
+	if _, err := io.ReadFull(r, buf[:min(len(buf), PageSize)]); err != nil {
+		log.Printf("error decompressing page %d: %v", pageId, err)
+		return ErrorReadFromDisk
+	}
+	return nil
+}
+
+// Drops the blocks of all pages from numPages on, and shrinks the database file to the blocks that are left.
+func (d *DefaultDiskManager) truncateCompressed(numPages int) error {
+	for len(d.blocks) < numPages {
+		d.blocks = append(d.blocks, block{})
+	}
+	d.blocks = d.blocks[:numPages]
+	if err := d.blockFile.Truncate(int64(numPages) * blockEntrySize); err != nil {
+		return ErrorBlockMap
+	}
+	d.fileEnd = 0
+	for _, b := range d.blocks {
+		d.fileEnd = max(d.fileEnd, b.offset+int64(b.capacity))
+	}
+	if err := d.dbFile.Truncate(d.fileEnd); err != nil {
+		return ErrorTruncateFile
+	}
+	return nil
+}
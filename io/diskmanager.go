@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
+	"slices"
+	"unsafe"
 )
 
 const (
@@ -14,9 +17,12 @@ const (
 )
 
 var (
-	ErrorReadFromDisk = fmt.Errorf("error reading from disk")
-	ErrorWriteToDisk  = fmt.Errorf("error writing to disk")
-	ErrorFlushToDisk  = fmt.Errorf("page contents not flushed to disk")
+	ErrorReadFromDisk    = fmt.Errorf("error reading from disk")
+	ErrorWriteToDisk     = fmt.Errorf("error writing to disk")
+	ErrorFlushToDisk     = fmt.Errorf("page contents not flushed to disk")
+	ErrorTruncateFile    = fmt.Errorf("error truncating database file")
+	ErrorUnalignedBuffer = fmt.Errorf("page buffer is not aligned to the disk block size")
+	ErrorOffsetOverflow  = fmt.Errorf("page offset overflows the file offset range")
 )
 
 /*
@@ -28,58 +34,215 @@ within the context of a DBMS.
 type DiskManager interface {
 	WritePage(pageId int, data []byte) error
 	ReadPage(pageId int, buf []byte) error
+	NumPages() (int, error)
+	Truncate(numPages int) error
+
+	// Sync makes all pages written so far durable on disk.
+	Sync() error
 }
 
 type DefaultDiskManager struct {
 	dbFile     *os.File
 	writeCount int
+	alignment  int // the block size page buffers must be aligned to, 0 if unchecked
+
+	// Page compression, see WithCompression.
+	compressed bool
+	blockFile  *os.File
+	blocks     []block
+	fileEnd    int64
+
+	// Deferred syncing, see WithDeferredSync.
+	deferSync bool
+	pending   map[int][]byte // page id -> page data written since the last Sync
+}
+
+// Option configures optional behaviour of a DefaultDiskManager.
+type Option func(*DefaultDiskManager)
+
+/*
+WithAlignment makes the disk manager reject page buffers whose start address is not
+aligned to the given block size (a power of two, eg. 512 or 4096) with ErrorUnalignedBuffer.
+Direct I/O (O_DIRECT) requires aligned buffers, so this catches unaligned buffers
+before direct I/O is turned on. The buffer pool allocates aligned frames when it is
+created with a matching memory.WithBlockAlignment.
+*/
+func WithAlignment(blockSize int) Option {
+	return func(d *DefaultDiskManager) {
+		d.alignment = blockSize
+	}
+}
+
+/*
+WithDeferredSync makes WritePage stage pages in memory instead of writing and syncing every
+page to the database file. Staged pages are written out in ascending page id order, and
+synced once, by Sync; callers invoke it at safe points such as a checkpoint, eg. through
+BufferPoolManager.FlushAllPages. Pages that were not synced are lost on a crash.
+
+Reads, NumPages and Truncate see staged pages as if they were already written.
+*/
+func WithDeferredSync() Option {
+	return func(d *DefaultDiskManager) {
+		d.deferSync = true
+		d.pending = make(map[int][]byte)
+	}
 }
 
 /*
 Creates a new disk manager that writes to the specified database file.
 */
-func NewDiskManager(fileName string) DiskManager {
+func NewDiskManager(fileName string, opts ...Option) DiskManager {
 	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		log.Fatal("cannot open db file: " + err.Error())
 	}
 
-	return &DefaultDiskManager{
+	d := &DefaultDiskManager{
 		dbFile: f,
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.compressed {
+		if err := d.openBlockMap(fileName); err != nil {
+			log.Fatal("cannot open block map file: " + err.Error())
+		}
+	}
+	return d
+}
+
+// AlignedBuffer returns a zeroed buffer of the given size whose start address is
+// aligned to alignment bytes. An alignment of 1 or less returns a plain buffer.
+func AlignedBuffer(size int, alignment int) []byte {
+	if alignment <= 1 {
+		return make([]byte, size)
+	}
+	buf := make([]byte, size+alignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % uintptr(alignment)); rem != 0 {
+		offset = alignment - rem
+	}
+	return buf[offset : offset+size : offset+size]
+}
+
+// IsAligned reports whether the start address of buf is aligned to alignment bytes.
+func IsAligned(buf []byte, alignment int) bool {
+	if alignment <= 1 || len(buf) == 0 {
+		return true
+	}
+	return uintptr(unsafe.Pointer(&buf[0]))%uintptr(alignment) == 0
 }
 
 func (d *DefaultDiskManager) Shutdown() {
 	if err := d.dbFile.Close(); err != nil {
 		log.Println("failed to close database file during shutdown")
 	}
+	if d.blockFile != nil {
+		if err := d.blockFile.Close(); err != nil {
+			log.Println("failed to close block map file during shutdown")
+		}
+	}
 }
 
 // WritePage writes the page data of the specified file to the disk file.
 // It takes a page number and a slice of bytes to be written to the page.
 // Returns an error if it cannot write to the page.
+// With deferred syncing the page is only staged until the next Sync.
 func (d *DefaultDiskManager) WritePage(pageId int, data []byte) error {
+	if !IsAligned(data, d.alignment) {
+		return ErrorUnalignedBuffer
+	}
+	if _, err := pageOffset(pageId); err != nil {
+		return err
+	}
 	d.writeCount++
-	offset := pageId * PageSize
-	_, err := d.dbFile.WriteAt(data, int64(offset))
+	if d.deferSync {
+		d.pending[pageId] = append(d.pending[pageId][:0], data...)
+		return nil
+	}
+	if err := d.writePage(pageId, data); err != nil {
+		return err
+	}
+	return d.syncFiles()
+}
+
+// Writes the page data to the database file without syncing it.
+func (d *DefaultDiskManager) writePage(pageId int, data []byte) error {
+	if d.compressed {
+		return d.writeCompressedPage(pageId, data)
+	}
+	offset, err := pageOffset(pageId)
+	if err != nil {
+		return err
+	}
+	_, err = d.dbFile.WriteAt(data, offset)
 	if err != nil {
 		log.Printf("error writing to file at offset %d", offset)
 		return ErrorWriteToDisk
 	}
+	return nil
+}
 
-	// Explicitly flush file buffer content to disk.
-	err = d.dbFile.Sync()
-	if err != nil {
+/*
+Sync writes out the pages staged by deferred syncing, in ascending page id order, and then
+explicitly flushes the file buffer content to disk. Without deferred syncing every page is
+already synced by WritePage, and Sync only flushes the file again.
+*/
+func (d *DefaultDiskManager) Sync() error {
+	pageIds := make([]int, 0, len(d.pending))
+	for pageId := range d.pending {
+		pageIds = append(pageIds, pageId)
+	}
+	slices.Sort(pageIds)
+	for _, pageId := range pageIds {
+		if err := d.writePage(pageId, d.pending[pageId]); err != nil {
+			return err
+		}
+		delete(d.pending, pageId)
+	}
+	return d.syncFiles()
+}
+
+func (d *DefaultDiskManager) syncFiles() error {
+	if err := d.dbFile.Sync(); err != nil {
 		return ErrorFlushToDisk
 	}
+	if d.blockFile != nil {
+		if err := d.blockFile.Sync(); err != nil {
+			return ErrorFlushToDisk
+		}
+	}
 	return nil
 }
 
+// Returns the file offset of a page. The offset is computed as an int64, so it does not
+// overflow on 32-bit platforms, and page ids whose offset would overflow an int64 (or that
+// are negative) are rejected with ErrorOffsetOverflow instead of wrapping onto another page.
+func pageOffset(pageId int) (int64, error) {
+	if pageId < 0 || int64(pageId) > math.MaxInt64/PageSize {
+		return 0, fmt.Errorf("%w: page id %d", ErrorOffsetOverflow, pageId)
+	}
+	return int64(pageId) * PageSize, nil
+}
+
 // Read the contents of the specified page from disk into the byte buffer
 func (d *DefaultDiskManager) ReadPage(pageId int, buf []byte) error {
-	offset := pageId * PageSize
-	n, err := d.dbFile.ReadAt(buf, int64(offset))
-	log.Printf("read bytes %d from page %d", n, pageId)
+	if !IsAligned(buf, d.alignment) {
+		return ErrorUnalignedBuffer
+	}
+	offset, err := pageOffset(pageId)
+	if err != nil {
+		return err
+	}
+	if data, ok := d.pending[pageId]; ok {
+		clear(buf)
+		copy(buf, data)
+		return nil
+	}
+	if d.compressed {
+		return d.readCompressedPage(pageId, buf)
+	}
+	n, err := d.dbFile.ReadAt(buf, offset)
 	if err != nil && err != io.EOF {
 		log.Printf("error when writing to disk page %d", pageId)
 		return ErrorReadFromDisk
@@ -89,3 +252,64 @@ func (d *DefaultDiskManager) ReadPage(pageId int, buf []byte) error {
 	}
 	return nil
 }
+
+// NumPages returns the number of pages in the database file. A partially written
+// last page counts as a page.
+func (d *DefaultDiskManager) NumPages() (int, error) {
+	n := 0
+	if d.compressed {
+		n = len(d.blocks)
+	} else {
+		info, err := d.dbFile.Stat()
+		if err != nil {
+			return 0, err
+		}
+		n = int((info.Size() + PageSize - 1) / PageSize)
+	}
+	for pageId := range d.pending {
+		n = max(n, pageId+1)
+	}
+	return n, nil
+}
+
+// Truncate shrinks (or grows) the database file to hold exactly numPages pages.
+// The disk manager does not know which pages are still in use, so callers must not
+// truncate below the highest allocated page (see BufferPoolManager.Truncate).
+func (d *DefaultDiskManager) Truncate(numPages int) error {
+	if numPages < 0 {
+		return fmt.Errorf("%w: negative number of pages %d", ErrorTruncateFile, numPages)
+	}
+	for pageId := range d.pending {
+		if pageId >= numPages {
+			delete(d.pending, pageId)
+		}
+	}
+	if d.compressed {
+		return d.truncateCompressed(numPages)
+	}
+	if err := d.dbFile.Truncate(int64(numPages) * PageSize); err != nil {
+		log.Printf("error truncating file to %d pages", numPages)
+		return ErrorTruncateFile
+	}
+	return nil
+}
+
+/*
+NullDiskManager is a disk manager without a disk, for buffer pools that keep every page in
+memory (see memory.NewInMemoryBufferPoolManager). Writes are discarded, every page reads as
+zeroes, and there are no pages on disk.
+*/
+type NullDiskManager struct{}
+
+func (NullDiskManager) WritePage(pageId int, data []byte) error { return nil }
+
+func (NullDiskManager) ReadPage(pageId int, buf []byte) error {
+	clear(buf)
+	return nil
+}
+
+func (NullDiskManager) NumPages() (int, error) { return 0, nil }
+
+func (NullDiskManager) Truncate(numPages int) error { return nil }
+
+func (NullDiskManager) Sync() error { return nil }
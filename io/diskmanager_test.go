@@ -2,8 +2,11 @@ package io
 
 import (
 	"bytes"
+	"compress/flate"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"testing"
 )
@@ -39,3 +42,166 @@ func setup() DiskManager {
 	dbFileName := "dbtest_1"
 	return NewDiskManager(baseDir + dbFileName)
 }
+
+func Test_alignment(t *testing.T) {
+	const blockSize = 512
+	d := NewDiskManager(t.TempDir()+"/aligned", WithAlignment(blockSize))
+	defer d.(*DefaultDiskManager).Shutdown()
+
+	buf := AlignedBuffer(PageSize, blockSize)
+	if !IsAligned(buf, blockSize) || len(buf) != PageSize {
+		t.Fatalf("buffer of length %d is not aligned to %d bytes", len(buf), blockSize)
+	}
+	if err := d.WritePage(0, buf); err != nil {
+		t.Fatalf("aligned write failed: %v", err)
+	}
+	if err := d.ReadPage(0, buf); err != nil {
+		t.Fatalf("aligned read failed: %v", err)
+	}
+
+	unaligned := AlignedBuffer(PageSize+1, blockSize)[1:]
+	if err := d.WritePage(0, unaligned); !errors.Is(err, ErrorUnalignedBuffer) {
+		t.Fatalf("expected %v for an unaligned write, got %v", ErrorUnalignedBuffer, err)
+	}
+	if err := d.ReadPage(0, unaligned); !errors.Is(err, ErrorUnalignedBuffer) {
+		t.Fatalf("expected %v for an unaligned read, got %v", ErrorUnalignedBuffer, err)
+	}
+}
+
+func Test_compression(t *testing.T) {
+	dir := t.TempDir()
+	plain := NewDiskManager(dir + "/plain")
+	defer plain.(*DefaultDiskManager).Shutdown()
+	compressed := NewDiskManager(dir+"/compressed", WithCompression())
+
+	const numPages = 8
+	pages := make([][]byte, numPages)
+	for i := range pages {
+		// Compressible content: a short repeating pattern.
+		pages[i] = bytes.Repeat([]byte{byte(i), 0, 0, 1}, PageSize/4)
+		if err := plain.WritePage(i, pages[i]); err != nil {
+			t.Fatalf("write of page %d failed: %v", i, err)
+		}
+		if err := compressed.WritePage(i, pages[i]); err != nil {
+			t.Fatalf("compressed write of page %d failed: %v", i, err)
+		}
+	}
+	// Rewrite a page with incompressible content, which moves it to a larger block.
+	pages[3] = make([]byte, PageSize)
+	rand.Read(pages[3])
+	if err := compressed.WritePage(3, pages[3]); err != nil {
+		t.Fatalf("compressed rewrite of page 3 failed: %v", err)
+	}
+
+	plainInfo, _ := os.Stat(dir + "/plain")
+	compressedInfo, _ := os.Stat(dir + "/compressed")
+	if compressedInfo.Size() >= plainInfo.Size() {
+		t.Fatalf("compressed file has %d bytes, plain file %d bytes", compressedInfo.Size(), plainInfo.Size())
+	}
+
+	// Reopen the compressed file, so pages are located through the persisted block map.
+	compressed.(*DefaultDiskManager).Shutdown()
+	compressed = NewDiskManager(dir+"/compressed", WithCompression())
+	defer compressed.(*DefaultDiskManager).Shutdown()
+	n, err := compressed.NumPages()
+	if err != nil || n != numPages {
+		t.Fatalf("expected %d pages, got %d (%v)", numPages, n, err)
+	}
+	buf := make([]byte, PageSize)
+	for i := range pages {
+		if err := compressed.ReadPage(i, buf); err != nil {
+			t.Fatalf("compressed read of page %d failed: %v", i, err)
+		}
+		if !bytes.Equal(buf, pages[i]) {
+			t.Fatalf("page %d does not round-trip through compressed storage", i)
+		}
+	}
+
+	if err := compressed.Truncate(2); err != nil {
+		t.Fatalf("truncate failed: %v", err)
+	}
+	if err := compressed.ReadPage(5, buf); err != nil || !bytes.Equal(buf, make([]byte, PageSize)) {
+		t.Fatalf("expected a truncated page to read as zeroes (%v)", err)
+	}
+	if err := compressed.ReadPage(1, buf); err != nil || !bytes.Equal(buf, pages[1]) {
+		t.Fatalf("page 1 does not survive the truncate (%v)", err)
+	}
+}
+
+func Test_truncatedCompressedBlock(t *testing.T) {
+	d := NewDiskManager(t.TempDir()+"/compressed", WithCompression()).(*DefaultDiskManager)
+	defer d.Shutdown()
+	if err := d.WritePage(0, bytes.Repeat([]byte{1, 2}, PageSize/2)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	// a block that decompresses to half a page
+	var short bytes.Buffer
+	w, _ := flate.NewWriter(&short, flate.BestSpeed)
+	w.Write(bytes.Repeat([]byte{3}, PageSize/2))
+	w.Close()
+	if _, err := d.dbFile.WriteAt(short.Bytes(), d.blocks[0].offset); err != nil {
+		t.Fatalf("write of the short block failed: %v", err)
+	}
+	d.blocks[0].length = uint32(short.Len())
+	if err := d.ReadPage(0, make([]byte, PageSize)); !errors.Is(err, ErrorReadFromDisk) {
+		t.Fatalf("expected %v for a truncated block, got %v", ErrorReadFromDisk, err)
+	}
+}
+
+func Test_deferredSync(t *testing.T) {
+	fileName := t.TempDir() + "/deferred"
+	d := NewDiskManager(fileName, WithDeferredSync())
+	defer d.(*DefaultDiskManager).Shutdown()
+
+	data := bytes.Repeat([]byte{7}, PageSize)
+	if err := d.WritePage(1, data); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	buf := make([]byte, PageSize)
+	if err := d.ReadPage(1, buf); err != nil || !bytes.Equal(buf, data) {
+		t.Fatalf("expected to read back the staged page (%v)", err)
+	}
+	if n, _ := d.NumPages(); n != 2 {
+		t.Fatalf("expected 2 pages, got %d", n)
+	}
+
+	// A separate reader of the database file does not see the page before Sync.
+	onDisk, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onDisk) != 0 {
+		t.Fatalf("expected an empty database file before sync, got %d bytes", len(onDisk))
+	}
+
+	if err := d.Sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	onDisk, err = os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onDisk) != 2*PageSize || !bytes.Equal(onDisk[PageSize:], data) {
+		t.Fatalf("expected the page on disk after sync, got %d bytes", len(onDisk))
+	}
+}
+
+func Test_offsetOverflow(t *testing.T) {
+	d := NewDiskManager(t.TempDir() + "/overflow")
+	defer d.(*DefaultDiskManager).Shutdown()
+
+	buf := make([]byte, PageSize)
+	for _, pageId := range []int{math.MaxInt64/PageSize + 1, math.MaxInt, -1} {
+		if err := d.WritePage(pageId, buf); !errors.Is(err, ErrorOffsetOverflow) {
+			t.Fatalf("expected %v writing page %d, got %v", ErrorOffsetOverflow, pageId, err)
+		}
+		if err := d.ReadPage(pageId, buf); !errors.Is(err, ErrorOffsetOverflow) {
+			t.Fatalf("expected %v reading page %d, got %v", ErrorOffsetOverflow, pageId, err)
+		}
+	}
+	offset, err := pageOffset(math.MaxInt64 / PageSize)
+	if err != nil || offset != math.MaxInt64/PageSize*PageSize {
+		t.Fatalf("expected the largest page offset, got %d (%v)", offset, err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"math/rand"
+	"os"
 	"time"
 	"wtfDB/index"
 	"wtfDB/io"
@@ -14,6 +15,9 @@ func main() {
 	indexName := "primary"
 	filename := "db_files/dbtest_2"
 	bufferSize := 4
+	if err := os.MkdirAll("db_files", 0750); err != nil {
+		panic(err)
+	}
 	bpm := memory.NewBufferPoolManager(io.NewDiskManager(filename), bufferSize)
 	treeMetadata := index.NewBPlusTreeMetadata(indexName)
 	t, err := index.NewBPlusTree(indexName, bpm, treeMetadata)
@@ -27,6 +31,11 @@ func main() {
 		index.PrettyPrint(t.Root, 0, "", false)
 		time.Sleep(1 * time.Second)
 	}
+	// the root page id is saved in the header page, the pages of the tree must be flushed
+	// so that the next run can open the tree again
+	if !bpm.FlushAllPages() {
+		panic("unable to flush the buffer pool")
+	}
 	bptree = t
 	// index.PrettyPrint(t.Root, 0, "", false)
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"slices"
+	"sync"
 	"wtfDB/io"
 )
 
@@ -21,15 +22,133 @@ in memory for faster access, and evicting unused or cold pages back out to stora
 * It allows a DBMS to support databases that are larger than the amount of memory available to the system.
 Consider a computer with 1 GB of memory (RAM). If we want to manage a 2 GB database, a buffer pool manager
 gives us the ability to interact with this database without needing to fit its entire contents in memory.
+
+The buffer pool manager is safe for concurrent use: its page table, pin counts and replacer
+are guarded by a single pool latch. The latch does not protect the contents of a frame's page data.
 */
 type BufferPoolManager struct {
+	mu           sync.Mutex  // pool latch, guards the page table, pin counts and the replacer
 	frames       []*Frame    // list of frame metadata of the frames that the buffer pool manages
+	dirtyFrames  int         // the number of frames holding a page that was modified since it was last flushed
+	pinnedFrames int         // the number of frames with a pin count greater than zero
+	stats        PoolStats   // hit and miss counters of GetPage requests
 	pageToFrame  map[int]int // buffer manager hash table on page id to frame id
 	nextPageId   int         // the next page id to be allocated -- monotonically increasing counter
-	freeFrames   []int       // list of free frames that do not hold any page data
+	freeFrames   []int       // stack of free frames that do not hold any page data, the top is at the end
 	size         int         // the number of frames the buffer pool manages
 	diskManager  io.DiskManager
 	lrukreplacer *LruKReplacer
+
+	validatePageIds bool         // reject requests for pages that were never allocated
+	deletedPages    map[int]bool // deleted page ids below nextPageId
+	blockAlignment  int          // the block size frame buffers are aligned to, 0 if unaligned
+	writeThrough    map[int]bool // pages flushed as soon as they are unpinned, see SetWriteThrough
+
+	onEvict  func(pageId, frameId int, wasDirty bool) // called for every evicted page, see WithOnEvict
+	inMemory bool                                     // pages are never evicted or flushed, see NewInMemoryBufferPoolManager
+}
+
+// PoolStats counts how GetPage requests were served.
+type PoolStats struct {
+	Hits   int // requests for pages that were already in the buffer pool
+	Misses int // requests for pages that had to be read from disk
+}
+
+// The number of frames an operation on a b+ tree with an inner root pins at most: the root,
+// which stays pinned, a node and the new sibling of its split.
+const minTreeFrames = 3
+
+var (
+	ErrPageNotAllocated = fmt.Errorf("page not allocated")
+	ErrBufferPoolFull   = fmt.Errorf("buffer pool is full, every frame is pinned")
+)
+
+// Option configures optional behaviour of a BufferPoolManager.
+type Option func(*BufferPoolManager)
+
+/*
+WithCleanPageEviction makes the replacer prefer clean pages over dirty pages among the
+equally cold eviction candidates (the frames with the same backward k-distance), so that
+a write-heavy workload does not flush a dirty page when a clean page can be evicted instead.
+Recency still decides first: a colder dirty page is evicted before a warmer clean page.
+*/
+func WithCleanPageEviction() Option {
+	return func(m *BufferPoolManager) {
+		m.lrukreplacer.isClean = func(frameId int) bool {
+			return !m.frames[frameId].IsDirty
+		}
+	}
+}
+
+/*
+EvictionCost returns the cost of evicting the page in a frame, eg. the cost of flushing it
+when it is dirty. The cost is measured in positions of recency: see WithEvictionCost.
+*/
+type EvictionCost func(*Frame) int
+
+/*
+WithEvictionCost makes the replacer choose the page to evict by a blend of recency and the
+cost of evicting it, for storage media on which flushing a page is expensive. The evictable
+frames are ranked from the coldest (rank 0) in LRU-K order, and the frame with the lowest
+rank plus cost is evicted; among equal scores, the colder frame is. A cost of c thus lets a
+frame stay resident in place of up to c warmer frames. Costs should not be negative.
+
+The cost function is called with the pool latch held, so it must not call back into the
+buffer pool. It generalizes WithCleanPageEviction, which it takes precedence over.
+*/
+func WithEvictionCost(cost EvictionCost) Option {
+	return func(m *BufferPoolManager) {
+		m.lrukreplacer.cost = func(frameId int) int {
+			return cost(m.frames[frameId])
+		}
+	}
+}
+
+/*
+WithBlockAlignment allocates the page buffer of every frame aligned to the given disk
+block size (a power of two, eg. 512 or 4096), as required for direct I/O (O_DIRECT).
+Pair it with io.WithAlignment on the disk manager to catch unaligned buffers.
+*/
+func WithBlockAlignment(blockSize int) Option {
+	return func(m *BufferPoolManager) {
+		m.blockAlignment = blockSize
+	}
+}
+
+// WithWriteThrough makes the given pages write-through from the start, see SetWriteThrough.
+func WithWriteThrough(pageIds ...int) Option {
+	return func(m *BufferPoolManager) {
+		for _, pageId := range pageIds {
+			m.writeThrough[pageId] = true
+		}
+	}
+}
+
+/*
+WithPageValidation makes GetPage reject page ids that were never allocated by this
+buffer pool with ErrPageNotAllocated, instead of reading whatever is on disk at
+that offset. A request for such a page is almost always a corrupt child pointer.
+
+Allocated pages are the pages of the database file when the pool was created, plus the
+pages the pool allocated since (page ids below nextPageId), minus the deleted pages.
+*/
+func WithPageValidation() Option {
+	return func(m *BufferPoolManager) {
+		m.validatePageIds = true
+	}
+}
+
+/*
+WithOnEvict registers a callback that is invoked every time a page is evicted to make room
+for another page, with the page id and frame id of the victim, and whether it was dirty (and
+so had to be flushed). This lets users log or sample eviction patterns, eg. to diagnose cache
+thrashing. The callback runs with the pool latch held, so it must be cheap and must not call
+back into the buffer pool.
+*/
+func WithOnEvict(fn func(pageId, frameId int, wasDirty bool)) Option {
+	return func(m *BufferPoolManager) {
+		m.onEvict = fn
+	}
 }
 
 // Buffer frame metadata stores metadata about a frame / page in memory.
@@ -37,7 +156,7 @@ type BufferPoolManager struct {
 type FrameMetadata struct {
 	Id       int  // The frame id/index of the frame in the buffer pool
 	PageId   int  // page id
-	IsDirty  bool // flag to track whether a page has been modified/written
+	IsDirty  bool // flag to track whether a page has been modified/written, set via MarkDirty
 	refBit   bool // allows page to be referenced once before it is eligible for eviction
 	pinCount int  // number of tasks/queries that are working with the page in memory
 }
@@ -50,16 +169,31 @@ type Frame struct {
 
 const InvalidPageId = int(-1)
 
-func newFrame(i int) *Frame {
+func newFrame(i int, alignment int) *Frame {
 	return &Frame{
 		FrameMetadata: FrameMetadata{
 			Id:     i,
 			PageId: InvalidPageId,
 		},
-		Data: make([]byte, io.PageSize), // buffer frame size determined by page size
+		Data: io.AlignedBuffer(io.PageSize, alignment), // buffer frame size determined by page size
 	}
 }
 
+// IsPinnedPage reports whether frame f holds the page pageId and is pinned, ie. whether
+// a node cached on the frame still reflects the page.
+func (m *BufferPoolManager) IsPinnedPage(f *Frame, pageId int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return f.PageId == pageId && f.IsPinned()
+}
+
+// PinCount returns the number of pins held on frame f.
+func (m *BufferPoolManager) PinCount(f *Frame) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return f.pinCount
+}
+
 // Pin pins a buffer frame to indicate the page is "in use".
 // A frame's page cannot be evicted while pinned.
 func (f *Frame) IsPinned() bool {
@@ -67,7 +201,16 @@ func (f *Frame) IsPinned() bool {
 }
 
 func (m *BufferPoolManager) Pin(f *Frame) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pin(f)
+}
+
+func (m *BufferPoolManager) pin(f *Frame) {
 	// fmt.Printf("Buffer manager: pinning frame: frameId=%d, pinCount=%d\n", f.Id, f.pinCount)
+	if f.pinCount == 0 {
+		m.pinnedFrames++
+	}
 	f.pinCount++
 	// fmt.Printf("Buffer manager: updated pin count: %d\n", f.pinCount)
 	m.lrukreplacer.recordAccess(f.Id)
@@ -76,36 +219,163 @@ func (m *BufferPoolManager) Pin(f *Frame) {
 
 // Unpin buffer frame.
 func (m *BufferPoolManager) Unpin(f *Frame) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	// fmt.Printf("Buffer manager: unpin frame: frameId=%d, pinCount=%d\n", f.Id, f.pinCount)
 	if f.pinCount <= 0 {
 		return
 	}
 	f.pinCount--
+	if f.pinCount == 0 {
+		m.pinnedFrames--
+	}
 	m.lrukreplacer.setEvictable(f.Id, f.pinCount == 0)
+	if f.pinCount == 0 && f.IsDirty && m.writeThrough[f.PageId] {
+		m.flushWriteThrough(f.PageId)
+	}
 	// fmt.Printf("Buffer manager: unpinned frame: frameId=%d, pinCount=%d, isEvictable=%v\n", f.Id, f.pinCount, m.lrukreplacer.metadataStore[f.Id].isEvictable)
 }
 
+/*
+SetWriteThrough makes a page write-through, or turns it back into a regular page. A dirty
+write-through page is flushed and synced to disk as soon as its last pin is released, rather
+than when it is evicted or at the next checkpoint, so that a page that must be durable right
+away, eg. a header page, does not need a full FlushAllPages. Syncing the disk manager also
+makes every page it staged durable (see io.WithDeferredSync). A page that fails to flush
+stays dirty, and is flushed again on its next unpin or its eviction.
+*/
+func (m *BufferPoolManager) SetWriteThrough(pageId int, writeThrough bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if writeThrough {
+		m.writeThrough[pageId] = true
+	} else {
+		delete(m.writeThrough, pageId)
+	}
+}
+
+func (m *BufferPoolManager) flushWriteThrough(pageId int) {
+	if m.inMemory || !m.flushPage(pageId) {
+		return
+	}
+	if err := m.diskManager.Sync(); err != nil {
+		log.Printf("error syncing write-through page %d to disk: %v", pageId, err)
+	}
+}
+
+// MarkDirty records that the frame's page data was modified in memory,
+// so that it is written out to disk before the frame is reused.
+func (m *BufferPoolManager) MarkDirty(f *Frame) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !f.IsDirty {
+		f.IsDirty = true
+		m.dirtyFrames++
+	}
+}
+
+// DirtyPageCount returns the number of pages in the buffer pool that were modified
+// since they were last flushed to disk.
+func (m *BufferPoolManager) DirtyPageCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dirtyFrames
+}
+
+// Stats returns the number of GetPage requests served from memory and from disk.
+func (m *BufferPoolManager) Stats() PoolStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// PinnedPageCount returns the number of pages in the buffer pool that are pinned,
+// and therefore cannot be evicted.
+func (m *BufferPoolManager) PinnedPageCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pinnedFrames
+}
+
+// Size returns the number of frames the buffer pool manages.
+func (m *BufferPoolManager) Size() int {
+	return m.size
+}
+
 func (f *Frame) ZeroBuffer() {
 	for i := range f.Data {
 		f.Data[i] = 0
 	}
 }
 
-func NewBufferPoolManager(dsm io.DiskManager, size int) *BufferPoolManager {
-	freeFrames := make([]int, size)
-	frames := make([]*Frame, size)
-	for i := range size {
-		freeFrames[i] = i
-		frames[i] = newFrame(i)
+// Zeroes the bytes of the frame from offset to the end of the page. A node that is
+// serialized onto the frame only overwrites the bytes it uses, so this clears whatever
+// a previously larger node left behind.
+func (f *Frame) ZeroFrom(offset int) {
+	if offset < len(f.Data) {
+		clear(f.Data[offset:])
 	}
-	return &BufferPoolManager{
-		frames:       frames,
-		freeFrames:   freeFrames, // todo: maybe should be a queue ??/
+}
+
+func NewBufferPoolManager(dsm io.DiskManager, size int, opts ...Option) *BufferPoolManager {
+	m := &BufferPoolManager{
 		pageToFrame:  make(map[int]int),
 		diskManager:  dsm,
 		lrukreplacer: NewLruKReplacer(),
 		size:         size,
+		deletedPages: make(map[int]bool),
+		writeThrough: make(map[int]bool),
+	}
+	// new pages are allocated after the pages that already exist on disk
+	if numPages, err := dsm.NumPages(); err != nil {
+		log.Printf("unable to determine the number of pages on disk: %+v", err)
+	} else {
+		m.nextPageId = numPages
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+	freeFrames := make([]int, size)
+	frames := make([]*Frame, size)
+	for i := range size {
+		freeFrames[size-1-i] = i // frame 0 on top, so that frames are first handed out in order
+		frames[i] = newFrame(i, m.blockAlignment)
+	}
+	m.frames = frames
+	m.freeFrames = freeFrames
+	if size < minTreeFrames {
+		log.Printf("buffer pool of %d frames is too small for a b+ tree, which pins up to %d frames", size, minTreeFrames)
+	}
+	return m
+}
+
+/*
+Creates a buffer pool that keeps every page in memory and has no disk: pages are never
+evicted, and flushing is a no-op. This turns a tree on top of the pool into a bounded
+in-memory ordered map without persistence. The pool holds at most size pages; once every
+frame holds a page, allocating another page fails with ErrBufferPoolFull. Deleted pages
+free their frames.
+*/
+func NewInMemoryBufferPoolManager(size int, opts ...Option) *BufferPoolManager {
+	m := NewBufferPoolManager(io.NullDiskManager{}, size, opts...)
+	m.inMemory = true
+	return m
+}
+
+// Takes a frame off the free frame stack. Returns false if there are no free frames.
+func (m *BufferPoolManager) popFreeFrame() (int, bool) {
+	n := len(m.freeFrames)
+	if n == 0 {
+		return 0, false
+	}
+	i := m.freeFrames[n-1]
+	m.freeFrames = m.freeFrames[:n-1]
+	return i, true
+}
+
+// Returns a frame to the free frame stack. The most recently freed frame is reused first.
+func (m *BufferPoolManager) pushFreeFrame(i int) {
+	m.freeFrames = append(m.freeFrames, i)
 }
 
 /*
@@ -113,7 +383,14 @@ Creates a new pinned page in memory.
 The page is loaded onto a buffer frame.
 */
 func (m *BufferPoolManager) GetNewPageFrame() (*Frame, error) {
-	return m.GetPage(m.newPage())
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pageId := m.newPage()
+	if pageId == InvalidPageId {
+		return nil, fmt.Errorf("%w: unable to create a new page", ErrBufferPoolFull)
+	}
+	f, _, err := m.getPage(pageId)
+	return f, err
 }
 
 /*
@@ -124,12 +401,9 @@ The page is not pinned in memory.
 */
 func (m *BufferPoolManager) newPage() int {
 	newPageId := m.nextPageId
-	m.nextPageId++
 
 	// need to persist new page to a buffer frame
-	if len(m.freeFrames) > 0 {
-		frameIdx := m.freeFrames[0]
-		m.freeFrames = slices.Delete(m.freeFrames, 0, 1) // todo: maybe use a queue/stack ?
+	if frameIdx, ok := m.popFreeFrame(); ok {
 		m.pageToFrame[newPageId] = frameIdx
 		m.frames[frameIdx].PageId = newPageId
 	} else {
@@ -144,11 +418,101 @@ func (m *BufferPoolManager) newPage() int {
 		}
 		m.pageToFrame[newPageId] = i
 	}
+	m.nextPageId++
 	return newPageId
 }
 
+/*
+Deletes a page, dropping it from the buffer pool without flushing it and returning its frame
+to the free frames. Returns false if the page is pinned, and ErrPageNotAllocated if the page
+was never allocated or is already deleted.
+
+When the last allocated page is deleted, the deleted pages at the end of the file are
+released: they are no longer allocated, so Truncate can shrink the file, and their page
+ids are handed out again by new pages.
+*/
 func (m *BufferPoolManager) DeletePage(pageId int) (bool, error) {
-	return false, nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.isAllocated(pageId) {
+		return false, fmt.Errorf("%w: page id %d", ErrPageNotAllocated, pageId)
+	}
+	if i, ok := m.pageToFrame[pageId]; ok {
+		f := m.frames[i]
+		if f.IsPinned() {
+			return false, nil
+		}
+		if err := m.lrukreplacer.remove(i); err != nil {
+			return false, err
+		}
+		if f.IsDirty {
+			m.dirtyFrames--
+		}
+		f.FrameMetadata = FrameMetadata{Id: i, PageId: InvalidPageId}
+		f.ZeroBuffer()
+		delete(m.pageToFrame, pageId)
+		m.pushFreeFrame(i)
+	}
+	m.deletedPages[pageId] = true
+	delete(m.writeThrough, pageId)
+	for m.nextPageId > 0 && m.deletedPages[m.nextPageId-1] {
+		m.nextPageId--
+		delete(m.deletedPages, m.nextPageId)
+	}
+	return true, nil
+}
+
+func (m *BufferPoolManager) isAllocated(pageId int) bool {
+	return pageId >= 0 && pageId < m.nextPageId && !m.deletedPages[pageId]
+}
+
+// IsAllocated reports whether page pageId is allocated: it was handed out by a new page and
+// not deleted since.
+func (m *BufferPoolManager) IsAllocated(pageId int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.isAllocated(pageId)
+}
+
+// Returns the ids of all allocated pages in ascending order: the pages below the next page id that were not deleted.
+func (m *BufferPoolManager) AllocatedPageIds() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pageIds := make([]int, 0, m.nextPageId-len(m.deletedPages))
+	for pageId := range m.nextPageId {
+		if m.isAllocated(pageId) {
+			pageIds = append(pageIds, pageId)
+		}
+	}
+	return pageIds
+}
+
+/*
+Returns the number of free pages and the total number of pages of the database file. The
+free pages are the deleted pages in the middle of the file, and the deleted pages at the end
+of the file that Truncate has not dropped yet. The total includes the allocated pages that
+are not written to the file yet.
+*/
+func (m *BufferPoolManager) PageCounts() (freePages int, totalPages int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	filePages, err := m.diskManager.NumPages()
+	if err != nil {
+		return 0, 0, err
+	}
+	totalPages = max(filePages, m.nextPageId)
+	return len(m.deletedPages) + totalPages - m.nextPageId, totalPages, nil
+}
+
+/*
+Truncate shrinks the database file to the allocated pages, dropping the pages that were
+deleted from the end of the file. The file is never truncated below the highest allocated
+page, so deleted pages in the middle of the file keep their space.
+*/
+func (m *BufferPoolManager) Truncate() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.diskManager.Truncate(m.nextPageId)
 }
 
 // GetPage returns a Page object that represents the page with the given page number
@@ -156,13 +520,94 @@ func (m *BufferPoolManager) DeletePage(pageId int) (bool, error) {
 // and placed in a frame in the buffer pool. The page is pinned in memory until it is
 // unpinned by the requestor(caller), at which point it is eligible for eviction
 // by the buffer pool's eviction policy.
+//
+// With page validation enabled, a page id that was never allocated returns ErrPageNotAllocated.
 func (m *BufferPoolManager) GetPage(pageId int) (*Frame, error) {
-	f, err := m.getPageFrame(pageId)
+	f, _, err := m.GetPageWithHit(pageId)
+	return f, err
+}
+
+// GetPageWithHit is GetPage, but also reports whether the page was already in the
+// buffer pool (a hit), or had to be read from disk.
+func (m *BufferPoolManager) GetPageWithHit(pageId int) (*Frame, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.validatePageIds && !m.isAllocated(pageId) {
+		return nil, false, fmt.Errorf("%w: page id %d", ErrPageNotAllocated, pageId)
+	}
+	f, wasHit, err := m.getPage(pageId)
+	if err != nil {
+		return nil, false, err
+	}
+	if wasHit {
+		m.stats.Hits++
+	} else {
+		m.stats.Misses++
+	}
+	return f, wasHit, nil
+}
+
+/*
+ReadPageUncached reads a page into buf without bringing it into the buffer pool, so that a
+large sequential scan does not evict the pages of other work. A page that is in the pool is
+copied from its frame, since it may be newer than the page on disk; any other page is read
+from disk. buf must be a page buffer, see PageBuffer.
+*/
+func (m *BufferPoolManager) ReadPageUncached(pageId int, buf []byte) error {
+	m.mu.Lock()
+	if i, ok := m.pageToFrame[pageId]; ok {
+		copy(buf, m.frames[i].Data)
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
+	// a page that is not in the pool was flushed before it was evicted, so the disk is current
+	if err := m.diskManager.ReadPage(pageId, buf); err != nil {
+		return fmt.Errorf("unable to read page %d: %w", pageId, err)
+	}
+	return nil
+}
+
+// PageBuffer returns a buffer for a page that is not held by the pool, aligned like the frames of the pool.
+func (m *BufferPoolManager) PageBuffer() []byte {
+	return io.AlignedBuffer(io.PageSize, m.blockAlignment)
+}
+
+/*
+TryGetPage is GetPage for latency-critical reads that must not pay for an eviction (and
+the flush of a dirty victim). The page is pinned and returned if it is already in the
+buffer pool, or if it can be read into a free frame. Returns nil and false if loading the
+page would require evicting another page, so the caller can do the work asynchronously
+instead. Also returns false if the page cannot be read.
+*/
+func (m *BufferPoolManager) TryGetPage(pageId int) (*Frame, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.validatePageIds && !m.isAllocated(pageId) {
+		return nil, false
+	}
+	if _, resident := m.pageToFrame[pageId]; !resident && len(m.freeFrames) == 0 {
+		return nil, false
+	}
+	f, wasHit, err := m.getPage(pageId)
+	if err != nil {
+		return nil, false
+	}
+	if wasHit {
+		m.stats.Hits++
+	} else {
+		m.stats.Misses++
+	}
+	return f, true
+}
+
+func (m *BufferPoolManager) getPage(pageId int) (*Frame, bool, error) {
+	f, wasHit, err := m.getPageFrame(pageId)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	m.Pin(f)
-	return f, nil
+	m.pin(f)
+	return f, wasHit, nil
 }
 
 func (m *BufferPoolManager) WritePage(pageId int, contents []byte) error {
@@ -170,7 +615,8 @@ func (m *BufferPoolManager) WritePage(pageId int, contents []byte) error {
 }
 
 /*
-Returns a buffer frame with the specified page. This method also pins the page.
+Returns a buffer frame with the specified page, and whether the page was already in memory
+(a hit) or had to be read from disk. The caller pins the page.
 
 This method handles 3 cases:
   - Case 1. the page exists in memory, therefore no need for additional i/o to fetch page
@@ -180,28 +626,29 @@ This method handles 3 cases:
     a page in memory, using lru-k to find a candidate frame for eviction, in order to bring
     in the specified page into a frame.
 */
-func (m *BufferPoolManager) getPageFrame(pageId int) (*Frame, error) {
+func (m *BufferPoolManager) getPageFrame(pageId int) (*Frame, bool, error) {
 	// case 1: page is loaded in memory
 	if i, ok := m.pageToFrame[pageId]; ok {
 		frame := m.frames[i]
-		return frame, nil
+		return frame, true, nil
 	}
 
 	// handles case 2 and 3 when the page is not found in memory
 	// case 2: page is not in memory, and there exists free frame/s
-	if len(m.freeFrames) > 0 {
-		i := m.freeFrames[0]
+	if i, ok := m.popFreeFrame(); ok {
 		frame := m.frames[i]
 		m.pageToFrame[pageId] = i
 		frame.PageId = pageId
-		m.diskManager.ReadPage(pageId, frame.Data)
-		return frame, nil
+		if err := m.readPage(frame); err != nil {
+			return nil, false, err
+		}
+		return frame, false, nil
 	}
 
 	// case 3: page is not in memory, and memory/buffer is full
 	evicted, i := m.evict()
 	if !evicted {
-		return nil, fmt.Errorf("internal error: memory is full - retry")
+		return nil, false, fmt.Errorf("%w: unable to load page %d", ErrBufferPoolFull, pageId)
 	}
 	frame := m.frames[i]
 	frame.FrameMetadata = FrameMetadata{
@@ -209,13 +656,33 @@ func (m *BufferPoolManager) getPageFrame(pageId int) (*Frame, error) {
 		PageId: pageId,
 	}
 	m.pageToFrame[pageId] = i
-	m.diskManager.ReadPage(pageId, frame.Data) // read new page into frame
-	return frame, nil
+	if err := m.readPage(frame); err != nil { // read new page into frame
+		return nil, false, err
+	}
+	return frame, false, nil
+}
+
+// Reads the frame's page from disk. If the read fails, the page is dropped from the
+// frame, which is returned to the free frames.
+func (m *BufferPoolManager) readPage(f *Frame) error {
+	err := m.diskManager.ReadPage(f.PageId, f.Data)
+	if err == nil {
+		return nil
+	}
+	delete(m.pageToFrame, f.PageId)
+	m.pushFreeFrame(f.Id)
+	pageId := f.PageId
+	f.FrameMetadata = FrameMetadata{Id: f.Id, PageId: InvalidPageId}
+	return fmt.Errorf("unable to read page %d: %w", pageId, err)
 }
 
 // Returns true if a page was successfully evicted from the buffer pool. If true,
 // the index of the evicted/free buffer frame is returned, otherwise -1.
 func (m *BufferPoolManager) evict() (bool, int) {
+	if m.inMemory {
+		// an evicted page would be lost, there is no disk to flush it to
+		return false, -1
+	}
 	i, err := m.lrukreplacer.evict() // get candidate pool to evict
 	if err != nil {
 		log.Println("cannot perform eviction")
@@ -223,11 +690,15 @@ func (m *BufferPoolManager) evict() (bool, int) {
 		return false, -1
 	}
 	frame := m.frames[i]
-	if !m.FlushPage(frame.PageId) {
+	wasDirty := frame.IsDirty
+	if !m.flushPage(frame.PageId) {
 		log.Printf("unable to flush data to disk for page id: %d - retry", frame.PageId)
 		return false, -1
 	}
 	delete(m.pageToFrame, frame.PageId) // a frame can only map to a single page
+	if m.onEvict != nil {
+		m.onEvict(frame.PageId, i, wasDirty)
+	}
 	return true, i
 }
 
@@ -241,13 +712,19 @@ Returns true, if the frame/page was not modified or the page was successfully
 written to disk.
 */
 func (m *BufferPoolManager) FlushPage(pageId int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.flushPage(pageId)
+}
+
+func (m *BufferPoolManager) flushPage(pageId int) bool {
 	frameId, ok := m.pageToFrame[pageId]
 	if !ok {
 		log.Printf("page id %d not found in buffer", pageId)
 		return false
 	}
 	f := m.frames[frameId]
-	if !f.IsDirty {
+	if !f.IsDirty || m.inMemory {
 		return true
 	}
 	err := m.diskManager.WritePage(int(pageId), f.Data)
@@ -256,15 +733,43 @@ func (m *BufferPoolManager) FlushPage(pageId int) bool {
 		return false
 	}
 	f.IsDirty = false
+	m.dirtyFrames--
 	return true
 }
 
-// Flushes all page data that is in memory to disk
-// Fixme: needs to perform some sanity checks
+/*
+Flushes all page data that is in memory to disk.
+
+Pages are flushed in ascending page id order rather than in map iteration order,
+so that the sequence of disk writes is deterministic and repeatable. The buffer
+pool has no knowledge of the index structure (or of log sequence numbers), so
+this is the only ordering guarantee it provides.
+
+The pool latch is held for the whole flush, so pages cannot be brought in or evicted
+(mutating the page table) while it is being iterated. Flushing all pages is a safe point,
+so the disk manager is synced afterwards (see io.WithDeferredSync).
+
+Fixme: needs to perform some sanity checks
+*/
 func (m *BufferPoolManager) FlushAllPages() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.inMemory {
+		return true
+	}
+	pageIds := make([]int, 0, len(m.pageToFrame))
+	for pageId := range m.pageToFrame {
+		pageIds = append(pageIds, pageId)
+	}
+	slices.Sort(pageIds)
+
 	allFlushed := true
-	for pageId, _ := range m.pageToFrame {
-		allFlushed = allFlushed && m.FlushPage(pageId)
+	for _, pageId := range pageIds {
+		allFlushed = m.flushPage(pageId) && allFlushed
+	}
+	if err := m.diskManager.Sync(); err != nil {
+		log.Printf("error syncing pages to disk: %v", err)
+		return false
 	}
 	return allFlushed
 }
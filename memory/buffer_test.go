@@ -0,0 +1,567 @@
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"wtfDB/io"
+)
+
+func Test_flushAllPagesInPageIdOrder(t *testing.T) {
+	dm := newRecordingDiskManager()
+	bpm := NewBufferPoolManager(dm, 8)
+
+	for range 8 {
+		f, err := bpm.GetNewPageFrame()
+		if err != nil {
+			t.Fatalf("unable to create page: %v", err)
+		}
+		bpm.MarkDirty(f)
+		bpm.Unpin(f)
+	}
+	// dirty pages in a different order than they were created
+	for _, pageId := range []int{5, 2, 7} {
+		f, _ := bpm.GetPage(pageId)
+		f.Data[0] = byte(pageId)
+		bpm.Unpin(f)
+	}
+
+	assertEqual(t, true, bpm.FlushAllPages(), "all pages should be flushed")
+	assertEqual(t, 8, len(dm.writes), "every dirty page is written once")
+	for i, pageId := range dm.writes {
+		assertEqual(t, i, pageId, "pages are flushed in ascending page id order")
+	}
+
+	// flushing clean pages does not write anything
+	dm.writes = nil
+	assertEqual(t, true, bpm.FlushAllPages(), "")
+	assertEqual(t, 0, len(dm.writes), "")
+}
+
+func Test_flushAllPagesWhileAllocating(t *testing.T) {
+	bpm := NewBufferPoolManager(newRecordingDiskManager(), 16)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 200 {
+			f, err := bpm.GetNewPageFrame()
+			if err != nil {
+				continue
+			}
+			bpm.Unpin(f)
+		}
+	}()
+
+	for flushing := true; flushing; {
+		select {
+		case <-done:
+			flushing = false
+		default:
+			bpm.FlushAllPages()
+		}
+	}
+	assertEqual(t, true, bpm.FlushAllPages(), "")
+}
+
+func Test_dirtyAndPinnedPageCounts(t *testing.T) {
+	bpm := NewBufferPoolManager(newRecordingDiskManager(), 2)
+	assertEqual(t, 0, bpm.DirtyPageCount(), "")
+	assertEqual(t, 0, bpm.PinnedPageCount(), "")
+
+	f1, _ := bpm.GetNewPageFrame()
+	f2, _ := bpm.GetNewPageFrame()
+	assertEqual(t, 2, bpm.PinnedPageCount(), "new pages are pinned")
+
+	bpm.Pin(f1)
+	assertEqual(t, 2, bpm.PinnedPageCount(), "a frame pinned twice is counted once")
+	bpm.Unpin(f1)
+	assertEqual(t, 2, bpm.PinnedPageCount(), "")
+	bpm.Unpin(f1)
+	assertEqual(t, 1, bpm.PinnedPageCount(), "")
+	bpm.Unpin(f1)
+	assertEqual(t, 1, bpm.PinnedPageCount(), "unpinning an unpinned frame is a no-op")
+
+	bpm.MarkDirty(f1)
+	bpm.MarkDirty(f1)
+	bpm.MarkDirty(f2)
+	assertEqual(t, 2, bpm.DirtyPageCount(), "a frame marked dirty twice is counted once")
+	assertEqual(t, true, bpm.FlushPage(f2.PageId), "")
+	assertEqual(t, 1, bpm.DirtyPageCount(), "")
+
+	// evicting the dirty page flushes it
+	f3, err := bpm.GetNewPageFrame()
+	if err != nil {
+		t.Fatalf("unable to create page: %v", err)
+	}
+	assertEqual(t, 0, bpm.DirtyPageCount(), "")
+	assertEqual(t, 2, bpm.PinnedPageCount(), "")
+
+	bpm.Unpin(f2)
+	bpm.Unpin(f3)
+	assertEqual(t, 0, bpm.PinnedPageCount(), "")
+}
+
+func Test_getPageNotAllocated(t *testing.T) {
+	bpm := NewBufferPoolManager(newRecordingDiskManager(), 4, WithPageValidation())
+	f, err := bpm.GetNewPageFrame()
+	if err != nil {
+		t.Fatalf("unable to create page: %v", err)
+	}
+	bpm.Unpin(f)
+
+	for _, pageId := range []int{1, 42, InvalidPageId} {
+		_, err := bpm.GetPage(pageId)
+		assertEqual(t, true, errors.Is(err, ErrPageNotAllocated), fmt.Sprintf("page id %d: %v", pageId, err))
+	}
+	assertEqual(t, 0, bpm.PinnedPageCount(), "a rejected request does not pin a frame")
+
+	f, err = bpm.GetPage(0)
+	assertEqual(t, true, err == nil, fmt.Sprint(err))
+	assertEqual(t, 0, f.PageId, "")
+
+	// without validation, any page id is read from disk
+	bpm = NewBufferPoolManager(newRecordingDiskManager(), 4)
+	_, err = bpm.GetPage(42)
+	assertEqual(t, true, err == nil, fmt.Sprint(err))
+}
+
+func Test_blockAlignedFrames(t *testing.T) {
+	for _, blockSize := range []int{512, 4096} {
+		bpm := NewBufferPoolManager(newRecordingDiskManager(), 8, WithBlockAlignment(blockSize))
+		for _, f := range bpm.frames {
+			assertEqual(t, true, io.IsAligned(f.Data, blockSize), fmt.Sprintf("frame %d is not aligned to %d bytes", f.Id, blockSize))
+			assertEqual(t, io.PageSize, len(f.Data), "")
+		}
+	}
+}
+
+func Test_getPageWithHit(t *testing.T) {
+	dm := newRecordingDiskManager()
+	bpm := NewBufferPoolManager(dm, 2)
+	for range 3 {
+		f, _ := bpm.GetNewPageFrame()
+		f.Data[0] = byte(f.PageId + 1)
+		bpm.MarkDirty(f)
+		bpm.Unpin(f)
+	}
+
+	// page 0 was evicted to make room for page 2
+	f, wasHit, err := bpm.GetPageWithHit(0)
+	assertEqual(t, true, err == nil, fmt.Sprint(err))
+	assertEqual(t, false, wasHit, "the first access reads the page from disk")
+	assertEqual(t, byte(1), f.Data[0], "")
+	bpm.Unpin(f)
+
+	f, wasHit, err = bpm.GetPageWithHit(0)
+	assertEqual(t, true, err == nil, fmt.Sprint(err))
+	assertEqual(t, true, wasHit, "the second access is served from memory")
+	bpm.Unpin(f)
+	assertEqual(t, PoolStats{Hits: 1, Misses: 1}, bpm.Stats(), "")
+
+	// pages read into free frames each get their own frame
+	bpm = NewBufferPoolManager(dm, 4)
+	f0, _, _ := bpm.GetPageWithHit(0)
+	f1, _, _ := bpm.GetPageWithHit(1)
+	assertEqual(t, true, f0.Id != f1.Id, "")
+	assertEqual(t, byte(1), f0.Data[0], "")
+	assertEqual(t, byte(2), f1.Data[0], "")
+}
+
+func Test_tryGetPage(t *testing.T) {
+	dm := newRecordingDiskManager()
+	bpm := NewBufferPoolManager(dm, 2)
+	f0, _ := bpm.GetNewPageFrame()
+	f1, _ := bpm.GetNewPageFrame()
+	bpm.Unpin(f0)
+	bpm.Unpin(f1)
+	dm.WritePage(2, []byte{3})
+
+	// page 0 is resident
+	f, ok := bpm.TryGetPage(0)
+	assertEqual(t, true, ok, "")
+	assertEqual(t, f0, f, "")
+
+	// page 2 is not resident and can only be loaded by evicting page 1
+	f, ok = bpm.TryGetPage(2)
+	assertEqual(t, false, ok, "")
+	assertEqual(t, (*Frame)(nil), f, "")
+	assertEqual(t, 1, bpm.PinnedPageCount(), "page 1 was not evicted")
+
+	// the pool is full of pinned pages
+	bpm.Pin(f1)
+	_, ok = bpm.TryGetPage(2)
+	assertEqual(t, false, ok, "")
+	bpm.Unpin(f0)
+	bpm.Unpin(f1)
+
+	// a page is read into a free frame without evicting
+	deleted, _ := bpm.DeletePage(1)
+	assertEqual(t, true, deleted, "")
+	f, ok = bpm.TryGetPage(2)
+	assertEqual(t, true, ok, "")
+	assertEqual(t, byte(3), f.Data[0], "")
+	assertEqual(t, PoolStats{Hits: 1, Misses: 1}, bpm.Stats(), "")
+}
+
+func Test_freeFrameReuseOrder(t *testing.T) {
+	bpm := NewBufferPoolManager(newRecordingDiskManager(), 4)
+	var frames []*Frame
+	for i := range 4 {
+		f, err := bpm.GetNewPageFrame()
+		assertEqual(t, true, err == nil, fmt.Sprint(err))
+		assertEqual(t, i, f.Id, "a new pool hands out its frames in order")
+		frames = append(frames, f)
+	}
+	for _, f := range frames {
+		bpm.Unpin(f)
+	}
+
+	// the most recently freed frame is reused first
+	for _, pageId := range []int{1, 2} {
+		deleted, err := bpm.DeletePage(pageId)
+		assertEqual(t, true, deleted, fmt.Sprint(err))
+	}
+	for _, frameId := range []int{2, 1} {
+		f, err := bpm.GetNewPageFrame()
+		assertEqual(t, true, err == nil, fmt.Sprint(err))
+		assertEqual(t, frameId, f.Id, "")
+		assertEqual(t, f, bpm.frames[bpm.pageToFrame[f.PageId]], "the page table points at the reused frame")
+	}
+	assertEqual(t, 0, len(bpm.freeFrames), "")
+}
+
+func Test_freeFrameChurn(t *testing.T) {
+	const poolSize = 8
+	bpm := NewBufferPoolManager(newRecordingDiskManager(), poolSize)
+	r := rand.New(rand.NewSource(1))
+	var pageIds []int
+	for range 1000 {
+		if len(pageIds) > 0 && (len(pageIds) == poolSize || r.Intn(2) == 0) {
+			i := r.Intn(len(pageIds))
+			deleted, err := bpm.DeletePage(pageIds[i])
+			assertEqual(t, true, deleted, fmt.Sprint(err))
+			pageIds = slices.Delete(pageIds, i, i+1)
+		} else {
+			f, err := bpm.GetNewPageFrame()
+			assertEqual(t, true, err == nil, fmt.Sprint(err))
+			bpm.Unpin(f)
+			pageIds = append(pageIds, f.PageId)
+		}
+
+		// every frame either holds exactly one page or is free, and never both
+		owners := make(map[int]int)
+		for pageId, frameId := range bpm.pageToFrame {
+			owners[frameId]++
+			assertEqual(t, pageId, bpm.frames[frameId].PageId, "")
+		}
+		for _, frameId := range bpm.freeFrames {
+			owners[frameId]++
+			assertEqual(t, InvalidPageId, bpm.frames[frameId].PageId, "")
+		}
+		assertEqual(t, poolSize, len(owners), "")
+		for frameId, n := range owners {
+			assertEqual(t, 1, n, fmt.Sprintf("frame %d", frameId))
+		}
+	}
+}
+
+func Test_truncateAfterDeletingTrailingPages(t *testing.T) {
+	dm := io.NewDiskManager(t.TempDir() + "/truncate")
+	defer dm.(*io.DefaultDiskManager).Shutdown()
+	bpm := NewBufferPoolManager(dm, 8)
+
+	frames := make([]*Frame, 6)
+	for i := range frames {
+		f, err := bpm.GetNewPageFrame()
+		if err != nil {
+			t.Fatalf("unable to create page: %v", err)
+		}
+		f.Data[0] = byte(f.PageId + 1)
+		bpm.MarkDirty(f)
+		frames[i] = f
+	}
+	assertEqual(t, true, bpm.FlushAllPages(), "")
+	numPages, _ := dm.NumPages()
+	assertEqual(t, 6, numPages, "")
+
+	ok, err := bpm.DeletePage(5)
+	assertEqual(t, false, ok, "a pinned page cannot be deleted")
+	assertEqual(t, true, err == nil, fmt.Sprint(err))
+	for _, f := range frames {
+		bpm.Unpin(f)
+	}
+
+	// a page in the middle of the file keeps its space
+	for _, pageId := range []int{1, 5, 4} {
+		ok, err := bpm.DeletePage(pageId)
+		assertEqual(t, true, ok, fmt.Sprint(err))
+	}
+	_, err = bpm.DeletePage(4)
+	assertEqual(t, true, errors.Is(err, ErrPageNotAllocated), "a page cannot be deleted twice")
+
+	assertEqual(t, true, bpm.Truncate() == nil, "")
+	numPages, _ = dm.NumPages()
+	assertEqual(t, 4, numPages, "the file shrank to the highest allocated page")
+
+	buf := make([]byte, io.PageSize)
+	for _, pageId := range []int{0, 2, 3} {
+		dm.ReadPage(pageId, buf)
+		assertEqual(t, byte(pageId+1), buf[0], "remaining pages are intact")
+	}
+
+	// the page ids of the released pages are allocated again
+	f, _ := bpm.GetNewPageFrame()
+	assertEqual(t, 4, f.PageId, "")
+
+	// a pool opened over the file allocates after the existing pages
+	reopened := NewBufferPoolManager(dm, 2)
+	f, _ = reopened.GetNewPageFrame()
+	assertEqual(t, 4, f.PageId, "")
+}
+
+func Test_writeThrough(t *testing.T) {
+	path := t.TempDir() + "/write_through"
+	dm := io.NewDiskManager(path, io.WithDeferredSync())
+	defer dm.(*io.DefaultDiskManager).Shutdown()
+	bpm := NewBufferPoolManager(dm, 4, WithWriteThrough(0))
+	header, _ := bpm.GetNewPageFrame()
+	other, _ := bpm.GetNewPageFrame()
+
+	// a separate reader of the database file sees what is durable on disk
+	reader := io.NewDiskManager(path)
+	defer reader.(*io.DefaultDiskManager).Shutdown()
+	onDisk := func(pageId int) byte {
+		buf := make([]byte, io.PageSize)
+		reader.ReadPage(pageId, buf)
+		return buf[0]
+	}
+
+	bpm.SetWriteThrough(other.PageId, true)
+	bpm.SetWriteThrough(other.PageId, false)
+	bpm.Pin(header)
+	for i, f := range []*Frame{header, other} {
+		f.Data[0] = byte(i + 1)
+		bpm.MarkDirty(f)
+		bpm.Unpin(f)
+	}
+	assertEqual(t, byte(0), onDisk(header.PageId), "the page is flushed once its last pin is released")
+	bpm.Unpin(header)
+	assertEqual(t, byte(1), onDisk(header.PageId), "")
+	assertEqual(t, 1, bpm.DirtyPageCount(), "")
+	assertEqual(t, byte(0), onDisk(other.PageId), "a regular page waits for a flush")
+
+	// every later change of the page is flushed on unpin too
+	f, _ := bpm.GetPage(header.PageId)
+	f.Data[0] = 9
+	bpm.MarkDirty(f)
+	bpm.Unpin(f)
+	assertEqual(t, byte(9), onDisk(header.PageId), "")
+}
+
+func Test_cleanPageEviction(t *testing.T) {
+	dm := newRecordingDiskManager()
+	bpm := NewBufferPoolManager(dm, 2, WithCleanPageEviction())
+	dirty, _ := bpm.GetNewPageFrame()
+	bpm.MarkDirty(dirty)
+	bpm.Unpin(dirty)
+	clean, _ := bpm.GetNewPageFrame()
+	bpm.Unpin(clean)
+
+	f, err := bpm.GetNewPageFrame()
+	assertEqual(t, true, err == nil, fmt.Sprint(err))
+	assertEqual(t, clean.Id, f.Id, "the clean page is evicted")
+	assertEqual(t, 0, len(dm.writes), "no page was flushed to make room")
+}
+
+func Test_evictionCost(t *testing.T) {
+	newPool := func(dirtyCost int) (*BufferPoolManager, *recordingDiskManager, *[]int) {
+		dm := newRecordingDiskManager()
+		var evicted []int
+		bpm := NewBufferPoolManager(dm, 4,
+			WithEvictionCost(func(f *Frame) int {
+				if f.IsDirty {
+					return dirtyCost
+				}
+				return 0
+			}),
+			WithOnEvict(func(pageId, frameId int, wasDirty bool) {
+				evicted = append(evicted, pageId)
+			}))
+		// pages 0 and 1 are the coldest and dirty, pages 2 and 3 are clean
+		for p := range 4 {
+			f, _ := bpm.GetNewPageFrame()
+			if p < 2 {
+				bpm.MarkDirty(f)
+			}
+			bpm.Unpin(f)
+		}
+		return bpm, dm, &evicted
+	}
+
+	// dirty frames that are strongly penalized stay resident while clean frames are evicted
+	bpm, dm, evicted := newPool(100)
+	for range 4 {
+		f, err := bpm.GetNewPageFrame()
+		assertEqual(t, true, err == nil, fmt.Sprint(err))
+		bpm.Unpin(f)
+	}
+	assertEqual(t, true, slices.Equal([]int{2, 3, 4, 5}, *evicted), fmt.Sprint(*evicted))
+	assertEqual(t, 0, len(dm.writes), "no dirty page was flushed")
+
+	// a small cost is outweighed by recency: the coldest dirty frame scores as much as the
+	// next clean frame, and the colder frame is evicted
+	bpm, dm, evicted = newPool(1)
+	f, _ := bpm.GetNewPageFrame()
+	bpm.Unpin(f)
+	assertEqual(t, true, slices.Equal([]int{0}, *evicted), fmt.Sprint(*evicted))
+	assertEqual(t, 1, len(dm.writes), "the dirty page was flushed")
+}
+
+func Test_onEvict(t *testing.T) {
+	type eviction struct {
+		pageId, frameId int
+		wasDirty        bool
+	}
+	var evictions []eviction
+	bpm := NewBufferPoolManager(newRecordingDiskManager(), 2, WithOnEvict(func(pageId, frameId int, wasDirty bool) {
+		evictions = append(evictions, eviction{pageId, frameId, wasDirty})
+	}))
+	dirty, _ := bpm.GetNewPageFrame()
+	bpm.MarkDirty(dirty)
+	bpm.Unpin(dirty)
+	clean, _ := bpm.GetNewPageFrame()
+	bpm.Unpin(clean)
+	assertEqual(t, 0, len(evictions), "free frames are used before evicting")
+
+	f, _ := bpm.GetNewPageFrame()
+	bpm.Unpin(f)
+	f, _ = bpm.GetNewPageFrame()
+	bpm.Unpin(f)
+	assertEqual(t, 2, len(evictions), "")
+	assertEqual(t, eviction{pageId: 0, frameId: 0, wasDirty: true}, evictions[0], "")
+	assertEqual(t, eviction{pageId: 1, frameId: 1, wasDirty: false}, evictions[1], "")
+}
+
+// An in-memory disk manager that records the order in which pages are written.
+type recordingDiskManager struct {
+	pages  map[int][]byte
+	writes []int
+}
+
+func newRecordingDiskManager() *recordingDiskManager {
+	return &recordingDiskManager{pages: make(map[int][]byte)}
+}
+
+func (d *recordingDiskManager) WritePage(pageId int, data []byte) error {
+	d.pages[pageId] = append([]byte(nil), data...)
+	d.writes = append(d.writes, pageId)
+	return nil
+}
+
+func (d *recordingDiskManager) ReadPage(pageId int, buf []byte) error {
+	copy(buf, d.pages[pageId])
+	return nil
+}
+
+func (d *recordingDiskManager) NumPages() (int, error) {
+	n := 0
+	for pageId := range d.pages {
+		n = max(n, pageId+1)
+	}
+	return n, nil
+}
+
+func (d *recordingDiskManager) Truncate(numPages int) error {
+	for pageId := range d.pages {
+		if pageId >= numPages {
+			delete(d.pages, pageId)
+		}
+	}
+	return nil
+}
+
+func (d *recordingDiskManager) Sync() error {
+	return nil
+}
+
+var _ io.DiskManager = (*recordingDiskManager)(nil)
+
+// Takes every frame of a large pool off the free frames and returns it again, so that
+// each page access is served from a free frame.
+func Benchmark_getPageFromFreeFrames(b *testing.B) {
+	const poolSize = 4096
+	bpm := NewBufferPoolManager(newRecordingDiskManager(), poolSize)
+	b.ReportAllocs()
+	pageIds := make([]int, 0, poolSize)
+	for i := range b.N {
+		f, err := bpm.GetNewPageFrame()
+		if err != nil {
+			b.Fatal(err)
+		}
+		bpm.Unpin(f)
+		pageIds = append(pageIds, f.PageId)
+		if len(pageIds) == poolSize || i == b.N-1 {
+			for _, pageId := range pageIds {
+				bpm.DeletePage(pageId)
+			}
+			pageIds = pageIds[:0]
+		}
+	}
+}
+
+// Keeps a large pool full and then frees and allocates a page at a time, so that every
+// allocation takes the frame the previous deletion returned.
+func Benchmark_allocateFreeChurn(b *testing.B) {
+	const poolSize = 4096
+	bpm := NewBufferPoolManager(newRecordingDiskManager(), poolSize)
+	pageIds := make([]int, 0, poolSize)
+	for range poolSize {
+		f, err := bpm.GetNewPageFrame()
+		if err != nil {
+			b.Fatal(err)
+		}
+		bpm.Unpin(f)
+		pageIds = append(pageIds, f.PageId)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		slot := i % poolSize
+		if _, err := bpm.DeletePage(pageIds[slot]); err != nil {
+			b.Fatal(err)
+		}
+		f, err := bpm.GetNewPageFrame()
+		if err != nil {
+			b.Fatal(err)
+		}
+		bpm.Unpin(f)
+		pageIds[slot] = f.PageId
+	}
+}
+
+func Test_restoreInvalidDump(t *testing.T) {
+	bpm := NewBufferPoolManager(newRecordingDiskManager(), 4)
+	f, _ := bpm.GetNewPageFrame()
+	copy(f.Data, "page zero")
+	bpm.Unpin(f)
+	var dump bytes.Buffer
+	assertEqual(t, nil, bpm.DumpFile(&dump), "")
+
+	dm := newRecordingDiskManager()
+	assertEqual(t, nil, RestoreFile(bytes.NewReader(dump.Bytes()), dm), "")
+	assertEqual(t, "page zero", string(dm.pages[0][:9]), "")
+
+	corrupt := slices.Clone(dump.Bytes())
+	corrupt[len(corrupt)-5] ^= 1 // the last byte of the page data
+	err := RestoreFile(bytes.NewReader(corrupt), newRecordingDiskManager())
+	assertEqual(t, true, errors.Is(err, ErrInvalidDump), fmt.Sprint(err))
+	err = RestoreFile(bytes.NewReader(dump.Bytes()[:dump.Len()-1]), newRecordingDiskManager())
+	assertEqual(t, true, errors.Is(err, ErrInvalidDump), fmt.Sprint(err))
+	err = RestoreFile(strings.NewReader("not a dump at all"), newRecordingDiskManager())
+	assertEqual(t, true, errors.Is(err, ErrInvalidDump), fmt.Sprint(err))
+}
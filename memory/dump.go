@@ -0,0 +1,98 @@
+package memory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	stdio "io"
+	"wtfDB/io"
+)
+
+// The magic bytes at the start of a dump, followed by the format version and the page size.
+var dumpMagic = []byte("wtfdump")
+
+const (
+	dumpVersion    = 1
+	dumpHeaderSize = 7 + 1 + 4           // magic, version, page size
+	dumpFrameSize  = 8 + io.PageSize + 4 // page id, page data, crc32 of the page data
+)
+
+var ErrInvalidDump = fmt.Errorf("invalid database dump")
+
+/*
+DumpFile writes a physical backup of the database file to w: every allocated page, with its
+page id, in ascending page id order. Deleted pages are skipped. A page that is in the buffer
+pool is dumped from its frame, so dirty pages need not be flushed first; see ReadPageUncached.
+The pages are not latched, so writes to the pool must be stopped for the dump to be consistent.
+
+The dump starts with a header of the magic bytes "wtfdump", a format version byte and the
+page size (4 bytes), followed by one frame per page: the page id (8 bytes), the page data and
+a CRC-32 (IEEE) checksum of the page data (4 bytes). Integers are big endian.
+*/
+func (m *BufferPoolManager) DumpFile(w stdio.Writer) error {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, 0, dumpHeaderSize)
+	header = append(header, dumpMagic...)
+	header = append(header, dumpVersion)
+	header = binary.BigEndian.AppendUint32(header, io.PageSize)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	buf := m.PageBuffer()
+	frame := make([]byte, 0, dumpFrameSize)
+	for _, pageId := range m.AllocatedPageIds() {
+		if err := m.ReadPageUncached(pageId, buf); err != nil {
+			return err
+		}
+		frame = binary.BigEndian.AppendUint64(frame[:0], uint64(pageId))
+		frame = append(frame, buf...)
+		frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(buf))
+		if _, err := bw.Write(frame); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+/*
+RestoreFile writes the pages of a dump made by DumpFile to disk manager dm, each under its
+own page id, so the restored file has the exact page layout of the dumped one, and syncs dm.
+Pages that were deleted when the file was dumped read as zeroes. Returns an error wrapping
+ErrInvalidDump if the dump is malformed, truncated or fails a checksum; the pages before the
+bad frame have been written by then.
+*/
+func RestoreFile(r stdio.Reader, dm io.DiskManager) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, dumpHeaderSize)
+	if _, err := stdio.ReadFull(br, header); err != nil {
+		return fmt.Errorf("%w: unable to read the header: %v", ErrInvalidDump, err)
+	}
+	if !bytes.Equal(header[:len(dumpMagic)], dumpMagic) || header[len(dumpMagic)] != dumpVersion {
+		return fmt.Errorf("%w: unknown format", ErrInvalidDump)
+	}
+	if pageSize := binary.BigEndian.Uint32(header[len(dumpMagic)+1:]); pageSize != io.PageSize {
+		return fmt.Errorf("%w: page size %d, expected %d", ErrInvalidDump, pageSize, io.PageSize)
+	}
+	frame := make([]byte, dumpFrameSize)
+	// aligned for disk managers that require aligned buffers, see io.WithAlignment
+	data := io.AlignedBuffer(io.PageSize, 4096)
+	for {
+		if _, err := stdio.ReadFull(br, frame); errors.Is(err, stdio.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("%w: truncated page frame: %v", ErrInvalidDump, err)
+		}
+		pageId := int(binary.BigEndian.Uint64(frame))
+		copy(data, frame[8:8+io.PageSize])
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(frame[8+io.PageSize:]) {
+			return fmt.Errorf("%w: checksum mismatch on page %d", ErrInvalidDump, pageId)
+		}
+		if err := dm.WritePage(pageId, data); err != nil {
+			return fmt.Errorf("unable to restore page %d: %w", pageId, err)
+		}
+	}
+	return dm.Sync()
+}
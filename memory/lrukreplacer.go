@@ -1,9 +1,11 @@
 package memory
 
 import (
+	"cmp"
 	"container/list"
 	"fmt"
 	"math"
+	"slices"
 	"time"
 )
 
@@ -30,6 +32,8 @@ type LruKReplacer struct {
 	size          int                             // tracks the number of evictable frames
 	metadataStore map[int]LruKFrameAccessMetadata // map of frame id to lru-k frame metadata
 	lru           *list.List                      // doubly-linked list between frames in ascending access/use order
+	isClean       func(frameId int) bool          // optional eviction hint, evict clean frames before equally cold dirty frames
+	cost          func(frameId int) int           // optional eviction cost of a frame, blended with recency, see WithEvictionCost
 }
 
 var ErrorAllFramesArePinned = fmt.Errorf("cannot evict anything -- everything is pinned")
@@ -56,7 +60,12 @@ Successful eviction of a frame decrements the size of replacer and removes the f
 access history.
 */
 func (lruK *LruKReplacer) evict() (int, error) {
-	frameId := lruK.maxBackwardKDistance()
+	frameId := -1
+	if lruK.cost != nil {
+		frameId = lruK.minEvictionScore()
+	} else {
+		frameId = lruK.maxBackwardKDistance()
+	}
 	if frameId == -1 {
 		return -1, ErrorAllFramesArePinned
 	}
@@ -137,10 +146,9 @@ func (lruK *LruKReplacer) remove(frameId int) error {
 	if !m.isEvictable {
 		return fmt.Errorf("attempting to remove a non-evictable frame")
 	}
-	v := lruK.lru.Remove(m.e)
+	lruK.lru.Remove(m.e)
 	delete(lruK.metadataStore, frameId)
 	lruK.size--
-	fmt.Printf("remove access history for element: %+v\n", v)
 	return nil
 }
 
@@ -160,7 +168,6 @@ func (lruK *LruKReplacer) maxBackwardKDistance() int {
 	// Set breakTie flag to true, if there exists at least two frames with equal max backward k-distance
 	for k := range lruK.metadataStore {
 		if !lruK.metadataStore[k].isEvictable {
-			fmt.Println("frame is non-evictable, ignore")
 			// fmt.Printf("lruK frame: %+v", lruK.metadataStore[k])
 			continue
 		}
@@ -179,6 +186,37 @@ func (lruK *LruKReplacer) maxBackwardKDistance() int {
 	return frameId
 }
 
+/*
+Returns the frame id of the evictable frame with the lowest eviction score, -1 if all frames
+are pinned. The evictable frames are ranked by recency in LRU-K order, the frame that would
+be evicted first having rank 0, and a frame's score is its rank plus its eviction cost.
+Among frames with the same score, the colder frame is evicted.
+*/
+func (lruK *LruKReplacer) minEvictionScore() int {
+	// the lru list runs from the least recently used frame, which breaks ties of the backward k-distance
+	candidates := make([]int, 0, lruK.size)
+	for curr := lruK.lru.Front(); curr != nil; curr = curr.Next() {
+		if frameId, ok := curr.Value.(int); ok && lruK.metadataStore[frameId].isEvictable {
+			candidates = append(candidates, frameId)
+		}
+	}
+	distances := make(map[int]int, len(candidates))
+	for _, frameId := range candidates {
+		distances[frameId] = lruK.getBackwardKDistance(frameId)
+	}
+	slices.SortStableFunc(candidates, func(a, b int) int {
+		return cmp.Compare(distances[b], distances[a])
+	})
+
+	frameId, minScore := -1, math.MaxInt
+	for rank, candidate := range candidates {
+		if score := rank + lruK.cost(candidate); score < minScore {
+			frameId, minScore = candidate, score
+		}
+	}
+	return frameId
+}
+
 // Calculate the backward k-distance of the frame/page with the given frame id.
 // Backward k-distance is the difference between the current timestamp and
 // the timestamp of kth previous access.
@@ -201,7 +239,20 @@ func (lruK *LruKReplacer) getBackwardKDistance(frameId int) int {
 
 // Return the frame id of the frame that has been least recently used.
 // If all frames are pinned, return an error.
+// With the isClean hint set, the least recently used clean frame is preferred, since
+// evicting it does not require flushing the frame's page to disk first.
 func (lruK *LruKReplacer) getLRUFrame(backwardKDist int) int {
+	if lruK.isClean != nil {
+		for curr := lruK.lru.Front(); curr != nil; curr = curr.Next() {
+			if frameId, ok := curr.Value.(int); ok {
+				if lruK.metadataStore[frameId].isEvictable &&
+					lruK.getBackwardKDistance(frameId) == backwardKDist &&
+					lruK.isClean(frameId) {
+					return frameId
+				}
+			}
+		}
+	}
 	curr := lruK.lru.Front()
 	for curr != nil {
 		if frameId, ok := curr.Value.(int); ok {
@@ -217,8 +268,7 @@ func (lruK *LruKReplacer) getLRUFrame(backwardKDist int) int {
 }
 
 func (lruK *LruKReplacer) cleanup(frameId int) {
-	v := lruK.lru.Remove(lruK.metadataStore[frameId].e)
+	lruK.lru.Remove(lruK.metadataStore[frameId].e)
 	delete(lruK.metadataStore, frameId)
 	lruK.size--
-	fmt.Printf("removed lruk element from lru-K replacer: %+v\n", v)
 }
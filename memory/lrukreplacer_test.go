@@ -132,6 +132,28 @@ func Test_recordAndEvict(t *testing.T) {
 
 }
 
+func Test_evictPrefersCleanFrames(t *testing.T) {
+	dirty := map[int]bool{1: true, 3: true}
+	for _, preferClean := range []bool{false, true} {
+		lruK := NewLruKReplacer()
+		if preferClean {
+			lruK.isClean = func(frameId int) bool { return !dirty[frameId] }
+		}
+		// all frames have fewer than k accesses, so they are equally cold
+		for frameId := 1; frameId <= 3; frameId++ {
+			lruK.recordAccess(frameId)
+			lruK.setEvictable(frameId, true)
+		}
+
+		fid, err := lruK.evict()
+		if preferClean {
+			assertEqual(t, 2, fid, errMessage(err))
+		} else {
+			assertEqual(t, 1, fid, errMessage(err))
+		}
+	}
+}
+
 func assertEqual[T comparable](t *testing.T, expected T, actual T, msg string) {
 	t.Helper()
 	if expected == actual {